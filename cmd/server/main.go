@@ -1,77 +1,367 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"sing-box-manager/internal/api"
+	"sing-box-manager/internal/logging"
+	"sing-box-manager/internal/metrics"
+	"sing-box-manager/internal/models"
 	"sing-box-manager/internal/service"
 	"sing-box-manager/internal/storage"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	// 初始化日志，后续所有输出均为JSON格式
+	logging.Setup(os.Stdout, getEnv("LOG_LEVEL", "info"))
+	
 	// 获取配置
 	port := getEnv("PORT", "8080")
 	dataFile := getEnv("DATA_FILE", "data/users.json")
 	configPath := getEnv("SINGBOX_CONFIG", "configs/sing-box.json")
 	templatePath := getEnv("SINGBOX_TEMPLATE", "configs/sing-box-template.json")
 	serverName := getEnv("SERVER_NAME", "example.com")
+	healthProbeHost := getEnv("HEALTH_PROBE_HOST", "127.0.0.1")
+	apiKeysFile := getEnv("API_KEYS_FILE", "data/api_keys.json")
+	adminsFile := getEnv("ADMINS_FILE", "data/admins.json")
+	sessionsFile := getEnv("SESSIONS_FILE", "data/sessions.json")
+	jwtSecretFile := getEnv("JWT_SECRET_FILE", "data/jwt_secret")
+	auditLogFile := getEnv("AUDIT_LOG_FILE", "data/audit.jsonl")
+	subscriptionBaseURL := getEnv("SUBSCRIPTION_BASE_URL", "")
+	subscriptionProfileName := getEnv("SUBSCRIPTION_PROFILE_NAME", "sing-box-manager")
+	subscriptionUpdateInterval := getIntEnv("SUBSCRIPTION_UPDATE_INTERVAL", 24)
+	subscriptionLogFile := getEnv("SUBSCRIPTION_LOG_FILE", "data/subscription_access.jsonl")
+	subscriptionMaxIPs := getIntEnv("SUBSCRIPTION_MAX_IPS_PER_DAY", 0)
+	subscriptionLogRetention := getDurationEnv("SUBSCRIPTION_LOG_RETENTION", 30*24*time.Hour)
+	accessTokenTTL := getDurationEnv("JWT_ACCESS_TTL", 15*time.Minute)
+	refreshTokenTTL := getDurationEnv("JWT_REFRESH_TTL", 7*24*time.Hour)
+	corsAllowOrigins := getEnv("CORS_ALLOW_ORIGINS", "*")
+	trustedProxies := getListEnv("TRUSTED_PROXIES")
+	// 指标端点的单独监听地址，为空时指标挂在API端口上并需要认证
+	metricsListen := getEnv("METRICS_LISTEN", "")
+	nodesFile := getEnv("NODES_FILE", "data/nodes.json")
+	plansFile := getEnv("PLANS_FILE", "data/plans.json")
+	ledgerFile := getEnv("LEDGER_FILE", "data/ledger.jsonl")
+	vouchersFile := getEnv("VOUCHERS_FILE", "data/vouchers.json")
+	trialsFile := getEnv("TRIALS_FILE", "data/trials.json")
+	trialPlanID := getEnv("TRIAL_PLAN_ID", "")
+	trialIPLimit := getIntEnv("TRIAL_IP_LIMIT", 2)
+	trialIPWindow := getDurationEnv("TRIAL_IP_WINDOW", 24*time.Hour)
+	localNodeEnabled := getEnv("LOCAL_NODE_ENABLED", "true") == "true"
+	nodeTrafficFile := getEnv("NODE_TRAFFIC_FILE", "data/node_traffic.json")
+	nodeOfflineAfter := getDurationEnv("NODE_OFFLINE_AFTER", 3*time.Minute)
+	nodeGroups := getListEnv("NODE_GROUPS")
+	singBoxManaged := getEnv("SINGBOX_MANAGED", "false") == "true"
+	singBoxLogFile := getEnv("SINGBOX_LOG_FILE", "logs/sing-box.log")
+	singBoxLogMaxSize := getIntEnv("SINGBOX_LOG_MAX_SIZE_MB", 10)
+	singBoxLogMaxFiles := getIntEnv("SINGBOX_LOG_MAX_FILES", 5)
+	singBoxLogBuffer := getIntEnv("SINGBOX_LOG_BUFFER", 1000)
+	deviceIdleTimeout := getDurationEnv("DEVICE_IDLE_TIMEOUT", 10*time.Minute)
+	clashAPIURL := getEnv("CLASH_API_URL", "")
+	clashAPISecret := getEnv("CLASH_API_SECRET", "")
+	deviceViolationLimit := getIntEnv("DEVICE_VIOLATION_LIMIT", 0)
+	deviceViolationWindow := getDurationEnv("DEVICE_VIOLATION_WINDOW", time.Hour)
+	deviceSuspendDuration := getDurationEnv("DEVICE_SUSPEND_DURATION", 30*time.Minute)
+	// 用于按用户限速的网卡，为空时只保存限速设置而不生效
+	speedLimitInterface := getEnv("SPEED_LIMIT_INTERFACE", "")
+	
+	// 运行模式：manager为中心管理端，agent从中心管理端同步用户并上报流量
+	mode := getEnv("MODE", "manager")
+	if mode != "manager" && mode != "agent" {
+		fatal("Invalid MODE, expected manager or agent", "mode", mode)
+	}
 	
 	// 初始化存储
 	jsonStorage := storage.NewJSONStorage(dataFile)
+	apiKeyStorage, err := storage.NewAPIKeyStorage(apiKeysFile)
+	if err != nil {
+		fatal("Failed to initialize api key storage", "error", err)
+	}
+	adminStorage, err := storage.NewAdminStorage(adminsFile)
+	if err != nil {
+		fatal("Failed to initialize admin storage", "error", err)
+	}
+	sessionStorage, err := storage.NewSessionStorage(sessionsFile)
+	if err != nil {
+		fatal("Failed to initialize session storage", "error", err)
+	}
+	auditStorage, err := storage.NewAuditStorage(auditLogFile)
+	if err != nil {
+		fatal("Failed to initialize audit storage", "error", err)
+	}
+	subscriptionLogStorage, err := storage.NewSubscriptionLogStorage(subscriptionLogFile)
+	if err != nil {
+		fatal("Failed to initialize subscription log storage", "error", err)
+	}
+	nodeStorage, err := storage.NewNodeStorage(nodesFile)
+	if err != nil {
+		fatal("Failed to initialize node storage", "error", err)
+	}
+	nodeTrafficStorage, err := storage.NewNodeTrafficStorage(nodeTrafficFile)
+	if err != nil {
+		fatal("Failed to initialize node traffic storage", "error", err)
+	}
+	planStorage, err := storage.NewPlanStorage(plansFile)
+	if err != nil {
+		fatal("Failed to initialize plan storage", "error", err)
+	}
+	ledgerStorage, err := storage.NewLedgerStorage(ledgerFile)
+	if err != nil {
+		fatal("Failed to initialize ledger storage", "error", err)
+	}
+	voucherStorage, err := storage.NewVoucherStorage(vouchersFile)
+	if err != nil {
+		fatal("Failed to initialize voucher storage", "error", err)
+	}
+	trialStorage, err := storage.NewTrialStorage(trialsFile)
+	if err != nil {
+		fatal("Failed to initialize trial storage", "error", err)
+	}
+	singBoxLogWriter, err := logging.NewRotatingFile(singBoxLogFile, int64(singBoxLogMaxSize)*1024*1024, singBoxLogMaxFiles)
+	if err != nil {
+		fatal("Failed to open sing-box log file", "error", err)
+	}
+	
+	// JWT签名密钥，未通过环境变量指定时自动生成并持久化
+	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		if jwtSecret, err = storage.LoadOrCreateSecret(jwtSecretFile, 32); err != nil {
+			fatal("Failed to load jwt secret", "error", err)
+		}
+	}
 	
 	// 初始化服务
-	userService := service.NewUserService(jsonStorage)
-	configService := service.NewConfigService(jsonStorage, configPath, templatePath, serverName)
+	auditService := service.NewAuditService(auditStorage)
+	userService := service.NewUserService(jsonStorage, planStorage, ledgerStorage, auditService)
+	configService := service.NewConfigService(jsonStorage, auditService, configPath, templatePath, serverName, nodeGroups)
+	userService.SetConfigService(configService)
+	singBoxLogService := service.NewSingBoxLogService(singBoxLogBuffer, singBoxLogWriter)
+	configService.SetSingBoxOutput(singBoxLogService)
+	
+	// 开启Clash API后可以查询和关闭sing-box的活动连接
+	var clashAPI *service.ClashAPIClient
+	if clashAPIURL != "" {
+		clashAPI = service.NewClashAPIClient(clashAPIURL, clashAPISecret)
+		controller, err := clashAPI.ListenAddress()
+		if err != nil {
+			fatal("Invalid CLASH_API_URL", "error", err)
+		}
+		configService.SetClashAPI(controller, clashAPISecret)
+	}
+	healthService := service.NewHealthService(jsonStorage, configService, healthProbeHost)
+	nodeService := service.NewNodeService(nodeStorage, nodeTrafficStorage, userService, configService, auditService, nodeOfflineAfter)
+	subscriptionService := service.NewSubscriptionService(userService, configService, nodeService, localNodeEnabled, subscriptionLogStorage, subscriptionMaxIPs)
+	authService := service.NewAuthService(apiKeyStorage, adminStorage)
+	adminService := service.NewAdminService(adminStorage, sessionStorage)
+	sessionService := service.NewSessionService(adminStorage, sessionStorage, jwtSecret, accessTokenTTL, refreshTokenTTL)
+	deviceService := service.NewDeviceService(userService)
+	planService := service.NewPlanService(planStorage, userService, configService, auditService)
+	voucherService := service.NewVoucherService(voucherStorage, planService, userService, adminService, auditService)
+	trialService := service.NewTrialService(trialStorage, planService, userService, adminService, auditService, jwtSecret, trialPlanID, trialIPLimit, trialIPWindow)
+	
+	// 首次启动时创建初始管理员和API密钥
+	if err := authService.Bootstrap(os.Getenv("ADMIN_API_KEY"), os.Getenv("ADMIN_PASSWORD")); err != nil {
+		fatal("Failed to bootstrap api key", "error", err)
+	}
 	
-	// 生成初始配置
-	if err := configService.GenerateConfig(); err != nil {
-		log.Printf("Warning: Failed to generate initial config: %v", err)
+	// 为旧用户补发订阅令牌
+	if err := userService.EnsureSubscriptionTokens(); err != nil {
+		slog.Warn("Failed to issue subscription tokens", "error", err)
+	}
+	
+	// 定期删除超过保留时长的订阅拉取记录，0表示不删除
+	if subscriptionLogRetention > 0 {
+		go subscriptionService.RunLogRetention(subscriptionLogRetention)
+	}
+	
+	if mode == "agent" {
+		// agent模式下用户和入站定义由中心管理端下发，首次同步后生成配置
+		agentService, err := newAgentService(jsonStorage, configService)
+		if err != nil {
+			fatal("Failed to initialize agent", "error", err)
+		}
+		go agentService.Run()
+	} else {
+		// 生成初始配置
+		if err := configService.GenerateConfig(context.Background()); err != nil {
+			slog.Warn("Failed to generate initial config", "error", err)
+		}
+		
+		// 停用到期或流量用尽的用户、按周期重置流量并恢复停用期结束的用户，agent的用户数据由中心管理端下发
+		go service.NewUserMaintenance(userService, configService).Run()
+		
+		// 由管理进程启动sing-box以便采集其日志，agent模式在首次同步后启动
+		if singBoxManaged {
+			go configService.StartSingBox(context.Background())
+		}
+	}
+	
+	// 从sing-box连接日志中识别用户设备，需要由管理进程启动sing-box才能采集到日志
+	if singBoxManaged {
+		deviceTracker := service.NewDeviceTracker(singBoxLogService, userService, subscriptionService, deviceIdleTimeout)
+		go deviceTracker.Run()
+		deviceService.SetConnectionControl(clashAPI, deviceTracker)
+		
+		// 超出设备数限制时通过Clash API关闭连接；agent的用户数据由中心管理端下发，只关闭连接不停用用户
+		if clashAPI != nil {
+			if mode == "agent" {
+				deviceViolationLimit = 0
+			}
+			enforcer := service.NewDeviceLimitEnforcer(clashAPI, deviceTracker, userService, configService, auditService, deviceViolationLimit, deviceViolationWindow, deviceSuspendDuration)
+			go enforcer.Run()
+		}
+		
+		// 按设备追踪识别出的来源IP通过tc限制用户带宽
+		if speedLimitInterface != "" {
+			go service.NewSpeedLimiter(speedLimitInterface, deviceTracker, userService).Run()
+		}
+	} else if speedLimitInterface != "" {
+		slog.Warn("SPEED_LIMIT_INTERFACE requires SINGBOX_MANAGED=true, speed limits are not enforced")
 	}
 	
 	// 启动配置自动重载
 	go configService.AutoReloadConfig()
 	
 	// 初始化API处理器
-	userHandler := api.NewUserHandler(userService)
-	configHandler := api.NewConfigHandler(configService)
+	authMiddleware := api.NewAuthMiddleware(authService, sessionService)
+	userHandler := api.NewUserHandler(userService, deviceService, adminService, authMiddleware)
+	configHandler := api.NewConfigHandler(configService, authMiddleware)
+	healthHandler := api.NewHealthHandler(healthService)
+	apiKeyHandler := api.NewAPIKeyHandler(authService, authMiddleware)
+	adminHandler := api.NewAdminHandler(adminService, authMiddleware)
+	sessionHandler := api.NewSessionHandler(sessionService, authMiddleware)
+	auditHandler := api.NewAuditHandler(auditService, authMiddleware)
+	nodeHandler := api.NewNodeHandler(nodeService, authMiddleware)
+	singBoxHandler := api.NewSingBoxHandler(singBoxLogService, authMiddleware)
+	subscriptionHandler := api.NewSubscriptionHandler(subscriptionService, userService, authMiddleware, subscriptionBaseURL, subscriptionProfileName, subscriptionUpdateInterval)
+	portalHandler := api.NewPortalHandler(subscriptionService, deviceService)
+	planHandler := api.NewPlanHandler(planService, authMiddleware)
+	voucherHandler := api.NewVoucherHandler(voucherService, authMiddleware)
+	trialHandler := api.NewTrialHandler(trialService, authMiddleware, subscriptionBaseURL)
 	
 	// 设置Gin模式
 	if getEnv("GIN_MODE", "debug") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	
-	// 创建路由
-	router := gin.Default()
+	// 创建路由，访问日志由logging中间件统一输出
+	router := gin.New()
+	
+	// 只信任指定代理的X-Forwarded-For，未配置时客户端IP取自连接地址，防止伪造IP绕过按IP的限制
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", "error", err)
+	}
 	
 	// 添加中间件
-	router.Use(corsMiddleware())
-	router.Use(gin.Logger())
+	router.Use(logging.Middleware())
 	router.Use(gin.Recovery())
+	router.Use(corsMiddleware(corsAllowOrigins))
+	router.Use(metrics.Middleware())
+	if mode == "agent" {
+		router.Use(agentReadOnlyMiddleware())
+	}
 	
 	// 健康检查端点
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "ok",
-			"service": "sing-box-manager",
-		})
-	})
+	healthHandler.RegisterRoutes(router)
+	
+	// Prometheus指标端点，配置了单独的监听地址时不需要认证，否则需要管理员的metrics:read权限
+	prometheus.MustRegister(service.NewMetricsCollector(userService, nodeService, configService))
+	if metricsListen != "" {
+		go serveMetrics(metricsListen)
+	} else {
+		router.GET("/metrics", authMiddleware.Authenticate(), authMiddleware.RequireScope(models.ScopeMetricsRead), authMiddleware.RequireRole(models.RoleAdmin), gin.WrapH(promhttp.Handler()))
+	}
 	
 	// 注册用户路由
 	userHandler.RegisterRoutes(router)
 	
+	// 注册套餐路由
+	planHandler.RegisterRoutes(router)
+	
+	// 注册兑换码路由
+	voucherHandler.RegisterRoutes(router)
+	
+	// 注册试用路由
+	trialHandler.RegisterRoutes(router)
+	
 	// 注册配置路由
 	configHandler.RegisterRoutes(router)
 	
+	// 注册API密钥管理路由
+	apiKeyHandler.RegisterRoutes(router)
+	
+	// 注册管理员账号路由
+	adminHandler.RegisterRoutes(router)
+	
+	// 注册登录会话路由
+	sessionHandler.RegisterRoutes(router)
+	
+	// 注册审计日志路由
+	auditHandler.RegisterRoutes(router)
+	
+	// 注册订阅路由
+	subscriptionHandler.RegisterRoutes(router)
+	
+	// 注册用户自助门户路由
+	portalHandler.RegisterRoutes(router)
+	
+	// 注册节点管理和agent同步路由
+	nodeHandler.RegisterRoutes(router)
+	
+	// 注册sing-box日志路由
+	singBoxHandler.RegisterRoutes(router)
+	
 	// 启动服务器
-	log.Printf("Starting server on port %s", port)
+	slog.Info("Starting server", "port", port, "mode", mode)
 	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+		fatal("Failed to start server", "error", err)
+	}
+}
+
+// serveMetrics 在单独的地址上提供Prometheus指标，该地址应只对监控系统开放
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	slog.Info("Starting metrics server", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fatal("Failed to start metrics server", "error", err)
 	}
 }
 
+// fatal 记录错误日志并退出进程
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newAgentService 根据环境变量创建agent同步服务
+func newAgentService(jsonStorage *storage.JSONStorage, configService *service.ConfigService) (*service.AgentService, error) {
+	managerURL := os.Getenv("MANAGER_URL")
+	nodeToken := os.Getenv("NODE_TOKEN")
+	if managerURL == "" || nodeToken == "" {
+		return nil, fmt.Errorf("MANAGER_URL and NODE_TOKEN are required in agent mode")
+	}
+	
+	stateStorage, err := storage.NewAgentStateStorage(getEnv("AGENT_STATE_FILE", "data/agent_state.json"))
+	if err != nil {
+		return nil, err
+	}
+	
+	syncInterval := getDurationEnv("AGENT_SYNC_INTERVAL", time.Minute)
+	return service.NewAgentService(managerURL, nodeToken, jsonStorage, stateStorage, configService, syncInterval), nil
+}
+
 // getEnv 获取环境变量，如果不存在则使用默认值
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -80,12 +370,86 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// corsMiddleware CORS中间件
-func corsMiddleware() gin.HandlerFunc {
+// getListEnv 获取逗号分隔的列表类型环境变量，不存在时返回nil
+func getListEnv(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// getIntEnv 获取整数类型的环境变量，无效时使用默认值
+func getIntEnv(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		slog.Warn("Invalid integer environment variable, using default", "key", key, "value", value, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+// getDurationEnv 获取时长类型的环境变量，格式如 15m、168h，无效时使用默认值
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		slog.Warn("Invalid duration environment variable, using default", "key", key, "value", value, "default", defaultValue.String())
+	}
+	return defaultValue
+}
+
+// agentManagedRoutes agent模式下由管理端同步覆盖的修改接口
+// 流量上报和设备接口记录的是本节点的数据，不在此列
+var agentManagedRoutes = map[string]bool{
+	"POST /api/users":            true,
+	"PUT /api/users/:id":         true,
+	"DELETE /api/users/:id":      true,
+	"POST /api/users/:id/renew":  true,
+	"POST /api/users/:id/topup":  true,
+	"POST /api/plans":            true,
+	"PUT /api/plans/:id":         true,
+	"DELETE /api/plans/:id":      true,
+	"POST /api/vouchers":         true,
+	"DELETE /api/vouchers/:code": true,
+	"POST /api/trials":           true,
+	"POST /api/trials/invites":   true,
+	"POST /trial":                true,
+	"POST /redeem":               true,
+}
+
+// agentReadOnlyMiddleware agent模式下拒绝修改用户和套餐，这些数据以管理端为准，本地修改会在下次同步时被覆盖
+func agentReadOnlyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		if agentManagedRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "users and plans are managed by the manager in agent mode",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// corsMiddleware CORS中间件，allowOrigins为逗号分隔的来源列表，"*"表示允许所有来源
+func corsMiddleware(allowOrigins string) gin.HandlerFunc {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(allowOrigins, ",") {
+		origins[strings.TrimSpace(origin)] = true
+	}
+	
+	return func(c *gin.Context) {
+		if origins["*"] {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else if origin := c.GetHeader("Origin"); origins[origin] {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
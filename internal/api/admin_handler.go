@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler 管理员账号API处理器
+type AdminHandler struct {
+	adminService *service.AdminService
+	auth         *AuthMiddleware
+}
+
+// NewAdminHandler 创建管理员处理器
+func NewAdminHandler(adminService *service.AdminService, auth *AuthMiddleware) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+		auth:         auth,
+	}
+}
+
+// CreateAdmin 创建管理员
+// POST /api/admins
+func (h *AdminHandler) CreateAdmin(c *gin.Context) {
+	var req models.CreateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	admin, err := h.adminService.CreateAdmin(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Admin created successfully",
+		"admin":   admin.Sanitized(),
+	})
+}
+
+// ListAdmins 列出所有管理员
+// GET /api/admins
+func (h *AdminHandler) ListAdmins(c *gin.Context) {
+	admins, err := h.adminService.ListAdmins()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	sanitized := make([]*models.Admin, 0, len(admins))
+	for _, admin := range admins {
+		sanitized = append(sanitized, admin.Sanitized())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"admins": sanitized,
+		"count":  len(sanitized),
+	})
+}
+
+// GetAdmin 获取管理员
+// GET /api/admins/:id
+func (h *AdminHandler) GetAdmin(c *gin.Context) {
+	admin, err := h.adminService.GetAdmin(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"admin": admin.Sanitized(),
+	})
+}
+
+// UpdateAdmin 更新管理员
+// PUT /api/admins/:id
+func (h *AdminHandler) UpdateAdmin(c *gin.Context) {
+	var req models.UpdateAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	admin, err := h.adminService.UpdateAdmin(c.Param("id"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admin updated successfully",
+		"admin":   admin.Sanitized(),
+	})
+}
+
+// DeleteAdmin 删除管理员
+// DELETE /api/admins/:id
+func (h *AdminHandler) DeleteAdmin(c *gin.Context) {
+	if err := h.adminService.DeleteAdmin(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Admin deleted successfully",
+	})
+}
+
+// SetPassword 重置管理员登录密码
+// POST /api/admins/:id/password
+func (h *AdminHandler) SetPassword(c *gin.Context) {
+	var req models.SetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.adminService.SetPassword(c.Param("id"), req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password updated successfully",
+	})
+}
+
+// GetCurrentAdmin 获取当前登录的管理员
+// GET /api/admins/me
+func (h *AdminHandler) GetCurrentAdmin(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"admin": currentPrincipal(c).Admin.Sanitized(),
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *AdminHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api", h.auth.Authenticate())
+	{
+		api.GET("/admins/me", h.GetCurrentAdmin)
+
+		admins := api.Group("/admins", h.auth.RequireScope(models.ScopeAdminsAdmin), h.auth.RequireRole(models.RoleAdmin))
+		{
+			admins.POST("", h.CreateAdmin)
+			admins.GET("", h.ListAdmins)
+			admins.GET("/:id", h.GetAdmin)
+			admins.PUT("/:id", h.UpdateAdmin)
+			admins.DELETE("/:id", h.DeleteAdmin)
+			admins.POST("/:id/password", h.SetPassword)
+		}
+	}
+}
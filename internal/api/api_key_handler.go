@@ -0,0 +1,136 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler API密钥管理处理器
+type APIKeyHandler struct {
+	authService *service.AuthService
+	auth        *AuthMiddleware
+}
+
+// NewAPIKeyHandler 创建API密钥管理处理器
+func NewAPIKeyHandler(authService *service.AuthService, auth *AuthMiddleware) *APIKeyHandler {
+	return &APIKeyHandler{
+		authService: authService,
+		auth:        auth,
+	}
+}
+
+// apiKeyResponse 对外展示的API密钥信息，不包含哈希
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	AdminID    string     `json:"admin_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+}
+
+// newAPIKeyResponse 构造API密钥响应
+func newAPIKeyResponse(key *models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		AdminID:    key.AdminID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		Revoked:    key.Revoked,
+	}
+}
+
+// CreateAPIKey 创建API密钥
+// POST /api/keys
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	key, rawKey, err := h.authService.CreateAPIKey(&req, currentPrincipal(c))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrKeyNotPermitted) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created successfully, store it now as it will not be shown again",
+		"api_key": newAPIKeyResponse(key),
+		"key":     rawKey,
+	})
+}
+
+// ListAPIKeys 列出所有API密钥
+// GET /api/keys
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.authService.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	responses := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		responses = append(responses, newAPIKeyResponse(key))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": responses,
+		"count":    len(responses),
+	})
+}
+
+// RevokeAPIKey 吊销API密钥
+// DELETE /api/keys/:id
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.authService.RevokeAPIKey(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "API key revoked successfully",
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *APIKeyHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api", h.auth.Authenticate())
+	{
+		keys := api.Group("/keys", h.auth.RequireScope(models.ScopeKeysAdmin), h.auth.RequireRole(models.RoleAdmin))
+		{
+			keys.POST("", h.CreateAPIKey)
+			keys.GET("", h.ListAPIKeys)
+			keys.DELETE("/:id", h.RevokeAPIKey)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAuditLimit 查询审计事件时的默认返回条数
+const defaultAuditLimit = 100
+
+// AuditHandler 审计日志API处理器
+type AuditHandler struct {
+	auditService *service.AuditService
+	auth         *AuthMiddleware
+}
+
+// NewAuditHandler 创建审计日志处理器
+func NewAuditHandler(auditService *service.AuditService, auth *AuthMiddleware) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+		auth:         auth,
+	}
+}
+
+// parseAuditQuery 解析查询参数
+func parseAuditQuery(c *gin.Context) (*models.AuditQuery, error) {
+	query := &models.AuditQuery{
+		Actor:  c.Query("actor"),
+		Target: c.Query("target"),
+		Action: c.Query("action"),
+		Limit:  defaultAuditLimit,
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from format: %v", err)
+		}
+		query.From = t
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to format: %v", err)
+		}
+		query.To = t
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+// ListEvents 查询审计事件
+// GET /api/audit?actor=&target=&action=&from=&to=&limit=
+func (h *AuditHandler) ListEvents(c *gin.Context) {
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	events, err := h.auditService.Query(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"count":  len(events),
+	})
+}
+
+// ExportEvents 以JSON Lines格式导出审计事件，不受limit限制
+// GET /api/audit/export?actor=&target=&action=&from=&to=
+func (h *AuditHandler) ExportEvents(c *gin.Context) {
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	query.Limit = 0
+
+	filename := fmt.Sprintf("audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	if err := h.auditService.Export(c.Writer, query); err != nil {
+		// 响应头已发送，只能中断连接
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// RegisterRoutes 注册路由
+func (h *AuditHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api", h.auth.Authenticate())
+	{
+		audit := api.Group("/audit", h.auth.RequireScope(models.ScopeAuditRead), h.auth.RequireRole(models.RoleAdmin))
+		{
+			audit.GET("", h.ListEvents)
+			audit.GET("/export", h.ExportEvents)
+		}
+	}
+}
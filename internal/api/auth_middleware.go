@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalContextKey 认证主体在gin上下文中的键
+const principalContextKey = "principal"
+
+// AuthMiddleware 认证中间件，支持API密钥和登录会话的访问令牌
+type AuthMiddleware struct {
+	authService    *service.AuthService
+	sessionService *service.SessionService
+}
+
+// NewAuthMiddleware 创建认证中间件
+func NewAuthMiddleware(authService *service.AuthService, sessionService *service.SessionService) *AuthMiddleware {
+	return &AuthMiddleware{
+		authService:    authService,
+		sessionService: sessionService,
+	}
+}
+
+// Authenticate 校验请求携带的API密钥或访问令牌
+// 支持 Authorization: Bearer <key|jwt> 和 X-API-Key: <key> 两种方式
+func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		credential := extractCredential(c)
+		if credential == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "missing api key or access token",
+			})
+			return
+		}
+
+		var principal *models.Principal
+		var err error
+		if isJWT(credential) {
+			principal, err = m.sessionService.Authenticate(credential)
+		} else {
+			principal, err = m.authService.Authenticate(credential)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), service.Actor{
+			Type: models.ActorTypeAdmin,
+			ID:   principal.Admin.ID,
+			Name: principal.Admin.Username,
+			IP:   c.ClientIP(),
+		}))
+		c.Next()
+	}
+}
+
+// RequireScope 要求当前密钥拥有指定权限
+func (m *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := currentPrincipal(c)
+		if principal == nil || !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "api key lacks required scope " + scope,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole 要求当前管理员属于指定角色之一
+func (m *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := currentPrincipal(c)
+		if principal == nil || !principal.HasRole(roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "role not permitted to perform this action",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// currentPrincipal 获取当前请求的认证主体
+func currentPrincipal(c *gin.Context) *models.Principal {
+	value, exists := c.Get(principalContextKey)
+	if !exists {
+		return nil
+	}
+	principal, _ := value.(*models.Principal)
+	return principal
+}
+
+// isJWT 判断凭据是否为JWT格式(三段以点分隔)
+func isJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// extractCredential 从请求头中提取API密钥或访问令牌
+func extractCredential(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+
+	authorization := c.GetHeader("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return ""
+}
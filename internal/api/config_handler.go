@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"sing-box-manager/internal/models"
 	"sing-box-manager/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -11,19 +12,21 @@ import (
 // ConfigHandler 配置API处理器
 type ConfigHandler struct {
 	configService *service.ConfigService
+	auth          *AuthMiddleware
 }
 
 // NewConfigHandler 创建配置处理器
-func NewConfigHandler(configService *service.ConfigService) *ConfigHandler {
+func NewConfigHandler(configService *service.ConfigService, auth *AuthMiddleware) *ConfigHandler {
 	return &ConfigHandler{
 		configService: configService,
+		auth:          auth,
 	}
 }
 
 // GenerateConfig 生成配置
 // POST /api/config/generate
 func (h *ConfigHandler) GenerateConfig(c *gin.Context) {
-	if err := h.configService.GenerateConfig(); err != nil {
+	if err := h.configService.GenerateConfig(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -38,7 +41,7 @@ func (h *ConfigHandler) GenerateConfig(c *gin.Context) {
 // ReloadConfig 重载配置
 // POST /api/config/reload
 func (h *ConfigHandler) ReloadConfig(c *gin.Context) {
-	if err := h.configService.ReloadSingBox(); err != nil {
+	if err := h.configService.ReloadSingBox(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -53,7 +56,7 @@ func (h *ConfigHandler) ReloadConfig(c *gin.Context) {
 // RestartSingBox 重启sing-box
 // POST /api/config/restart
 func (h *ConfigHandler) RestartSingBox(c *gin.Context) {
-	if err := h.configService.RestartSingBox(); err != nil {
+	if err := h.configService.RestartSingBox(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -79,7 +82,7 @@ func (h *ConfigHandler) GetConfigStatus(c *gin.Context) {
 // GenerateRealityKeypair 生成Reality密钥对
 // POST /api/config/reality/keypair
 func (h *ConfigHandler) GenerateRealityKeypair(c *gin.Context) {
-	keypair, err := h.configService.GenerateRealityKeypair()
+	keypair, err := h.configService.GenerateRealityKeypair(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -95,9 +98,9 @@ func (h *ConfigHandler) GenerateRealityKeypair(c *gin.Context) {
 
 // RegisterRoutes 注册路由
 func (h *ConfigHandler) RegisterRoutes(router *gin.Engine) {
-	api := router.Group("/api")
+	api := router.Group("/api", h.auth.Authenticate())
 	{
-		config := api.Group("/config")
+		config := api.Group("/config", h.auth.RequireScope(models.ScopeConfigAdmin), h.auth.RequireRole(models.RoleAdmin))
 		{
 			config.GET("/status", h.GetConfigStatus)
 			config.POST("/generate", h.GenerateConfig)
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler 健康检查处理器
+type HealthHandler struct {
+	healthService *service.HealthService
+}
+
+// NewHealthHandler 创建健康检查处理器
+func NewHealthHandler(healthService *service.HealthService) *HealthHandler {
+	return &HealthHandler{
+		healthService: healthService,
+	}
+}
+
+// Health 兼容旧版的健康检查
+// GET /health
+func (h *HealthHandler) Health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"service": "sing-box-manager",
+	})
+}
+
+// Live 存活探针，只要管理进程能响应即返回成功
+// GET /health/live
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": service.HealthStatusOK,
+	})
+}
+
+// Ready 就绪探针，任一检查失败时返回503
+// GET /health/ready
+func (h *HealthHandler) Ready(c *gin.Context) {
+	report := h.healthService.CheckReadiness()
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, report)
+}
+
+// RegisterRoutes 注册路由
+func (h *HealthHandler) RegisterRoutes(router *gin.Engine) {
+	health := router.Group("/health")
+	{
+		health.GET("", h.Health)
+		health.GET("/live", h.Live)
+		health.GET("/ready", h.Ready)
+	}
+}
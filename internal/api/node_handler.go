@@ -0,0 +1,295 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nodeContextKey 已认证节点在gin上下文中的键
+const nodeContextKey = "node"
+
+// NodeHandler 边缘节点管理和agent同步API处理器
+type NodeHandler struct {
+	nodeService *service.NodeService
+	auth        *AuthMiddleware
+}
+
+// NewNodeHandler 创建节点处理器
+func NewNodeHandler(nodeService *service.NodeService, auth *AuthMiddleware) *NodeHandler {
+	return &NodeHandler{
+		nodeService: nodeService,
+		auth:        auth,
+	}
+}
+
+// nodeResponse 对外展示的节点信息，附带根据心跳计算的在线状态
+type nodeResponse struct {
+	*models.Node
+	Online bool `json:"online"`
+}
+
+// newNodeResponse 构造节点响应
+func (h *NodeHandler) newNodeResponse(node *models.Node) nodeResponse {
+	return nodeResponse{
+		Node:   node.Sanitized(),
+		Online: h.nodeService.IsOnline(node),
+	}
+}
+
+// CreateNode 注册节点，响应中的令牌只返回这一次
+// POST /api/nodes
+func (h *NodeHandler) CreateNode(c *gin.Context) {
+	var req models.CreateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	node, token, err := h.nodeService.CreateNode(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Node created successfully",
+		"node":    h.newNodeResponse(node),
+		"token":   token,
+	})
+}
+
+// ListNodes 列出所有节点
+// GET /api/nodes
+func (h *NodeHandler) ListNodes(c *gin.Context) {
+	nodes, err := h.nodeService.ListNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	responses := make([]nodeResponse, 0, len(nodes))
+	for _, node := range nodes {
+		responses = append(responses, h.newNodeResponse(node))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": responses,
+		"count": len(responses),
+	})
+}
+
+// GetNode 获取节点
+// GET /api/nodes/:id
+func (h *NodeHandler) GetNode(c *gin.Context) {
+	node, err := h.nodeService.GetNode(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.newNodeResponse(node))
+}
+
+// UpdateNode 更新节点
+// PUT /api/nodes/:id
+func (h *NodeHandler) UpdateNode(c *gin.Context) {
+	var req models.UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	node, err := h.nodeService.UpdateNode(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Node updated successfully",
+		"node":    h.newNodeResponse(node),
+	})
+}
+
+// DeleteNode 删除节点
+// DELETE /api/nodes/:id
+func (h *NodeHandler) DeleteNode(c *gin.Context) {
+	if err := h.nodeService.DeleteNode(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Node deleted successfully",
+	})
+}
+
+// GetNodeStats 获取节点在线状态和各用户在该节点上的流量
+// GET /api/nodes/:id/stats
+func (h *NodeHandler) GetNodeStats(c *gin.Context) {
+	stats, err := h.nodeService.Stats(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// RotateToken 为节点签发新令牌
+// POST /api/nodes/:id/token
+func (h *NodeHandler) RotateToken(c *gin.Context) {
+	token, err := h.nodeService.RotateToken(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Node token rotated successfully",
+		"token":   token,
+	})
+}
+
+// authenticateNode 校验节点令牌，并将节点作为操作者写入请求上下文
+func (h *NodeHandler) authenticateNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		node, err := h.nodeService.Authenticate(c.GetHeader(service.NodeTokenHeader))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Set(nodeContextKey, node)
+		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), service.Actor{
+			Type: models.ActorTypeNode,
+			ID:   node.ID,
+			Name: node.Name,
+			IP:   c.ClientIP(),
+		}))
+		c.Next()
+	}
+}
+
+// currentNode 获取当前请求已认证的节点
+func currentNode(c *gin.Context) *models.Node {
+	return c.MustGet(nodeContextKey).(*models.Node)
+}
+
+// GetAgentConfig 节点拉取用户和入站定义
+// GET /api/agent/config
+func (h *NodeHandler) GetAgentConfig(c *gin.Context) {
+	config, err := h.nodeService.AgentConfig(currentNode(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// Heartbeat 节点上报运行状态
+// POST /api/agent/heartbeat
+func (h *NodeHandler) Heartbeat(c *gin.Context) {
+	var status models.NodeStatus
+	if err := c.ShouldBindJSON(&status); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.nodeService.Heartbeat(currentNode(c), &status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Heartbeat recorded",
+	})
+}
+
+// ReportTraffic 节点上报流量增量
+// POST /api/agent/traffic
+func (h *NodeHandler) ReportTraffic(c *gin.Context) {
+	var report models.TrafficReport
+	if err := c.ShouldBindJSON(&report); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := h.nodeService.ReportTraffic(c.Request.Context(), currentNode(c), &report)
+	if errors.Is(err, service.ErrDuplicateReport) {
+		// 重发的上报已处理过，按成功确认使agent清除未确认的上报
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Traffic report already applied",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Traffic reported successfully",
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *NodeHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api")
+	{
+		nodes := api.Group("/nodes", h.auth.Authenticate(), h.auth.RequireScope(models.ScopeNodesAdmin), h.auth.RequireRole(models.RoleAdmin))
+		{
+			nodes.POST("", h.CreateNode)
+			nodes.GET("", h.ListNodes)
+			nodes.GET("/:id", h.GetNode)
+			nodes.PUT("/:id", h.UpdateNode)
+			nodes.DELETE("/:id", h.DeleteNode)
+			nodes.GET("/:id/stats", h.GetNodeStats)
+			nodes.POST("/:id/token", h.RotateToken)
+		}
+
+		// 节点使用各自的令牌认证，不经过管理员认证
+		agent := api.Group("/agent", h.authenticateNode())
+		{
+			agent.POST("/heartbeat", h.Heartbeat)
+			agent.GET("/config", h.GetAgentConfig)
+			agent.POST("/traffic", h.ReportTraffic)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlanHandler 套餐模板API处理器
+type PlanHandler struct {
+	planService *service.PlanService
+	auth        *AuthMiddleware
+}
+
+// NewPlanHandler 创建套餐处理器
+func NewPlanHandler(planService *service.PlanService, auth *AuthMiddleware) *PlanHandler {
+	return &PlanHandler{
+		planService: planService,
+		auth:        auth,
+	}
+}
+
+// planResponse 对外展示的套餐信息，附带使用该套餐的用户数
+type planResponse struct {
+	*models.Plan
+	Users int `json:"users"`
+}
+
+// CreatePlan 创建套餐
+// POST /api/plans
+func (h *PlanHandler) CreatePlan(c *gin.Context) {
+	var req models.CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.planService.CreatePlan(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Plan created successfully",
+		"plan":    plan,
+	})
+}
+
+// ListPlans 列出所有套餐
+// GET /api/plans
+func (h *PlanHandler) ListPlans(c *gin.Context) {
+	plans, err := h.planService.ListPlans()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	stats, err := h.planService.PlanStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	responses := make([]planResponse, 0, len(plans))
+	for _, plan := range plans {
+		responses = append(responses, planResponse{Plan: plan, Users: stats[plan.ID]})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans": responses,
+		"count": len(responses),
+	})
+}
+
+// GetPlan 获取套餐
+// GET /api/plans/:id
+func (h *PlanHandler) GetPlan(c *gin.Context) {
+	plan, err := h.planService.GetPlan(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	stats, err := h.planService.PlanStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, planResponse{Plan: plan, Users: stats[plan.ID]})
+}
+
+// UpdatePlan 更新套餐
+// PUT /api/plans/:id
+func (h *PlanHandler) UpdatePlan(c *gin.Context) {
+	var req models.UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	plan, err := h.planService.UpdatePlan(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan updated successfully",
+		"plan":    plan,
+	})
+}
+
+// DeletePlan 删除套餐
+// DELETE /api/plans/:id
+func (h *PlanHandler) DeletePlan(c *gin.Context) {
+	if err := h.planService.DeletePlan(c.Request.Context(), c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan deleted successfully",
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *PlanHandler) RegisterRoutes(router *gin.Engine) {
+	// 创建用户时需要选择套餐，有查看用户权限即可查看套餐
+	read := h.auth.RequireScope(models.ScopeUsersRead)
+	write := h.auth.RequireScope(models.ScopePlansAdmin)
+	admins := h.auth.RequireRole(models.RoleAdmin)
+
+	plans := router.Group("/api/plans", h.auth.Authenticate())
+	{
+		plans.POST("", write, admins, h.CreatePlan)
+		plans.GET("", read, h.ListPlans)
+		plans.GET("/:id", read, h.GetPlan)
+		plans.PUT("/:id", write, admins, h.UpdatePlan)
+		plans.DELETE("/:id", write, admins, h.DeletePlan)
+	}
+}
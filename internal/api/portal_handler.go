@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// portalUserContextKey 自助门户中已认证用户在gin上下文中的键
+const portalUserContextKey = "portal_user"
+
+// PortalHandler 用户自助门户API处理器，通过订阅令牌认证
+type PortalHandler struct {
+	subscriptionService *service.SubscriptionService
+	deviceService       *service.DeviceService
+}
+
+// NewPortalHandler 创建自助门户处理器
+func NewPortalHandler(subscriptionService *service.SubscriptionService, deviceService *service.DeviceService) *PortalHandler {
+	return &PortalHandler{
+		subscriptionService: subscriptionService,
+		deviceService:       deviceService,
+	}
+}
+
+// authenticateUser 校验订阅令牌，并将用户作为操作者写入请求上下文
+func (h *PortalHandler) authenticateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := h.subscriptionService.GetSubscriber(c.Param("token"))
+		if err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, service.ErrSubscriptionDisabled) {
+				status = http.StatusForbidden
+			}
+			c.AbortWithStatusJSON(status, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Set(portalUserContextKey, user)
+		c.Request = c.Request.WithContext(service.WithActor(c.Request.Context(), service.Actor{
+			Type: models.ActorTypeUser,
+			ID:   user.ID,
+			Name: user.Username,
+			IP:   c.ClientIP(),
+		}))
+		c.Next()
+	}
+}
+
+// currentPortalUser 获取当前请求已认证的用户
+func currentPortalUser(c *gin.Context) *models.User {
+	return c.MustGet(portalUserContextKey).(*models.User)
+}
+
+// ListDevices 用户查看自己的设备
+// GET /portal/:token/devices
+func (h *PortalHandler) ListDevices(c *gin.Context) {
+	user := currentPortalUser(c)
+
+	devices, err := h.deviceService.ListDevices(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"devices":      devices,
+		"total":        len(devices),
+		"device_limit": user.DeviceLimit,
+	})
+}
+
+// RenameDevice 用户修改自己设备的名称
+// PUT /portal/:token/devices/:device_id
+func (h *PortalHandler) RenameDevice(c *gin.Context) {
+	var req models.RenameDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	device, err := h.deviceService.RenameDevice(c.Request.Context(), currentPortalUser(c).ID, c.Param("device_id"), req.Name)
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, device)
+}
+
+// RevokeDevice 用户移除自己不再使用的设备，腾出设备数
+// DELETE /portal/:token/devices/:device_id
+func (h *PortalHandler) RevokeDevice(c *gin.Context) {
+	closed, err := h.deviceService.RevokeDevice(c.Request.Context(), currentPortalUser(c).ID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Device revoked successfully",
+		"closed_connections": closed,
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *PortalHandler) RegisterRoutes(router *gin.Engine) {
+	portal := router.Group("/portal/:token", h.authenticateUser())
+	{
+		portal.GET("/devices", h.ListDevices)
+		portal.PUT("/devices/:device_id", h.RenameDevice)
+		portal.DELETE("/devices/:device_id", h.RevokeDevice)
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SessionHandler 管理员登录会话处理器
+type SessionHandler struct {
+	sessionService *service.SessionService
+	auth           *AuthMiddleware
+}
+
+// NewSessionHandler 创建会话处理器
+func NewSessionHandler(sessionService *service.SessionService, auth *AuthMiddleware) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+		auth:           auth,
+	}
+}
+
+// Login 用户名密码登录
+// POST /api/auth/login
+func (h *SessionHandler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokens, err := h.sessionService.Login(&req, c.ClientIP())
+	if errors.Is(err, service.ErrTOTPRequired) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":         err.Error(),
+			"totp_required": true,
+		})
+		return
+	}
+	if errors.Is(err, service.ErrLoginLocked) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh 刷新访问令牌
+// POST /api/auth/refresh
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout 注销当前会话
+// POST /api/auth/logout
+func (h *SessionHandler) Logout(c *gin.Context) {
+	principal := currentPrincipal(c)
+	if principal.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "logout requires a session access token",
+		})
+		return
+	}
+
+	if err := h.sessionService.Logout(principal.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+// ChangePassword 修改自己的登录密码
+// POST /api/auth/password
+func (h *SessionHandler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.sessionService.ChangePassword(currentPrincipal(c).Admin, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully, please log in again",
+	})
+}
+
+// SetupTOTP 生成TOTP密钥
+// POST /api/auth/totp/setup
+func (h *SessionHandler) SetupTOTP(c *gin.Context) {
+	secret, url, err := h.sessionService.SetupTOTP(currentPrincipal(c).Admin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Scan the otpauth URL with an authenticator app, then confirm via /api/auth/totp/enable",
+		"secret":      secret,
+		"otpauth_url": url,
+	})
+}
+
+// EnableTOTP 验证并启用TOTP
+// POST /api/auth/totp/enable
+func (h *SessionHandler) EnableTOTP(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.sessionService.EnableTOTP(currentPrincipal(c).Admin, req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "TOTP enabled successfully",
+	})
+}
+
+// DisableTOTP 关闭TOTP
+// POST /api/auth/totp/disable
+func (h *SessionHandler) DisableTOTP(c *gin.Context) {
+	var req models.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.sessionService.DisableTOTP(currentPrincipal(c).Admin, req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "TOTP disabled successfully",
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *SessionHandler) RegisterRoutes(router *gin.Engine) {
+	auth := router.Group("/api/auth")
+	{
+		auth.POST("/login", h.Login)
+		auth.POST("/refresh", h.Refresh)
+
+		authenticated := auth.Group("", h.auth.Authenticate())
+		{
+			authenticated.POST("/logout", h.Logout)
+			authenticated.POST("/password", h.ChangePassword)
+			authenticated.POST("/totp/setup", h.SetupTOTP)
+			authenticated.POST("/totp/enable", h.EnableTOTP)
+			authenticated.POST("/totp/disable", h.DisableTOTP)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultSingBoxLogLimit 查询sing-box日志时的默认返回条数
+const defaultSingBoxLogLimit = 200
+
+// singBoxLogKeepAlive 实时日志流在没有新日志时发送心跳的间隔，避免被代理断开
+const singBoxLogKeepAlive = 30 * time.Second
+
+// SingBoxHandler sing-box运行日志API处理器
+type SingBoxHandler struct {
+	logService *service.SingBoxLogService
+	auth       *AuthMiddleware
+}
+
+// NewSingBoxHandler 创建sing-box日志处理器
+func NewSingBoxHandler(logService *service.SingBoxLogService, auth *AuthMiddleware) *SingBoxHandler {
+	return &SingBoxHandler{
+		logService: logService,
+		auth:       auth,
+	}
+}
+
+// parseSingBoxLogQuery 解析查询参数
+func parseSingBoxLogQuery(c *gin.Context) (*models.SingBoxLogQuery, error) {
+	query := &models.SingBoxLogQuery{
+		Level: c.Query("level"),
+		User:  c.Query("user"),
+		Limit: defaultSingBoxLogLimit,
+	}
+
+	if query.Level != "" && !models.IsValidSingBoxLogLevel(query.Level) {
+		return nil, fmt.Errorf("invalid level: %s", query.Level)
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since format: %v", err)
+		}
+		query.Since = t
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit: %s", limit)
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+// GetLogs 查询内存中保留的sing-box日志
+// GET /api/singbox/logs?level=&user=&since=&limit=
+func (h *SingBoxHandler) GetLogs(c *gin.Context) {
+	query, err := parseSingBoxLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	logs := h.logService.Query(query)
+	c.JSON(http.StatusOK, gin.H{
+		"logs":  logs,
+		"count": len(logs),
+	})
+}
+
+// StreamLogs 以Server-Sent Events推送sing-box日志，先发送满足条件的历史日志，之后实时推送新日志
+// GET /api/singbox/logs/stream?level=&user=&since=&limit=
+func (h *SingBoxHandler) StreamLogs(c *gin.Context) {
+	query, err := parseSingBoxLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 先订阅再读取历史日志，避免两者之间产生的日志丢失，重复的日志在推送时跳过
+	entries, cancel := h.logService.Subscribe()
+	defer cancel()
+
+	history := h.logService.Query(query)
+	sent := make(map[*models.SingBoxLogEntry]bool, len(history))
+	for _, entry := range history {
+		sent[entry] = true
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, entry := range history {
+		c.SSEvent("log", entry)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(singBoxLogKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				return false
+			}
+			if !sent[entry] && query.Matches(entry) {
+				c.SSEvent("log", entry)
+			}
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now().Unix())
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *SingBoxHandler) RegisterRoutes(router *gin.Engine) {
+	api := router.Group("/api", h.auth.Authenticate())
+	{
+		singbox := api.Group("/singbox", h.auth.RequireScope(models.ScopeLogsRead), h.auth.RequireRole(models.RoleAdmin))
+		{
+			singbox.GET("/logs", h.GetLogs)
+			singbox.GET("/logs/stream", h.StreamLogs)
+		}
+	}
+}
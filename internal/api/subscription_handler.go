@@ -0,0 +1,316 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAccessLogLimit 查询订阅拉取记录时的默认返回条数
+const defaultAccessLogLimit = 100
+
+// SubscriptionHandler 订阅处理器
+type SubscriptionHandler struct {
+	subscriptionService *service.SubscriptionService
+	userService         *service.UserService
+	auth                *AuthMiddleware
+	baseURL             string
+	profileName         string
+	updateInterval      int
+}
+
+// NewSubscriptionHandler 创建订阅处理器
+// baseURL为空时根据请求地址生成订阅链接，profileName为客户端中显示的配置名称，updateInterval为建议的自动更新间隔(小时)
+func NewSubscriptionHandler(subscriptionService *service.SubscriptionService, userService *service.UserService, auth *AuthMiddleware, baseURL, profileName string, updateInterval int) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		subscriptionService: subscriptionService,
+		userService:         userService,
+		auth:                auth,
+		baseURL:             strings.TrimRight(baseURL, "/"),
+		profileName:         profileName,
+		updateInterval:      updateInterval,
+	}
+}
+
+// setProfileHeaders 设置订阅客户端识别的配额和配置文件响应头
+func (h *SubscriptionHandler) setProfileHeaders(c *gin.Context, user *models.User, format string) {
+	c.Header("Subscription-Userinfo", h.subscriptionService.UserInfo(user))
+
+	if h.updateInterval > 0 {
+		c.Header("Profile-Update-Interval", fmt.Sprint(h.updateInterval))
+	}
+
+	filename := h.profileName + "-" + user.Username + service.FileExtension(format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s",
+		asciiFilename(filename), url.PathEscape(filename)))
+}
+
+// asciiFilename 将非ASCII字符替换为下划线，供不支持filename*的客户端使用
+func asciiFilename(filename string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+}
+
+// subscriptionURL 生成用户的订阅地址，未配置baseURL时根据请求推断
+func subscriptionURL(c *gin.Context, baseURL string, user *models.User) string {
+	if baseURL == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + c.Request.Host
+	}
+	return baseURL + "/sub/" + user.SubscriptionToken
+}
+
+// GetSubscription 公开的订阅内容，未指定format时根据User-Agent识别客户端
+// GET /sub/:token?format=base64|clash|singbox
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	user, err := h.subscriptionService.GetSubscriber(c.Param("token"))
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, service.ErrSubscriptionDisabled) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	format := service.DetectSubscriptionFormat(c.Query("format"), c.GetHeader("User-Agent"))
+	content, contentType, err := h.subscriptionService.Render(user, format)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrUnsupportedFormat) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.subscriptionService.RecordAccess(c.Request.Context(), user, c.ClientIP(), c.GetHeader("User-Agent"), format)
+	h.setProfileHeaders(c, user, format)
+	c.Data(http.StatusOK, contentType, content)
+}
+
+// GetQRCode 公开的分享链接二维码，通过订阅令牌认证
+// GET /sub/:token/qrcode?inbound=&format=png|svg&size=
+func (h *SubscriptionHandler) GetQRCode(c *gin.Context) {
+	user, err := h.subscriptionService.GetSubscriber(c.Param("token"))
+	if err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, service.ErrSubscriptionDisabled) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if h.renderQRCode(c, user) {
+		h.subscriptionService.RecordAccess(c.Request.Context(), user, c.ClientIP(), c.GetHeader("User-Agent"), "qrcode")
+	}
+}
+
+// GetUserQRCode 管理员获取用户分享链接的二维码
+// GET /api/users/:id/qrcode?inbound=&format=png|svg&size=
+func (h *SubscriptionHandler) GetUserQRCode(c *gin.Context) {
+	user, err := h.getAccessibleUser(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.renderQRCode(c, user)
+}
+
+// renderQRCode 按查询参数渲染用户指定入站分享链接的二维码，返回是否渲染成功
+func (h *SubscriptionHandler) renderQRCode(c *gin.Context, user *models.User) bool {
+	size := service.DefaultQRCodeSize
+	if value := c.Query("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid size: " + value,
+			})
+			return false
+		}
+		size = n
+	}
+
+	link, err := h.subscriptionService.ShareLink(user, c.Query("inbound"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInboundNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	image, contentType, err := service.RenderQRCode(link, c.DefaultQuery("format", service.QRCodeFormatPNG), size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, image)
+	return true
+}
+
+// getAccessibleUser 获取路径参数指定、当前管理员有权访问的用户
+func (h *SubscriptionHandler) getAccessibleUser(c *gin.Context) (*models.User, error) {
+	user, err := h.userService.GetUser(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !currentPrincipal(c).CanAccessUser(user) {
+		return nil, fmt.Errorf("user with ID %s not found", c.Param("id"))
+	}
+
+	return user, nil
+}
+
+// GetUserSubscription 管理员查看用户的订阅地址和分享链接
+// GET /api/users/:id/subscription
+func (h *SubscriptionHandler) GetUserSubscription(c *gin.Context) {
+	user, err := h.getAccessibleUser(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	links, err := h.subscriptionService.ShareLinks(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscription_url": subscriptionURL(c, h.baseURL, user),
+		"links":            links,
+	})
+}
+
+// RotateSubscription 为用户签发新的订阅令牌，旧订阅地址立即失效
+// POST /api/users/:id/subscription/rotate
+func (h *SubscriptionHandler) RotateSubscription(c *gin.Context) {
+	user, err := h.getAccessibleUser(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err = h.userService.RotateSubscriptionToken(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	h.subscriptionService.ForgetAccess(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Subscription token rotated successfully",
+		"subscription_url": subscriptionURL(c, h.baseURL, user),
+	})
+}
+
+// GetSubscriptionAccess 查看用户订阅的拉取记录
+// GET /api/users/:id/subscription/access?from=&limit=
+func (h *SubscriptionHandler) GetSubscriptionAccess(c *gin.Context) {
+	user, err := h.getAccessibleUser(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	query := &models.SubscriptionAccessQuery{
+		UserID: user.ID,
+		Limit:  defaultAccessLogLimit,
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("invalid from format: %v", err),
+			})
+			return
+		}
+		query.From = t
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid limit: " + limit,
+			})
+			return
+		}
+		query.Limit = n
+	}
+
+	records, err := h.subscriptionService.AccessLog(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access": records,
+		"count":  len(records),
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *SubscriptionHandler) RegisterRoutes(router *gin.Engine) {
+	router.GET("/sub/:token", h.GetSubscription)
+	router.GET("/sub/:token/qrcode", h.GetQRCode)
+
+	read := h.auth.RequireScope(models.ScopeUsersRead)
+	write := h.auth.RequireScope(models.ScopeUsersWrite)
+	managers := h.auth.RequireRole(models.RoleAdmin, models.RoleReseller)
+
+	api := router.Group("/api", h.auth.Authenticate())
+	{
+		api.GET("/users/:id/subscription", read, h.GetUserSubscription)
+		api.POST("/users/:id/subscription/rotate", write, managers, h.RotateSubscription)
+		api.GET("/users/:id/subscription/access", read, h.GetSubscriptionAccess)
+		api.GET("/users/:id/qrcode", read, h.GetUserQRCode)
+	}
+}
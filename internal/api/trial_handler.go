@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrialHandler 试用开通API处理器
+type TrialHandler struct {
+	trialService *service.TrialService
+	auth         *AuthMiddleware
+	baseURL      string
+}
+
+// NewTrialHandler 创建试用处理器，baseURL用于生成订阅地址
+func NewTrialHandler(trialService *service.TrialService, auth *AuthMiddleware, baseURL string) *TrialHandler {
+	return &TrialHandler{
+		trialService: trialService,
+		auth:         auth,
+		baseURL:      baseURL,
+	}
+}
+
+// CreateTrial 管理员为邮箱开通试用
+// POST /api/trials
+func (h *TrialHandler) CreateTrial(c *gin.Context) {
+	var req models.CreateTrialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.trialService.CreateTrial(c.Request.Context(), &req, currentPrincipal(c).Admin)
+	if err != nil {
+		c.JSON(trialErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":          "Trial created successfully",
+		"user":             user,
+		"subscription_url": subscriptionURL(c, h.baseURL, user),
+	})
+}
+
+// ListTrials 列出试用记录，分销商只能看到自己的记录
+// GET /api/trials
+func (h *TrialHandler) ListTrials(c *gin.Context) {
+	var ownerID string
+	if admin := currentPrincipal(c).Admin; admin.Role == models.RoleReseller {
+		ownerID = admin.ID
+	}
+
+	trials, err := h.trialService.ListTrials(ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trials": trials,
+		"count":  len(trials),
+	})
+}
+
+// CreateInvite 签发试用邀请
+// POST /api/trials/invites
+func (h *TrialHandler) CreateInvite(c *gin.Context) {
+	var req models.CreateTrialInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	invite, err := h.trialService.CreateInvite(c.Request.Context(), &req, currentPrincipal(c).Admin)
+	if err != nil {
+		c.JSON(trialErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Trial invite created successfully",
+		"invite":  invite,
+	})
+}
+
+// ClaimTrial 通过试用邀请自助开通试用
+// POST /trial
+func (h *TrialHandler) ClaimTrial(c *gin.Context) {
+	var req models.ClaimTrialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.trialService.ClaimTrial(c.Request.Context(), &req, c.ClientIP())
+	if err != nil {
+		c.JSON(trialErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 用户凭订阅地址导入配置，不返回密码等其他信息
+	c.JSON(http.StatusCreated, gin.H{
+		"message":            "Trial created successfully",
+		"username":           user.Username,
+		"expires_at":         user.ExpiresAt,
+		"traffic_limit":      user.TrafficLimit,
+		"device_limit":       user.DeviceLimit,
+		"subscription_token": user.SubscriptionToken,
+		"subscription_url":   subscriptionURL(c, h.baseURL, user),
+	})
+}
+
+// RegisterRoutes 注册路由
+func (h *TrialHandler) RegisterRoutes(router *gin.Engine) {
+	read := h.auth.RequireScope(models.ScopeUsersRead)
+	write := h.auth.RequireScope(models.ScopeUsersWrite)
+	managers := h.auth.RequireRole(models.RoleAdmin, models.RoleReseller)
+
+	trials := router.Group("/api/trials", h.auth.Authenticate(), managers)
+	{
+		trials.POST("", write, h.CreateTrial)
+		trials.GET("", read, h.ListTrials)
+		trials.POST("/invites", write, h.CreateInvite)
+	}
+
+	// 公开的试用开通接口，通过签名的试用邀请认证
+	router.POST("/trial", h.ClaimTrial)
+}
+
+// trialErrorStatus 将试用错误映射为HTTP状态码
+func trialErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrTrialInviteInvalid):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrTrialEmailUsed), errors.Is(err, service.ErrTrialInviteUsed):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrTrialRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrTrialNotConfigured):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
@@ -1,8 +1,12 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	"sing-box-manager/internal/metrics"
 	"sing-box-manager/internal/models"
 	"sing-box-manager/internal/service"
 
@@ -11,16 +15,122 @@ import (
 
 // UserHandler 用户API处理器
 type UserHandler struct {
-	userService *service.UserService
+	userService   *service.UserService
+	deviceService *service.DeviceService
+	adminService  *service.AdminService
+	auth          *AuthMiddleware
 }
 
 // NewUserHandler 创建用户处理器
-func NewUserHandler(userService *service.UserService) *UserHandler {
+func NewUserHandler(userService *service.UserService, deviceService *service.DeviceService, adminService *service.AdminService, auth *AuthMiddleware) *UserHandler {
 	return &UserHandler{
-		userService: userService,
+		userService:   userService,
+		deviceService: deviceService,
+		adminService:  adminService,
+		auth:          auth,
 	}
 }
 
+// getAccessibleUser 获取当前管理员有权访问的用户，无权访问时按不存在处理
+func (h *UserHandler) getAccessibleUser(c *gin.Context, id string) (*models.User, error) {
+	user, err := h.userService.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	
+	if !currentPrincipal(c).CanAccessUser(user) {
+		return nil, fmt.Errorf("user with ID %s not found", id)
+	}
+	
+	return user, nil
+}
+
+// authorizeCreate 按角色检查创建请求是否允许，在按套餐填充请求前调用
+// 分销商创建的用户归其所有，节点分组、可用入站和流量重置周期只能通过套餐或由超级管理员指定
+func (h *UserHandler) authorizeCreate(c *gin.Context, req *models.CreateUserRequest) error {
+	principal := currentPrincipal(c)
+	if principal.Admin.Role != models.RoleReseller {
+		return nil
+	}
+	req.OwnerID = principal.Admin.ID
+	
+	if len(req.AllowedGroups) > 0 {
+		return fmt.Errorf("resellers may not assign node groups")
+	}
+	if req.AllowedInbounds != nil || req.TrafficResetCycle != "" {
+		return fmt.Errorf("resellers may only assign allowed_inbounds and traffic_reset_cycle through a plan")
+	}
+	return nil
+}
+
+// resolveOwner 返回新用户所属的分销商，创建用户时检查其配额，未指定时返回nil
+func (h *UserHandler) resolveOwner(req *models.CreateUserRequest) (*models.Admin, error) {
+	if req.OwnerID == "" {
+		return nil, nil
+	}
+	
+	owner, err := h.adminService.GetAdmin(req.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if owner.Role != models.RoleReseller {
+		return nil, fmt.Errorf("owner %s is not a reseller", owner.Username)
+	}
+	return owner, nil
+}
+
+// authorizeUpdate 按角色检查更新请求是否允许，在按套餐填充请求前调用
+// 客服只能延长有效期，分销商不能分配节点分组，可用入站和流量重置周期只能通过套餐修改
+func (h *UserHandler) authorizeUpdate(c *gin.Context, user *models.User, req *models.UpdateUserRequest) (int, error) {
+	admin := currentPrincipal(c).Admin
+	
+	switch admin.Role {
+	case models.RoleOperator:
+		if req.TrafficLimit != nil || req.DeviceLimit != nil || req.IsActive != nil || req.AllowedGroups != nil || req.SpeedLimit != nil ||
+			req.PlanID != nil || req.AllowedInbounds != nil || req.TrafficResetCycle != nil {
+			return http.StatusForbidden, fmt.Errorf("operators may only extend expires_at")
+		}
+		if req.ExpiresAt != nil {
+			expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				return http.StatusBadRequest, fmt.Errorf("invalid expires_at format: %v", err)
+			}
+			if expiresAt.Before(user.ExpiresAt) {
+				return http.StatusForbidden, fmt.Errorf("operators may only extend expires_at")
+			}
+		}
+	case models.RoleReseller:
+		if req.AllowedGroups != nil {
+			return http.StatusForbidden, fmt.Errorf("resellers may not assign node groups")
+		}
+		if req.AllowedInbounds != nil || req.TrafficResetCycle != nil {
+			return http.StatusForbidden, fmt.Errorf("resellers may only assign allowed_inbounds and traffic_reset_cycle through a plan")
+		}
+		// 自动停用的用户只能通过续期、充值或到期自动恢复
+		if req.IsActive != nil && user.DisabledReason != "" && user.DisabledReason != models.DisabledReasonManual {
+			return http.StatusForbidden, fmt.Errorf("resellers may not change is_active of a user disabled as %s", user.DisabledReason)
+		}
+	}
+	
+	return http.StatusOK, nil
+}
+
+// resellerQuota 当前管理员是分销商时返回其账号，提高用户流量限制时检查其配额
+func resellerQuota(c *gin.Context) *models.Admin {
+	if admin := currentPrincipal(c).Admin; admin.Role == models.RoleReseller {
+		return admin
+	}
+	return nil
+}
+
+// userErrorStatus 将创建和修改用户的错误映射为HTTP状态码
+func userErrorStatus(err error) int {
+	if errors.Is(err, service.ErrQuotaExceeded) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
 // CreateUser 创建用户
 // POST /api/users
 func (h *UserHandler) CreateUser(c *gin.Context) {
@@ -32,14 +142,38 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 	
-	user, err := h.userService.CreateUser(&req)
-	if err != nil {
+	if err := h.authorizeCreate(c, &req); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	// 先按套餐填入各项限制，分销商配额按实际流量检查
+	if err := h.userService.ResolveCreatePlan(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 	
+	owner, err := h.resolveOwner(&req)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	// 分销商配额按套餐填充后实际生效的流量检查
+	user, err := h.userService.CreateUser(c.Request.Context(), &req, owner)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "User created successfully",
 		"user":    user,
@@ -51,7 +185,7 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 func (h *UserHandler) GetUser(c *gin.Context) {
 	id := c.Param("id")
 	
-	user, err := h.userService.GetUser(id)
+	user, err := h.getAccessibleUser(c, id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -77,14 +211,36 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 	
-	user, err := h.userService.UpdateUser(id, &req)
+	existing, err := h.getAccessibleUser(c, id)
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if status, err := h.authorizeUpdate(c, existing, &req); err != nil {
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if err := h.userService.ResolveUpdatePlan(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 	
+	user, err := h.userService.UpdateUser(c.Request.Context(), id, &req, resellerQuota(c))
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User updated successfully",
 		"user":    user,
@@ -96,7 +252,14 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id := c.Param("id")
 	
-	if err := h.userService.DeleteUser(id); err != nil {
+	if _, err := h.getAccessibleUser(c, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if err := h.userService.DeleteUser(c.Request.Context(), id); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
 		})
@@ -111,7 +274,15 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 // ListUsers 列出所有用户
 // GET /api/users
 func (h *UserHandler) ListUsers(c *gin.Context) {
-	users, err := h.userService.ListUsers()
+	var users []*models.User
+	var err error
+	
+	// 分销商只能看到自己名下的用户
+	if admin := currentPrincipal(c).Admin; admin.Role == models.RoleReseller {
+		users, err = h.userService.ListUsersByOwner(admin.ID)
+	} else {
+		users, err = h.userService.ListUsers()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -131,6 +302,9 @@ func (h *UserHandler) GetUserByUsername(c *gin.Context) {
 	username := c.Param("username")
 	
 	user, err := h.userService.GetUserByUsername(username)
+	if err == nil && !currentPrincipal(c).CanAccessUser(user) {
+		err = fmt.Errorf("user with username %s not found", username)
+	}
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -159,7 +333,14 @@ func (h *UserHandler) ConnectDevice(c *gin.Context) {
 		return
 	}
 	
-	if err := h.userService.ConnectDevice(userID, req.DeviceID); err != nil {
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if err := h.userService.ConnectDevice(c.Request.Context(), userID, req.DeviceID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
@@ -187,7 +368,14 @@ func (h *UserHandler) DisconnectDevice(c *gin.Context) {
 		return
 	}
 	
-	if err := h.userService.DisconnectDevice(userID, req.DeviceID); err != nil {
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if err := h.userService.DisconnectDevice(c.Request.Context(), userID, req.DeviceID); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
@@ -199,13 +387,202 @@ func (h *UserHandler) DisconnectDevice(c *gin.Context) {
 	})
 }
 
+// ListDevices 获取用户的设备列表
+// GET /api/users/:id/devices
+func (h *UserHandler) ListDevices(c *gin.Context) {
+	userID := c.Param("id")
+	
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	devices, err := h.deviceService.ListDevices(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"devices": devices,
+		"total":   len(devices),
+	})
+}
+
+// RenameDevice 修改设备名称
+// PUT /api/users/:id/devices/:device_id
+func (h *UserHandler) RenameDevice(c *gin.Context) {
+	userID := c.Param("id")
+	
+	var req models.RenameDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	device, err := h.deviceService.RenameDevice(c.Request.Context(), userID, c.Param("device_id"), req.Name)
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, device)
+}
+
+// RevokeDevice 移除设备并断开其连接
+// DELETE /api/users/:id/devices/:device_id
+func (h *UserHandler) RevokeDevice(c *gin.Context) {
+	userID := c.Param("id")
+	
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	closed, err := h.deviceService.RevokeDevice(c.Request.Context(), userID, c.Param("device_id"))
+	if err != nil {
+		c.JSON(deviceErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Device revoked successfully",
+		"closed_connections": closed,
+	})
+}
+
+// RenewUser 续期用户
+// POST /api/users/:id/renew
+func (h *UserHandler) RenewUser(c *gin.Context) {
+	id := c.Param("id")
+	
+	var req models.RenewUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if _, err := h.getAccessibleUser(c, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	// 客服只能按天数延长有效期，套餐会改变各项限制
+	if currentPrincipal(c).Admin.Role == models.RoleOperator && (req.PlanID != "" || req.ResetTraffic) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "operators may only renew by duration_days",
+		})
+		return
+	}
+	
+	// 套餐提高流量限制时检查分销商配额
+	user, entry, err := h.userService.RenewUser(c.Request.Context(), id, &req, resellerQuota(c))
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User renewed successfully",
+		"user":    user,
+		"ledger":  entry,
+	})
+}
+
+// TopUpUser 为用户充值流量
+// POST /api/users/:id/topup
+func (h *UserHandler) TopUpUser(c *gin.Context) {
+	id := c.Param("id")
+	
+	var req models.TopUpUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	if _, err := h.getAccessibleUser(c, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	user, entry, err := h.userService.TopUpUser(c.Request.Context(), id, &req, resellerQuota(c))
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User topped up successfully",
+		"user":    user,
+		"ledger":  entry,
+	})
+}
+
+// GetLedger 获取用户的续期和充值记录
+// GET /api/users/:id/ledger
+func (h *UserHandler) GetLedger(c *gin.Context) {
+	id := c.Param("id")
+	
+	if _, err := h.getAccessibleUser(c, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	entries, err := h.userService.ListLedger(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"ledger": entries,
+		"total":  len(entries),
+	})
+}
+
 // UpdateTraffic 更新流量使用
 // POST /api/users/:id/traffic
 func (h *UserHandler) UpdateTraffic(c *gin.Context) {
 	userID := c.Param("id")
 	
 	var req struct {
-		BytesUsed int64 `json:"bytes_used" binding:"required"`
+		BytesUsed int64  `json:"bytes_used" binding:"required"`
+		Inbound   string `json:"inbound,omitempty"` // 可选，产生流量的入站标签
 	}
 	
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -215,12 +592,13 @@ func (h *UserHandler) UpdateTraffic(c *gin.Context) {
 		return
 	}
 	
-	if err := h.userService.UpdateTrafficUsage(userID, req.BytesUsed); err != nil {
+	if err := h.userService.UpdateTrafficUsage(c.Request.Context(), userID, req.BytesUsed, req.Inbound); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
+	metrics.AddTraffic("local", req.Inbound, req.BytesUsed)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Traffic updated successfully",
@@ -232,6 +610,13 @@ func (h *UserHandler) UpdateTraffic(c *gin.Context) {
 func (h *UserHandler) GetUserStats(c *gin.Context) {
 	userID := c.Param("id")
 	
+	if _, err := h.getAccessibleUser(c, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	
 	stats, err := h.userService.GetUserStats(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -247,20 +632,41 @@ func (h *UserHandler) GetUserStats(c *gin.Context) {
 
 // RegisterRoutes 注册路由
 func (h *UserHandler) RegisterRoutes(router *gin.Engine) {
-	api := router.Group("/api")
+	read := h.auth.RequireScope(models.ScopeUsersRead)
+	write := h.auth.RequireScope(models.ScopeUsersWrite)
+	
+	// 客服只能查看和延长有效期，流量上报仅限超级管理员
+	managers := h.auth.RequireRole(models.RoleAdmin, models.RoleReseller)
+	admins := h.auth.RequireRole(models.RoleAdmin)
+	
+	api := router.Group("/api", h.auth.Authenticate())
 	{
 		users := api.Group("/users")
 		{
-			users.POST("", h.CreateUser)
-			users.GET("", h.ListUsers)
-			users.GET("/:id", h.GetUser)
-			users.PUT("/:id", h.UpdateUser)
-			users.DELETE("/:id", h.DeleteUser)
-			users.GET("/username/:username", h.GetUserByUsername)
-			users.POST("/:id/connect", h.ConnectDevice)
-			users.POST("/:id/disconnect", h.DisconnectDevice)
-			users.POST("/:id/traffic", h.UpdateTraffic)
-			users.GET("/:id/stats", h.GetUserStats)
+			users.POST("", write, managers, h.CreateUser)
+			users.GET("", read, h.ListUsers)
+			users.GET("/:id", read, h.GetUser)
+			users.PUT("/:id", write, h.UpdateUser)
+			users.DELETE("/:id", write, managers, h.DeleteUser)
+			users.GET("/username/:username", read, h.GetUserByUsername)
+			users.POST("/:id/connect", write, managers, h.ConnectDevice)
+			users.POST("/:id/disconnect", write, managers, h.DisconnectDevice)
+			users.GET("/:id/devices", read, h.ListDevices)
+			users.PUT("/:id/devices/:device_id", write, managers, h.RenameDevice)
+			users.DELETE("/:id/devices/:device_id", write, managers, h.RevokeDevice)
+			users.POST("/:id/traffic", write, admins, h.UpdateTraffic)
+			users.POST("/:id/renew", write, h.RenewUser)
+			users.POST("/:id/topup", write, managers, h.TopUpUser)
+			users.GET("/:id/ledger", read, h.GetLedger)
+			users.GET("/:id/stats", read, h.GetUserStats)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// deviceErrorStatus 设备不存在时返回404，其余错误返回500
+func deviceErrorStatus(err error) int {
+	if errors.Is(err, service.ErrDeviceNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
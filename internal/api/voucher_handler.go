@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VoucherHandler 兑换码API处理器
+type VoucherHandler struct {
+	voucherService *service.VoucherService
+	auth           *AuthMiddleware
+}
+
+// NewVoucherHandler 创建兑换码处理器
+func NewVoucherHandler(voucherService *service.VoucherService, auth *AuthMiddleware) *VoucherHandler {
+	return &VoucherHandler{
+		voucherService: voucherService,
+		auth:           auth,
+	}
+}
+
+// CreateVouchers 批量生成兑换码
+// POST /api/vouchers
+func (h *VoucherHandler) CreateVouchers(c *gin.Context) {
+	var req models.CreateVouchersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	vouchers, err := h.voucherService.CreateVouchers(c.Request.Context(), &req, currentPrincipal(c).Admin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Vouchers created successfully",
+		"batch_id": vouchers[0].BatchID,
+		"vouchers": vouchers,
+		"count":    len(vouchers),
+	})
+}
+
+// ListVouchers 列出兑换码，可按批次和状态过滤，分销商只能看到自己的兑换码
+// GET /api/vouchers
+func (h *VoucherHandler) ListVouchers(c *gin.Context) {
+	query := models.VoucherQuery{
+		BatchID: c.Query("batch_id"),
+		Status:  c.Query("status"),
+	}
+	if admin := currentPrincipal(c).Admin; admin.Role == models.RoleReseller {
+		query.OwnerID = admin.ID
+	}
+
+	vouchers, err := h.voucherService.ListVouchers(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vouchers": vouchers,
+		"count":    len(vouchers),
+	})
+}
+
+// GetVoucher 获取兑换码及其兑换记录
+// GET /api/vouchers/:code
+func (h *VoucherHandler) GetVoucher(c *gin.Context) {
+	voucher, err := h.getAccessibleVoucher(c, c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, voucher)
+}
+
+// DisableVoucher 停用兑换码
+// DELETE /api/vouchers/:code
+func (h *VoucherHandler) DisableVoucher(c *gin.Context) {
+	existing, err := h.getAccessibleVoucher(c, c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	voucher, err := h.voucherService.DisableVoucher(c.Request.Context(), existing.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Voucher disabled successfully",
+		"voucher": voucher,
+	})
+}
+
+// Redeem 用户使用订阅令牌兑换兑换码
+// POST /redeem
+func (h *VoucherHandler) Redeem(c *gin.Context) {
+	var req models.RedeemVoucherRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, voucher, err := h.voucherService.Redeem(c.Request.Context(), req.Token, req.Code, c.ClientIP())
+	if err != nil {
+		c.JSON(redeemErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Voucher redeemed successfully",
+		"code":          voucher.Code,
+		"expires_at":    user.ExpiresAt,
+		"traffic_limit": user.TrafficLimit,
+		"traffic_used":  user.TrafficUsed,
+		"is_active":     user.IsActive,
+	})
+}
+
+// getAccessibleVoucher 获取当前管理员可访问的兑换码，分销商无法访问他人的兑换码
+func (h *VoucherHandler) getAccessibleVoucher(c *gin.Context, code string) (*models.Voucher, error) {
+	voucher, err := h.voucherService.GetVoucher(code)
+	if err != nil {
+		return nil, err
+	}
+	if admin := currentPrincipal(c).Admin; admin.Role == models.RoleReseller && voucher.OwnerID != admin.ID {
+		return nil, service.ErrVoucherNotFound
+	}
+	return voucher, nil
+}
+
+// RegisterRoutes 注册路由
+func (h *VoucherHandler) RegisterRoutes(router *gin.Engine) {
+	read := h.auth.RequireScope(models.ScopeUsersRead)
+	write := h.auth.RequireScope(models.ScopeUsersWrite)
+
+	// 兑换码等同于续期额度，客服不能查看或生成
+	managers := h.auth.RequireRole(models.RoleAdmin, models.RoleReseller)
+
+	vouchers := router.Group("/api/vouchers", h.auth.Authenticate(), managers)
+	{
+		vouchers.POST("", write, h.CreateVouchers)
+		vouchers.GET("", read, h.ListVouchers)
+		vouchers.GET("/:code", read, h.GetVoucher)
+		vouchers.DELETE("/:code", write, h.DisableVoucher)
+	}
+
+	// 用户兑换接口，通过订阅令牌认证
+	router.POST("/redeem", h.Redeem)
+}
+
+// redeemErrorStatus 将兑换错误映射为HTTP状态码
+func redeemErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRedeemInvalid):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrRedeemLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrVoucherUsed), errors.Is(err, service.ErrVoucherAlreadyRedeemed):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrVoucherExpired), errors.Is(err, service.ErrVoucherDisabled):
+		return http.StatusGone
+	case errors.Is(err, service.ErrVoucherNotApplicable):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader 请求ID使用的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDContextKey struct{}
+
+// Setup 创建JSON格式的结构化日志并设为全局默认，level为debug/info/warn/error
+func Setup(w io.Writer, level string) *slog.Logger {
+	logger := slog.New(&contextHandler{
+		Handler: slog.NewJSONHandler(w, &slog.HandlerOptions{Level: ParseLevel(level)}),
+	})
+	slog.SetDefault(logger)
+
+	// gin调试模式下的路由注册等输出同样走结构化日志
+	gin.DebugPrintRouteFunc = func(httpMethod, absolutePath, handlerName string, handlers int) {
+		logger.Debug("Registered route", "method", httpMethod, "path", absolutePath, "handler", handlerName, "handlers", handlers)
+	}
+	gin.DebugPrintFunc = func(format string, values ...interface{}) {
+		logger.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+
+	return logger
+}
+
+// ParseLevel 解析日志级别，无法识别时使用info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// WithRequestID 将请求ID写入上下文
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestID 从上下文读取请求ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDContextKey{}).(string); ok {
+		return requestID
+	}
+	return ""
+}
+
+// contextHandler 为带上下文的日志自动附加请求ID
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle 实现slog.Handler
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs 实现slog.Handler
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup 实现slog.Handler
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Middleware 为每个请求分配请求ID(优先沿用客户端传入的X-Request-ID)，
+// 写入请求上下文和响应头，并在请求结束后记录访问日志
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), requestID))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "http request", attrs...)
+	}
+}
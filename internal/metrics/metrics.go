@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace 所有指标名称的前缀
+const namespace = "sbm"
+
+var (
+	// ConfigGenerations 配置生成次数，按结果区分
+	ConfigGenerations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_generations_total",
+		Help:      "Number of sing-box config generations by result.",
+	}, []string{"result"})
+
+	// SingBoxReloads sing-box重载次数，按结果区分
+	SingBoxReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "singbox_reloads_total",
+		Help:      "Number of sing-box reloads by result.",
+	}, []string{"result"})
+
+	// SingBoxRestarts sing-box重启次数，按结果区分
+	SingBoxRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "singbox_restarts_total",
+		Help:      "Number of sing-box restarts by result.",
+	}, []string{"result"})
+
+	// TrafficBytes 上报的流量，按来源节点和入站区分
+	TrafficBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "traffic_bytes_total",
+		Help:      "Reported proxy traffic in bytes by node and inbound.",
+	}, []string{"node", "inbound"})
+
+	// StorageWriteDuration 数据文件写入耗时
+	StorageWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_write_duration_seconds",
+		Help:      "Latency of persisting data files.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"file"})
+
+	// DeviceLimitViolations 用户同时在线设备数超过限制的次数
+	DeviceLimitViolations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_limit_violations_total",
+		Help:      "Number of times a user exceeded the device limit.",
+	})
+
+	// ClosedConnections 因超出设备数限制被关闭的连接数
+	ClosedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "device_limit_closed_connections_total",
+		Help:      "Number of connections closed for exceeding the device limit.",
+	})
+
+	// HTTPRequestDuration API请求耗时，按路由模板区分
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Result 将错误转换为result标签值
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveStorageWrite 记录一次数据文件写入的耗时
+func ObserveStorageWrite(file string, start time.Time) {
+	StorageWriteDuration.WithLabelValues(file).Observe(time.Since(start).Seconds())
+}
+
+// AddTraffic 累加上报的流量，inbound为空时记为unknown
+func AddTraffic(node, inbound string, bytesUsed int64) {
+	if bytesUsed <= 0 {
+		return
+	}
+	if inbound == "" {
+		inbound = "unknown"
+	}
+	TrafficBytes.WithLabelValues(node, inbound).Add(float64(bytesUsed))
+}
+
+// Middleware 记录每个请求的耗时，使用路由模板作为标签避免路径参数导致标签爆炸
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
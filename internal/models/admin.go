@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+)
+
+// 管理员角色
+const (
+	// RoleAdmin 超级管理员，拥有全部权限
+	RoleAdmin = "admin"
+	// RoleOperator 客服，只能查看用户和延长有效期
+	RoleOperator = "operator"
+	// RoleReseller 分销商，只能管理自己名下的用户
+	RoleReseller = "reseller"
+)
+
+// Admin 管理员账号
+type Admin struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	IsActive  bool      `json:"is_active"`
+
+	// 分销商配额，0表示不限制
+	MaxUsers   int   `json:"max_users"`
+	MaxTraffic int64 `json:"max_traffic"` // 可分配的总流量(字节)
+
+	// 登录凭据，不通过API返回
+	PasswordHash string `json:"password_hash,omitempty"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	// 最近一次使用的TOTP时间步，同一时间步的验证码不能重复使用
+	TOTPLastStep int64 `json:"totp_last_step,omitempty"`
+}
+
+// Sanitized 返回去除登录凭据后的副本，用于API响应
+func (a *Admin) Sanitized() *Admin {
+	admin := *a
+	admin.PasswordHash = ""
+	admin.TOTPSecret = ""
+	admin.TOTPLastStep = 0
+	return &admin
+}
+
+// IsValidRole 检查角色是否合法
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleOperator, RoleReseller:
+		return true
+	}
+	return false
+}
+
+// Principal 当前请求的认证主体
+type Principal struct {
+	Admin *Admin
+	// 通过API密钥认证时非空
+	APIKey *APIKey
+	// 通过登录会话认证时非空
+	SessionID string
+}
+
+// HasScope 检查主体是否拥有指定权限，非API密钥认证的主体不受权限范围限制
+func (p *Principal) HasScope(scope string) bool {
+	if p.APIKey == nil {
+		return true
+	}
+	return p.APIKey.HasScope(scope)
+}
+
+// HasRole 检查主体是否属于指定角色之一
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Admin.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessUser 检查主体是否可以访问指定用户，分销商只能访问自己名下的用户
+func (p *Principal) CanAccessUser(user *User) bool {
+	if p.Admin.Role == RoleReseller {
+		return user.OwnerID == p.Admin.ID
+	}
+	return true
+}
+
+// CreateAdminRequest 创建管理员请求
+type CreateAdminRequest struct {
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password,omitempty"`
+	Role       string `json:"role" binding:"required"`
+	MaxUsers   int    `json:"max_users"`
+	MaxTraffic int64  `json:"max_traffic"`
+}
+
+// UpdateAdminRequest 更新管理员请求
+type UpdateAdminRequest struct {
+	Role       *string `json:"role,omitempty"`
+	MaxUsers   *int    `json:"max_users,omitempty"`
+	MaxTraffic *int64  `json:"max_traffic,omitempty"`
+	IsActive   *bool   `json:"is_active,omitempty"`
+}
+
+// SetPasswordRequest 设置管理员密码请求
+type SetPasswordRequest struct {
+	Password string `json:"password" binding:"required"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+)
+
+// API权限范围
+const (
+	ScopeAll         = "*"
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopeConfigAdmin = "config:admin"
+	ScopeKeysAdmin   = "keys:admin"
+	ScopeAdminsAdmin = "admins:admin"
+	ScopeAuditRead   = "audit:read"
+	ScopeNodesAdmin  = "nodes:admin"
+	ScopeLogsRead    = "logs:read"
+	ScopePlansAdmin  = "plans:admin"
+	ScopeMetricsRead = "metrics:read"
+)
+
+// KnownScopes 所有可分配的权限范围
+var KnownScopes = []string{
+	ScopeAll,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeConfigAdmin,
+	ScopeKeysAdmin,
+	ScopeAdminsAdmin,
+	ScopeAuditRead,
+	ScopeNodesAdmin,
+	ScopeLogsRead,
+	ScopePlansAdmin,
+	ScopeMetricsRead,
+}
+
+// APIKey 管理API密钥，服务端只保存密钥的哈希
+type APIKey struct {
+	ID         string     `json:"id"`
+	AdminID    string     `json:"admin_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"key_hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+}
+
+// HasScope 检查密钥是否拥有指定权限
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == ScopeAll || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired 检查密钥是否过期
+func (k *APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// IsValidScope 检查权限范围是否合法
+func IsValidScope(scope string) bool {
+	for _, s := range KnownScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyRequest 创建API密钥请求
+type CreateAPIKeyRequest struct {
+	AdminID   string   `json:"admin_id"` // 为空时绑定到当前管理员
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes" binding:"required"`
+	ExpiresAt *string  `json:"expires_at,omitempty"` // RFC3339格式
+}
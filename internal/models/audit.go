@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+)
+
+// 审计操作者类型
+const (
+	ActorTypeAdmin  = "admin"
+	ActorTypeSystem = "system"
+	ActorTypeNode   = "node"
+	// 用户通过订阅令牌在自助门户中操作
+	ActorTypeUser = "user"
+)
+
+// FieldChange 字段变更前后的值
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEvent 审计事件
+type AuditEvent struct {
+	ID         string                 `json:"id"`
+	Timestamp  time.Time              `json:"timestamp"`
+	ActorType  string                 `json:"actor_type"`
+	ActorID    string                 `json:"actor_id,omitempty"`
+	Actor      string                 `json:"actor"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id,omitempty"`
+	Changes    map[string]FieldChange `json:"changes,omitempty"`
+	SourceIP   string                 `json:"source_ip,omitempty"`
+}
+
+// AuditQuery 审计事件查询条件，零值字段表示不过滤
+type AuditQuery struct {
+	Actor  string
+	Target string
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// Matches 检查事件是否满足查询条件
+func (q *AuditQuery) Matches(event *AuditEvent) bool {
+	if q.Actor != "" && event.ActorID != q.Actor && event.Actor != q.Actor {
+		return false
+	}
+	if q.Target != "" && event.TargetID != q.Target {
+		return false
+	}
+	if q.Action != "" && event.Action != q.Action {
+		return false
+	}
+	if !q.From.IsZero() && event.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && event.Timestamp.After(q.To) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Device 用户的一台设备
+type Device struct {
+	ID string `json:"id"`
+	// 用户或管理员设置的名称，便于识别设备
+	Name      string    `json:"name,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	LastIP    string    `json:"last_ip,omitempty"`
+	// 从同一IP拉取订阅时的User-Agent识别出的客户端
+	ClientApp string `json:"client_app,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// DeviceSighting 一次设备活动
+type DeviceSighting struct {
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// DeviceActivity 设备活动记录，键为用户ID，值为设备ID到最近一次活动的映射
+type DeviceActivity map[string]map[string]DeviceSighting
+
+// Record 记录一次设备活动，只保留较新的一次，新记录没有User-Agent时沿用旧记录的
+func (a DeviceActivity) Record(userID, deviceID string, sighting DeviceSighting) {
+	devices, exists := a[userID]
+	if !exists {
+		devices = make(map[string]DeviceSighting)
+		a[userID] = devices
+	}
+
+	previous, exists := devices[deviceID]
+	if exists && !sighting.LastSeen.After(previous.LastSeen) {
+		return
+	}
+	if sighting.UserAgent == "" {
+		sighting.UserAgent = previous.UserAgent
+	}
+	devices[deviceID] = sighting
+}
+
+// RenameDeviceRequest 设备重命名请求
+type RenameDeviceRequest struct {
+	Name string `json:"name" binding:"required,max=64"`
+}
+
+// ClientAppFromUserAgent 从User-Agent中取出客户端名称，如 ClashMetaForAndroid/2.10.1 取 ClashMetaForAndroid
+func ClientAppFromUserAgent(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if i := strings.IndexAny(userAgent, "/ ("); i >= 0 {
+		userAgent = userAgent[:i]
+	}
+	return userAgent
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// 账单记录类型
+const (
+	LedgerRenew   = "renew"
+	LedgerTopUp   = "topup"
+	LedgerConvert = "convert" // 试用用户按套餐续期转为正式用户
+)
+
+// LedgerEntry 一次续期或流量充值记录
+type LedgerEntry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	UserID    string    `json:"user_id"`
+	Type      string    `json:"type"`
+
+	// 操作者，与审计日志的操作者一致
+	ActorType string `json:"actor_type"`
+	ActorID   string `json:"actor_id,omitempty"`
+	ActorName string `json:"actor_name,omitempty"`
+
+	// 续期天数、使用的套餐和充值的流量 (字节)
+	DurationDays int    `json:"duration_days,omitempty"`
+	PlanID       string `json:"plan_id,omitempty"`
+	TrafficBytes int64  `json:"traffic_bytes,omitempty"`
+
+	// 操作前后的有效期和流量限制
+	ExpiresBefore      time.Time `json:"expires_before"`
+	ExpiresAfter       time.Time `json:"expires_after"`
+	TrafficLimitBefore int64     `json:"traffic_limit_before"`
+	TrafficLimitAfter  int64     `json:"traffic_limit_after"`
+
+	// 操作使用的兑换码等来源说明
+	Note string `json:"note,omitempty"`
+}
+
+// RenewUserRequest 续期请求，从当前有效期和当前时间中较晚者起算
+// 指定套餐时按套餐天数续期并应用套餐的各项限制，试用用户同时转为正式用户，从当前时间起算
+type RenewUserRequest struct {
+	DurationDays int    `json:"duration_days,omitempty" binding:"min=0"`
+	PlanID       string `json:"plan_id,omitempty"`
+	// 同时清零已用流量，开始新的计费周期
+	ResetTraffic bool   `json:"reset_traffic,omitempty"`
+	Note         string `json:"note,omitempty" binding:"max=256"`
+}
+
+// TopUpUserRequest 流量充值请求
+type TopUpUserRequest struct {
+	TrafficBytes int64  `json:"traffic_bytes" binding:"required,min=1"`
+	Note         string `json:"note,omitempty" binding:"max=256"`
+}
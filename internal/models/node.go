@@ -0,0 +1,104 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Node 边缘节点，运行agent模式的sing-box-manager
+type Node struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// 客户端连接该节点使用的地址，同时作为TLS证书域名
+	Address string `json:"address"`
+	// 节点分组，为空表示所有用户都可使用该节点
+	Groups     []string   `json:"groups"`
+	TokenHash  string     `json:"token_hash,omitempty"`
+	Enabled    bool       `json:"enabled"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSyncAt *time.Time `json:"last_sync_at,omitempty"`
+
+	// 最近一次心跳的时间和上报的运行状态
+	LastSeenAt *time.Time  `json:"last_seen_at,omitempty"`
+	Status     *NodeStatus `json:"status,omitempty"`
+}
+
+// NodeStatus 节点心跳上报的运行状态
+type NodeStatus struct {
+	SingBoxVersion string  `json:"singbox_version"`
+	SingBoxRunning bool    `json:"singbox_running"`
+	UptimeSeconds  int64   `json:"uptime_seconds"`
+	CPUPercent     float64 `json:"cpu_percent"`
+	MemoryUsed     int64   `json:"memory_used"`
+	MemoryTotal    int64   `json:"memory_total"`
+}
+
+// NormalizeGroups 去除分组名称两端空白、空值和重复项
+func NormalizeGroups(groups []string) []string {
+	normalized := make([]string, 0, len(groups))
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		group = strings.TrimSpace(group)
+		if group == "" || seen[group] {
+			continue
+		}
+		seen[group] = true
+		normalized = append(normalized, group)
+	}
+	return normalized
+}
+
+// IsOnline 检查节点是否在timeout内发送过心跳
+func (n *Node) IsOnline(timeout time.Duration) bool {
+	return n.LastSeenAt != nil && time.Since(*n.LastSeenAt) <= timeout
+}
+
+// Sanitized 返回去除令牌哈希的副本，用于API响应
+func (n *Node) Sanitized() *Node {
+	node := *n
+	node.TokenHash = ""
+	return &node
+}
+
+// CreateNodeRequest 注册节点请求
+type CreateNodeRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	Address string   `json:"address" binding:"required"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// UpdateNodeRequest 更新节点请求
+type UpdateNodeRequest struct {
+	Name    *string   `json:"name,omitempty"`
+	Address *string   `json:"address,omitempty"`
+	Groups  *[]string `json:"groups,omitempty"`
+	Enabled *bool     `json:"enabled,omitempty"`
+}
+
+// NodeUserTraffic 用户在单个节点上的累计流量
+type NodeUserTraffic struct {
+	UserID       string     `json:"user_id"`
+	Username     string     `json:"username,omitempty"`
+	BytesUsed    int64      `json:"bytes_used"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
+
+// NodeStats 节点统计信息
+type NodeStats struct {
+	Node         *Node             `json:"node"`
+	Online       bool              `json:"online"`
+	TotalTraffic int64             `json:"total_traffic"`
+	ActiveUsers  int               `json:"active_users"` // 最近24小时内在该节点上产生过流量的用户数
+	Users        []NodeUserTraffic `json:"users"`
+}
+
+// TrafficReport 节点上报的流量增量，键为用户ID，值为字节数
+type TrafficReport struct {
+	// 上报ID，重发同一份上报时不变，中心管理端据此忽略已处理过的上报
+	ID    string           `json:"id,omitempty"`
+	Usage map[string]int64 `json:"usage" binding:"required"`
+	// 入站标签 -> 字节数，只包含上报时能识别入站的流量，其余流量记为未知入站
+	Inbounds map[string]int64 `json:"inbounds,omitempty"`
+	// 上次上报后节点上活动过的设备，按用户ID和设备ID记录最近活动时间
+	Devices DeviceActivity `json:"devices,omitempty"`
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// 流量重置周期
+const (
+	ResetCycleNone    = ""
+	ResetCycleDaily   = "daily"
+	ResetCycleWeekly  = "weekly"
+	ResetCycleMonthly = "monthly"
+)
+
+// Plan 套餐模板，创建或更新用户时按套餐填入各项限制
+type Plan struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// 有效期天数，创建用户时从当前时间起算
+	DurationDays int         `json:"duration_days"`
+	TrafficLimit int64       `json:"traffic_limit"`
+	DeviceLimit  int         `json:"device_limit"`
+	SpeedLimit   *SpeedLimit `json:"speed_limit,omitempty"`
+	// 已用流量的重置周期，为空表示不重置
+	TrafficResetCycle string `json:"traffic_reset_cycle,omitempty"`
+	// 可使用的入站标签，为空表示不限制
+	AllowedInbounds []string  `json:"allowed_inbounds,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Duration 返回套餐的有效期
+func (p *Plan) Duration() time.Duration {
+	return time.Duration(p.DurationDays) * 24 * time.Hour
+}
+
+// CreatePlanRequest 创建套餐请求
+type CreatePlanRequest struct {
+	Name              string      `json:"name" binding:"required"`
+	DurationDays      int         `json:"duration_days" binding:"required,min=1"`
+	TrafficLimit      int64       `json:"traffic_limit" binding:"required,min=1"`
+	DeviceLimit       int         `json:"device_limit" binding:"required,min=1"`
+	SpeedLimit        *SpeedLimit `json:"speed_limit,omitempty"`
+	TrafficResetCycle string      `json:"traffic_reset_cycle,omitempty"`
+	AllowedInbounds   []string    `json:"allowed_inbounds,omitempty"`
+}
+
+// UpdatePlanRequest 更新套餐请求，已使用该套餐的用户不受影响
+type UpdatePlanRequest struct {
+	Name              *string     `json:"name,omitempty"`
+	DurationDays      *int        `json:"duration_days,omitempty" binding:"omitempty,min=1"`
+	TrafficLimit      *int64      `json:"traffic_limit,omitempty" binding:"omitempty,min=1"`
+	DeviceLimit       *int        `json:"device_limit,omitempty" binding:"omitempty,min=1"`
+	SpeedLimit        *SpeedLimit `json:"speed_limit,omitempty"`
+	TrafficResetCycle *string     `json:"traffic_reset_cycle,omitempty"`
+	AllowedInbounds   *[]string   `json:"allowed_inbounds,omitempty"`
+}
+
+// IsValidResetCycle 检查流量重置周期是否合法
+func IsValidResetCycle(cycle string) bool {
+	switch cycle {
+	case ResetCycleNone, ResetCycleDaily, ResetCycleWeekly, ResetCycleMonthly:
+		return true
+	}
+	return false
+}
+
+// NextResetAfter 返回from之后的下一次流量重置时间，不重置时返回nil
+func NextResetAfter(cycle string, from time.Time) *time.Time {
+	var next time.Time
+	switch cycle {
+	case ResetCycleDaily:
+		next = from.AddDate(0, 0, 1)
+	case ResetCycleWeekly:
+		next = from.AddDate(0, 0, 7)
+	case ResetCycleMonthly:
+		next = from.AddDate(0, 1, 0)
+	default:
+		return nil
+	}
+	return &next
+}
+
+// NormalizeInbounds 去除入站标签两端空白、空值和重复项
+func NormalizeInbounds(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
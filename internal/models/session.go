@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// Session 管理员登录会话，刷新令牌只保存哈希
+type Session struct {
+	ID          string    `json:"id"`
+	AdminID     string    `json:"admin_id"`
+	RefreshHash string    `json:"refresh_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ClientIP    string    `json:"client_ip"`
+	Revoked     bool      `json:"revoked"`
+}
+
+// IsValid 检查会话是否仍然有效
+func (s *Session) IsValid() bool {
+	return !s.Revoked && time.Now().Before(s.ExpiresAt)
+}
+
+// LoginRequest 登录请求
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	TOTPCode string `json:"totp_code,omitempty"`
+}
+
+// RefreshRequest 刷新令牌请求
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// ChangePasswordRequest 修改自己密码请求
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// TOTPCodeRequest 携带TOTP验证码的请求
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TokenPair 登录或刷新后签发的令牌
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // 访问令牌有效秒数
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// sing-box日志级别，按严重程度从低到高排列
+var singBoxLogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
+
+// SingBoxLogEntry 一行sing-box日志，能从日志中识别出的入站和用户会单独解析出来
+type SingBoxLogEntry struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	// sing-box为每个连接分配的ID，同一连接的多行日志ID相同
+	ConnectionID string `json:"connection_id,omitempty"`
+	Inbound      string `json:"inbound,omitempty"`
+	User         string `json:"user,omitempty"`
+	Message      string `json:"message"`
+}
+
+// SingBoxLogQuery sing-box日志查询条件，零值字段表示不过滤
+type SingBoxLogQuery struct {
+	// 最低日志级别，如warn会同时返回warn、error等更严重的日志
+	Level string
+	User  string
+	Since time.Time
+	Limit int
+}
+
+// Matches 检查日志是否满足查询条件
+func (q *SingBoxLogQuery) Matches(entry *SingBoxLogEntry) bool {
+	if q.Level != "" && SingBoxLogLevelRank(entry.Level) < SingBoxLogLevelRank(q.Level) {
+		return false
+	}
+	if q.User != "" && entry.User != q.User {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	return true
+}
+
+// SingBoxLogLevelRank 返回日志级别的严重程度，无法识别的级别返回-1
+func SingBoxLogLevelRank(level string) int {
+	level = strings.ToLower(level)
+	for i, l := range singBoxLogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsValidSingBoxLogLevel 检查日志级别是否合法
+func IsValidSingBoxLogLevel(level string) bool {
+	return SingBoxLogLevelRank(level) >= 0
+}
@@ -0,0 +1,23 @@
+package models
+
+// SpeedLimit 用户的带宽限制，速率单位为Kbps，0表示该方向不限速
+type SpeedLimit struct {
+	UploadKbps   int64 `json:"upload_kbps,omitempty" binding:"min=0"`
+	DownloadKbps int64 `json:"download_kbps,omitempty" binding:"min=0"`
+	// 允许短时超出速率的突发流量 (KB)，0表示按速率自动计算
+	BurstKB int64 `json:"burst_kb,omitempty" binding:"min=0"`
+}
+
+// IsZero 检查是否未设置任何限速
+func (l *SpeedLimit) IsZero() bool {
+	return l == nil || (l.UploadKbps <= 0 && l.DownloadKbps <= 0)
+}
+
+// NormalizeSpeedLimit 返回限速的副本，未设置任何限速时返回nil
+func NormalizeSpeedLimit(limit *SpeedLimit) *SpeedLimit {
+	if limit.IsZero() {
+		return nil
+	}
+	normalized := *limit
+	return &normalized
+}
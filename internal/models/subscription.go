@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// SubscriptionAccess 一次订阅拉取记录
+type SubscriptionAccess struct {
+	Timestamp time.Time `json:"timestamp"`
+	UserID    string    `json:"user_id"`
+	// 令牌前缀，用于区分轮换前后的令牌而不泄露完整令牌
+	TokenPrefix string `json:"token_prefix"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+	Format      string `json:"format"`
+}
+
+// SubscriptionAccessQuery 订阅拉取记录查询条件，零值字段表示不过滤
+type SubscriptionAccessQuery struct {
+	UserID string
+	From   time.Time
+	Limit  int
+}
+
+// Matches 检查记录是否满足查询条件
+func (q *SubscriptionAccessQuery) Matches(access *SubscriptionAccess) bool {
+	if q.UserID != "" && access.UserID != q.UserID {
+		return false
+	}
+	if !q.From.IsZero() && access.Timestamp.Before(q.From) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// TrialRecord 一次试用开通记录，用于按邮箱和IP限制试用次数
+type TrialRecord struct {
+	Email     string    `json:"email"`
+	UserID    string    `json:"user_id,omitempty"`
+	PlanID    string    `json:"plan_id"`
+	OwnerID   string    `json:"owner_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	InviteID  string    `json:"invite_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateTrialRequest 管理员直接开通试用请求，未指定套餐时使用默认试用套餐
+type CreateTrialRequest struct {
+	Email  string `json:"email" binding:"required,email"`
+	PlanID string `json:"plan_id,omitempty"`
+}
+
+// CreateTrialInviteRequest 生成试用邀请请求，未指定套餐时使用默认试用套餐
+type CreateTrialInviteRequest struct {
+	PlanID     string `json:"plan_id,omitempty"`
+	ValidHours int    `json:"valid_hours,omitempty" binding:"min=0"` // 默认168小时
+	MaxUses    int    `json:"max_uses,omitempty" binding:"min=0"`    // 可开通的试用次数，默认1次
+}
+
+// TrialInvite 签名的试用邀请，持有者可在有效期内自助开通试用
+type TrialInvite struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	PlanID    string    `json:"plan_id"`
+	OwnerID   string    `json:"owner_id,omitempty"`
+	MaxUses   int       `json:"max_uses"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ClaimTrialRequest 通过邀请自助开通试用请求
+type ClaimTrialRequest struct {
+	Invite string `json:"invite" binding:"required"`
+	Email  string `json:"email" binding:"required,email"`
+}
+
+// NormalizeEmail 统一邮箱格式，用于判断同一邮箱是否已试用
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -1,9 +1,22 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// 用户被停用的原因
+const (
+	// 管理员手动停用
+	DisabledReasonManual = "manual"
+	// 有效期已过，续期后自动恢复
+	DisabledReasonExpired = "expired"
+	// 流量用尽，充值或流量重置后自动恢复
+	DisabledReasonTraffic = "traffic_exceeded"
+	// 多次超出设备数限制被临时停用，到期后自动恢复
+	DisabledReasonSuspended = "suspended"
+)
+
 // User 用户数据模型
 type User struct {
 	ID            string    `json:"id"`
@@ -12,6 +25,9 @@ type User struct {
 	CreatedAt     time.Time `json:"created_at"`
 	ExpiresAt     time.Time `json:"expires_at"`
 	
+	// 所属分销商，为空表示由管理员直接管理
+	OwnerID string `json:"owner_id,omitempty"`
+	
 	// 流量限制 (字节)
 	TrafficLimit int64 `json:"traffic_limit"`
 	TrafficUsed  int64 `json:"traffic_used"`
@@ -19,11 +35,84 @@ type User struct {
 	// 设备数限制
 	DeviceLimit int `json:"device_limit"`
 	
-	// 当前连接设备
-	ConnectedDevices []string `json:"connected_devices"`
+	// 带宽限制，为空表示不限速
+	SpeedLimit *SpeedLimit `json:"speed_limit,omitempty"`
+	
+	// 创建或最近一次更新时使用的套餐，用于统计
+	PlanID string `json:"plan_id,omitempty"`
+	
+	// 试用用户，按套餐续期后转为正式用户
+	Trial bool `json:"trial,omitempty"`
+	
+	// 可使用的入站标签，为空表示不限制
+	AllowedInbounds []string `json:"allowed_inbounds,omitempty"`
+	
+	// 已用流量的重置周期和下一次重置时间
+	TrafficResetCycle string     `json:"traffic_reset_cycle,omitempty"`
+	NextTrafficReset  *time.Time `json:"next_traffic_reset,omitempty"`
+	
+	// 当前连接设备，空闲超时的设备会被自动移除
+	Devices []Device `json:"devices"`
+	
+	// 被移除的设备及禁止其重新连接的截止时间
+	RevokedDevices map[string]time.Time `json:"revoked_devices,omitempty"`
 	
 	// 状态
 	IsActive bool `json:"is_active"`
+	
+	// 停用原因和自动恢复时间，因到期或流量用尽停用的用户在续期、充值后自动恢复
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	DisabledUntil  *time.Time `json:"disabled_until,omitempty"`
+	
+	// 订阅令牌，用于公开的订阅链接
+	SubscriptionToken string `json:"subscription_token"`
+	
+	// 可使用的节点分组，只能使用未分组的节点和这些分组中的节点
+	AllowedGroups []string `json:"allowed_groups,omitempty"`
+	
+	// 订阅在一天内被过多不同IP拉取时标记，轮换令牌后清除
+	SubscriptionFlagged   bool       `json:"subscription_flagged"`
+	SubscriptionFlaggedAt *time.Time `json:"subscription_flagged_at,omitempty"`
+}
+
+// Clone 返回用户的深拷贝，用于记录变更前的状态
+func (u *User) Clone() *User {
+	clone := *u
+	if u.Devices != nil {
+		clone.Devices = make([]Device, len(u.Devices))
+		copy(clone.Devices, u.Devices)
+	}
+	if u.RevokedDevices != nil {
+		clone.RevokedDevices = make(map[string]time.Time, len(u.RevokedDevices))
+		for deviceID, until := range u.RevokedDevices {
+			clone.RevokedDevices[deviceID] = until
+		}
+	}
+	if u.AllowedGroups != nil {
+		clone.AllowedGroups = make([]string, len(u.AllowedGroups))
+		copy(clone.AllowedGroups, u.AllowedGroups)
+	}
+	if u.SpeedLimit != nil {
+		speedLimit := *u.SpeedLimit
+		clone.SpeedLimit = &speedLimit
+	}
+	if u.AllowedInbounds != nil {
+		clone.AllowedInbounds = make([]string, len(u.AllowedInbounds))
+		copy(clone.AllowedInbounds, u.AllowedInbounds)
+	}
+	if u.NextTrafficReset != nil {
+		nextReset := *u.NextTrafficReset
+		clone.NextTrafficReset = &nextReset
+	}
+	if u.DisabledUntil != nil {
+		disabledUntil := *u.DisabledUntil
+		clone.DisabledUntil = &disabledUntil
+	}
+	if u.SubscriptionFlaggedAt != nil {
+		flaggedAt := *u.SubscriptionFlaggedAt
+		clone.SubscriptionFlaggedAt = &flaggedAt
+	}
+	return &clone
 }
 
 // IsExpired 检查用户是否过期
@@ -36,6 +125,58 @@ func (u *User) IsTrafficExceeded() bool {
 	return u.TrafficUsed >= u.TrafficLimit
 }
 
+// CanAccessNode 检查用户是否可以使用属于nodeGroups分组的节点
+func (u *User) CanAccessNode(nodeGroups []string) bool {
+	if len(nodeGroups) == 0 {
+		return true
+	}
+	
+	for _, group := range nodeGroups {
+		for _, allowed := range u.AllowedGroups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExhaustedReason 返回用户因到期或流量用尽应被停用的原因，仍可使用时返回空字符串
+func (u *User) ExhaustedReason() string {
+	if u.IsExpired() {
+		return DisabledReasonExpired
+	}
+	if u.IsTrafficExceeded() {
+		return DisabledReasonTraffic
+	}
+	return ""
+}
+
+// IsRecoverable 检查用户是否因到期或流量用尽被停用，且现在已不再到期或超限
+func (u *User) IsRecoverable() bool {
+	if u.IsActive {
+		return false
+	}
+	if u.DisabledReason != DisabledReasonExpired && u.DisabledReason != DisabledReasonTraffic {
+		return false
+	}
+	return u.ExhaustedReason() == ""
+}
+
+// CanUseInbound 检查用户是否可以使用指定入站
+func (u *User) CanUseInbound(tag string) bool {
+	if len(u.AllowedInbounds) == 0 {
+		return true
+	}
+	
+	for _, allowed := range u.AllowedInbounds {
+		if allowed == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // CanConnect 检查用户是否可以连接
 func (u *User) CanConnect(deviceID string) bool {
 	if !u.IsActive || u.IsExpired() || u.IsTrafficExceeded() {
@@ -43,29 +184,112 @@ func (u *User) CanConnect(deviceID string) bool {
 	}
 	
 	// 如果设备已连接，允许
-	for _, device := range u.ConnectedDevices {
-		if device == deviceID {
-			return true
-		}
+	if u.FindDevice(deviceID) >= 0 {
+		return true
 	}
 	
 	// 检查设备数限制
-	return len(u.ConnectedDevices) < u.DeviceLimit
+	return len(u.Devices) < u.DeviceLimit
+}
+
+// FindDevice 返回设备在列表中的位置，不存在时返回-1
+func (u *User) FindDevice(deviceID string) int {
+	for i := range u.Devices {
+		if u.Devices[i].ID == deviceID {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsDeviceRevoked 检查设备是否已被移除且仍在禁止连接期内
+func (u *User) IsDeviceRevoked(deviceID string, now time.Time) bool {
+	until, exists := u.RevokedDevices[deviceID]
+	return exists && now.Before(until)
+}
+
+// ConnectedDeviceIDs 返回当前连接设备的ID列表
+func (u *User) ConnectedDeviceIDs() []string {
+	ids := make([]string, 0, len(u.Devices))
+	for _, device := range u.Devices {
+		ids = append(ids, device.ID)
+	}
+	return ids
+}
+
+// DeviceLastSeen 返回各设备最近一次活动时间
+func (u *User) DeviceLastSeen() map[string]time.Time {
+	lastSeen := make(map[string]time.Time, len(u.Devices))
+	for _, device := range u.Devices {
+		lastSeen[device.ID] = device.LastSeen
+	}
+	return lastSeen
+}
+
+// MarshalJSON 在devices之外继续输出旧版的connected_devices和device_last_seen，兼容旧客户端
+func (u User) MarshalJSON() ([]byte, error) {
+	type plainUser User
+	return json.Marshal(struct {
+		plainUser
+		ConnectedDevices []string             `json:"connected_devices"`
+		DeviceLastSeen   map[string]time.Time `json:"device_last_seen,omitempty"`
+	}{
+		plainUser:        plainUser(u),
+		ConnectedDevices: u.ConnectedDeviceIDs(),
+		DeviceLastSeen:   u.DeviceLastSeen(),
+	})
+}
+
+// UnmarshalJSON 兼容旧版数据：connected_devices为设备ID列表，device_last_seen为各设备最近活动时间
+func (u *User) UnmarshalJSON(data []byte) error {
+	type plainUser User
+	aux := struct {
+		*plainUser
+		ConnectedDevices []string             `json:"connected_devices"`
+		DeviceLastSeen   map[string]time.Time `json:"device_last_seen"`
+	}{plainUser: (*plainUser)(u)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	
+	if u.Devices == nil && len(aux.ConnectedDevices) > 0 {
+		u.Devices = make([]Device, 0, len(aux.ConnectedDevices))
+		for _, deviceID := range aux.ConnectedDevices {
+			lastSeen := aux.DeviceLastSeen[deviceID]
+			u.Devices = append(u.Devices, Device{ID: deviceID, FirstSeen: lastSeen, LastSeen: lastSeen})
+		}
+	}
+	return nil
 }
 
 // CreateUserRequest 创建用户请求
+// 指定套餐时未填写的有效期和各项限制取自套餐，未指定套餐时有效期、流量和设备数必填
 type CreateUserRequest struct {
-	Username     string `json:"username" binding:"required"`
-	Password     string `json:"password" binding:"required"`
-	ExpiresAt    string `json:"expires_at" binding:"required"` // RFC3339格式
-	TrafficLimit int64  `json:"traffic_limit" binding:"required"`
-	DeviceLimit  int    `json:"device_limit" binding:"required"`
+	Username          string      `json:"username" binding:"required"`
+	Password          string      `json:"password" binding:"required"`
+	PlanID            string      `json:"plan_id,omitempty"`
+	ExpiresAt         string      `json:"expires_at,omitempty"` // RFC3339格式
+	TrafficLimit      int64       `json:"traffic_limit,omitempty" binding:"min=0"`
+	DeviceLimit       int         `json:"device_limit,omitempty" binding:"min=0"`
+	OwnerID           string      `json:"owner_id,omitempty"` // 分销商创建时强制为自己
+	AllowedGroups     []string    `json:"allowed_groups,omitempty"`
+	SpeedLimit        *SpeedLimit `json:"speed_limit,omitempty"`
+	AllowedInbounds   []string    `json:"allowed_inbounds,omitempty"`
+	TrafficResetCycle string      `json:"traffic_reset_cycle,omitempty"`
+	Trial             bool        `json:"-"` // 仅由试用开通流程设置
 }
 
 // UpdateUserRequest 更新用户请求
 type UpdateUserRequest struct {
-	ExpiresAt    *string `json:"expires_at,omitempty"`
-	TrafficLimit *int64  `json:"traffic_limit,omitempty"`
-	DeviceLimit  *int    `json:"device_limit,omitempty"`
-	IsActive     *bool   `json:"is_active,omitempty"`
+	ExpiresAt     *string   `json:"expires_at,omitempty"`
+	TrafficLimit  *int64    `json:"traffic_limit,omitempty"`
+	DeviceLimit   *int      `json:"device_limit,omitempty"`
+	IsActive      *bool     `json:"is_active,omitempty"`
+	AllowedGroups *[]string `json:"allowed_groups,omitempty"`
+	// 各速率均为0时取消限速
+	SpeedLimit *SpeedLimit `json:"speed_limit,omitempty"`
+	// 指定套餐时未填写的各项限制取自套餐，有效期不变
+	PlanID            *string   `json:"plan_id,omitempty"`
+	AllowedInbounds   *[]string `json:"allowed_inbounds,omitempty"`
+	TrafficResetCycle *string   `json:"traffic_reset_cycle,omitempty"`
 }
\ No newline at end of file
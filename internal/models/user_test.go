@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserJSONKeepsLegacyDeviceFields(t *testing.T) {
+	seen := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := &User{ID: "u1", Devices: []Device{{ID: "ip-a", FirstSeen: seen, LastSeen: seen}}}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["connected_devices"]) != `["ip-a"]` {
+		t.Errorf("connected_devices = %s", fields["connected_devices"])
+	}
+	if string(fields["device_last_seen"]) != `{"ip-a":"2026-01-02T03:04:05Z"}` {
+		t.Errorf("device_last_seen = %s", fields["device_last_seen"])
+	}
+
+	// 值类型同样输出旧字段
+	if value, _ := json.Marshal(*user); !strings.Contains(string(value), `"connected_devices":["ip-a"]`) {
+		t.Fatalf("value marshal = %s", value)
+	}
+
+	var decoded User
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Devices, user.Devices) {
+		t.Fatalf("round trip devices = %+v", decoded.Devices)
+	}
+}
+
+func TestUserUnmarshalLegacyDevices(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"legacy only", `{"connected_devices":["a","b"],"device_last_seen":{"a":"2026-01-01T00:00:00Z"}}`, []string{"a", "b"}},
+		{"devices take precedence", `{"devices":[{"id":"c"}],"connected_devices":["a"]}`, []string{"c"}},
+		{"no devices", `{}`, []string{}},
+	}
+	for _, tt := range tests {
+		var user User
+		if err := json.Unmarshal([]byte(tt.data), &user); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := user.ConnectedDeviceIDs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: devices = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
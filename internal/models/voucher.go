@@ -0,0 +1,125 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// 兑换码状态，用于查询过滤
+const (
+	VoucherStatusUnused   = "unused"
+	VoucherStatusUsed     = "used"
+	VoucherStatusExpired  = "expired"
+	VoucherStatusDisabled = "disabled"
+)
+
+// Voucher 兑换码，兑换后为用户续期或充值流量
+type Voucher struct {
+	Code    string `json:"code"`
+	BatchID string `json:"batch_id"`
+
+	// 兑换内容：指定套餐时按套餐续期并应用套餐的各项限制，否则按天数续期和充值流量
+	PlanID       string `json:"plan_id,omitempty"`
+	DurationDays int    `json:"duration_days,omitempty"`
+	TrafficBytes int64  `json:"traffic_bytes,omitempty"`
+
+	// 可兑换次数，同一用户只能兑换一次
+	MaxRedemptions int                 `json:"max_redemptions"`
+	Redemptions    []VoucherRedemption `json:"redemptions"`
+	ExpiresAt      *time.Time          `json:"expires_at,omitempty"`
+	Disabled       bool                `json:"disabled"`
+
+	// 所属分销商，分销商的兑换码只能由其名下用户兑换
+	OwnerID   string    `json:"owner_id,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// VoucherRedemption 一次兑换记录
+type VoucherRedemption struct {
+	UserID     string    `json:"user_id"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	IP         string    `json:"ip,omitempty"`
+}
+
+// Status 返回兑换码当前的状态
+func (v *Voucher) Status(now time.Time) string {
+	switch {
+	case v.Disabled:
+		return VoucherStatusDisabled
+	case len(v.Redemptions) >= v.MaxRedemptions:
+		return VoucherStatusUsed
+	case v.ExpiresAt != nil && now.After(*v.ExpiresAt):
+		return VoucherStatusExpired
+	}
+	return VoucherStatusUnused
+}
+
+// RedeemedBy 检查用户是否已兑换过该兑换码
+func (v *Voucher) RedeemedBy(userID string) bool {
+	for _, redemption := range v.Redemptions {
+		if redemption.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateVouchersRequest 批量生成兑换码请求，需指定套餐、续期天数或充值流量中的至少一项
+type CreateVouchersRequest struct {
+	Count          int    `json:"count" binding:"required,min=1,max=1000"`
+	PlanID         string `json:"plan_id,omitempty"`
+	DurationDays   int    `json:"duration_days,omitempty" binding:"min=0"`
+	TrafficBytes   int64  `json:"traffic_bytes,omitempty" binding:"min=0"`
+	MaxRedemptions int    `json:"max_redemptions,omitempty" binding:"min=0"` // 默认1，即单次使用
+	ExpiresAt      string `json:"expires_at,omitempty"`                      // RFC3339格式
+	Note           string `json:"note,omitempty" binding:"max=256"`
+}
+
+// RedeemVoucherRequest 兑换请求，用户通过订阅令牌认证
+type RedeemVoucherRequest struct {
+	Token string `json:"token" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// VoucherQuery 兑换码查询条件，零值字段表示不过滤
+type VoucherQuery struct {
+	BatchID string
+	Status  string
+	OwnerID string
+}
+
+// Matches 检查兑换码是否满足查询条件
+func (q *VoucherQuery) Matches(v *Voucher, now time.Time) bool {
+	if q.BatchID != "" && v.BatchID != q.BatchID {
+		return false
+	}
+	if q.Status != "" && v.Status(now) != q.Status {
+		return false
+	}
+	if q.OwnerID != "" && v.OwnerID != q.OwnerID {
+		return false
+	}
+	return true
+}
+
+// NormalizeVoucherCode 统一兑换码格式：转为大写，去除空白和分隔符后每4位用-分隔
+func NormalizeVoucherCode(code string) string {
+	var compact strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			compact.WriteRune(r)
+		}
+	}
+
+	raw := compact.String()
+	var formatted strings.Builder
+	for i, r := range raw {
+		if i > 0 && i%4 == 0 {
+			formatted.WriteByte('-')
+		}
+		formatted.WriteRune(r)
+	}
+	return formatted.String()
+}
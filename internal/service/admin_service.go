@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength 管理员密码最小长度
+const minPasswordLength = 8
+
+// AdminService 管理员账号服务
+type AdminService struct {
+	admins   *storage.AdminStorage
+	sessions *storage.SessionStorage
+}
+
+// NewAdminService 创建管理员服务，重置密码时通过sessions吊销该账号的会话
+func NewAdminService(admins *storage.AdminStorage, sessions *storage.SessionStorage) *AdminService {
+	return &AdminService{
+		admins:   admins,
+		sessions: sessions,
+	}
+}
+
+// CreateAdmin 创建管理员
+func (s *AdminService) CreateAdmin(req *models.CreateAdminRequest) (*models.Admin, error) {
+	if !models.IsValidRole(req.Role) {
+		return nil, fmt.Errorf("invalid role %s", req.Role)
+	}
+
+	admin := &models.Admin{
+		ID:         uuid.New().String(),
+		Username:   req.Username,
+		Role:       req.Role,
+		CreatedAt:  time.Now(),
+		IsActive:   true,
+		MaxUsers:   req.MaxUsers,
+		MaxTraffic: req.MaxTraffic,
+	}
+
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		admin.PasswordHash = hash
+	}
+
+	if err := s.admins.CreateAdmin(admin); err != nil {
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// GetAdmin 获取管理员
+func (s *AdminService) GetAdmin(id string) (*models.Admin, error) {
+	return s.admins.GetAdmin(id)
+}
+
+// ListAdmins 列出所有管理员
+func (s *AdminService) ListAdmins() ([]*models.Admin, error) {
+	return s.admins.ListAdmins()
+}
+
+// UpdateAdmin 更新管理员，在存储的锁内修改记录的副本，已返回给调用方的记录不会被修改
+func (s *AdminService) UpdateAdmin(id string, req *models.UpdateAdminRequest) (*models.Admin, error) {
+	if req.Role != nil && !models.IsValidRole(*req.Role) {
+		return nil, fmt.Errorf("invalid role %s", *req.Role)
+	}
+
+	existing, err := s.admins.GetAdmin(id)
+	if err != nil {
+		return nil, err
+	}
+	// 降级或停用超级管理员前确保还有其他可用的超级管理员
+	demoted := req.Role != nil && *req.Role != models.RoleAdmin
+	disabled := req.IsActive != nil && !*req.IsActive
+	if existing.Role == models.RoleAdmin && (demoted || disabled) {
+		if err := s.ensureOtherAdmin(id); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.admins.ModifyAdmin(id, func(admin *models.Admin) error {
+		if req.Role != nil {
+			admin.Role = *req.Role
+		}
+		if req.MaxUsers != nil {
+			admin.MaxUsers = *req.MaxUsers
+		}
+		if req.MaxTraffic != nil {
+			admin.MaxTraffic = *req.MaxTraffic
+		}
+		if req.IsActive != nil {
+			admin.IsActive = *req.IsActive
+		}
+		return nil
+	})
+}
+
+// DeleteAdmin 删除管理员
+func (s *AdminService) DeleteAdmin(id string) error {
+	admin, err := s.admins.GetAdmin(id)
+	if err != nil {
+		return err
+	}
+
+	if admin.Role == models.RoleAdmin {
+		if err := s.ensureOtherAdmin(id); err != nil {
+			return err
+		}
+	}
+
+	return s.admins.DeleteAdmin(id)
+}
+
+// SetPassword 设置管理员登录密码，并吊销该账号的所有会话和刷新令牌
+func (s *AdminService) SetPassword(id, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.admins.ModifyAdmin(id, func(admin *models.Admin) error {
+		admin.PasswordHash = hash
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeAdminSessions(id)
+}
+
+// ensureOtherAdmin 确保除指定账号外还存在可用的超级管理员，防止系统被锁死
+func (s *AdminService) ensureOtherAdmin(id string) error {
+	admins, err := s.admins.ListAdmins()
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range admins {
+		if admin.ID != id && admin.Role == models.RoleAdmin && admin.IsActive {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot remove the last active admin")
+}
+
+// hashPassword 使用bcrypt计算密码哈希
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash 用户名不存在时参与比较的哈希，使登录耗时与用户名存在时一致
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(uuid.New().String()), bcrypt.DefaultCost)
+	if err != nil {
+		return ""
+	}
+	return string(hash)
+})
+
+// checkPassword 校验密码是否与哈希匹配
+func checkPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
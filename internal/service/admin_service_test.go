@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestUpdateAdmin(t *testing.T) {
+	s := newTestServices(t)
+	s.addAdmin(t, &models.Admin{ID: "root", Username: "root", Role: models.RoleAdmin})
+	reseller := s.addAdmin(t, &models.Admin{ID: "reseller", Username: "reseller", Role: models.RoleReseller, MaxUsers: 1})
+
+	maxUsers := 5
+	updated, err := s.admin.UpdateAdmin(reseller.ID, &models.UpdateAdminRequest{MaxUsers: &maxUsers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 更新在副本上进行，之前读到的记录不变
+	if updated.MaxUsers != 5 || reseller.MaxUsers != 1 {
+		t.Errorf("updated = %d, earlier copy = %d", updated.MaxUsers, reseller.MaxUsers)
+	}
+
+	operator, invalid, inactive := models.RoleOperator, "owner", false
+	tests := []struct {
+		name    string
+		id      string
+		req     *models.UpdateAdminRequest
+		wantErr bool
+	}{
+		{"invalid role", reseller.ID, &models.UpdateAdminRequest{Role: &invalid}, true},
+		{"demote last admin", "root", &models.UpdateAdminRequest{Role: &operator}, true},
+		{"disable last admin", "root", &models.UpdateAdminRequest{IsActive: &inactive}, true},
+		{"disable reseller", reseller.ID, &models.UpdateAdminRequest{IsActive: &inactive}, false},
+		{"unknown admin", "missing", &models.UpdateAdminRequest{MaxUsers: &maxUsers}, true},
+	}
+	for _, tt := range tests {
+		if _, err := s.admin.UpdateAdmin(tt.id, tt.req); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+
+	root, err := s.admin.GetAdmin("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Role != models.RoleAdmin || !root.IsActive {
+		t.Errorf("root = %+v", root)
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// NodeTokenHeader 节点调用中心管理端时携带令牌的请求头
+const NodeTokenHeader = "X-Node-Token"
+
+// AgentService agent模式下与中心管理端同步用户、入站定义和流量
+type AgentService struct {
+	managerURL    string
+	token         string
+	storage       *storage.JSONStorage
+	state         *storage.AgentStateStorage
+	configService *ConfigService
+	interval      time.Duration
+	client        *http.Client
+	cpu           cpuSampler
+
+	// 最近一次写入配置的内容摘要，未变化时不重载sing-box
+	lastDigest string
+
+	// 最近一次上报设备活动的时间，之后的活动会在下次同步时上报
+	lastDeviceReport time.Time
+}
+
+// NewAgentService 创建agent同步服务
+func NewAgentService(managerURL, token string, storage *storage.JSONStorage, state *storage.AgentStateStorage, configService *ConfigService, interval time.Duration) *AgentService {
+	return &AgentService{
+		managerURL:    strings.TrimRight(managerURL, "/"),
+		token:         token,
+		storage:       storage,
+		state:         state,
+		configService: configService,
+		interval:      interval,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Run 立即同步一次，之后按固定间隔同步
+func (s *AgentService) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(context.Background()); err != nil {
+			slog.Error("Failed to sync with manager", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Sync 发送心跳并上报本地新增流量，再拉取用户和入站定义，配置有变化时重新生成并重载sing-box
+// 心跳失败只记录日志，不影响流量上报和配置同步
+func (s *AgentService) Sync(ctx context.Context) error {
+	if err := s.sendHeartbeat(ctx); err != nil {
+		slog.WarnContext(ctx, "Heartbeat failed, continuing sync", "error", err)
+	}
+
+	baseline := s.state.TrafficBaseline()
+
+	// 上次发送结果未知的上报原样重发，中心管理端已处理过时会直接确认
+	if pending := s.state.PendingReport(); pending != nil {
+		if err := s.deliverReport(ctx, pending, baseline); err != nil {
+			return err
+		}
+	}
+
+	reportedAt := time.Now()
+	if report := s.buildReport(baseline); report != nil {
+		if err := s.state.SetPendingReport(report); err != nil {
+			return fmt.Errorf("failed to save pending traffic report: %v", err)
+		}
+		if err := s.deliverReport(ctx, report, baseline); err != nil {
+			return err
+		}
+	}
+	s.lastDeviceReport = reportedAt
+
+	config, err := s.fetchConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 记录下发时的用量作为新基线，ReplaceUsers会在此基础上累加未上报的本地流量
+	newBaseline := make(map[string]int64, len(config.Users))
+	for _, user := range config.Users {
+		newBaseline[user.ID] = user.TrafficUsed
+	}
+
+	// 上报之后新识别的设备尚未同步到中心管理端，替换用户后重新写入
+	unreported := s.storage.DeviceActivitySince(reportedAt)
+	if err := s.storage.ReplaceUsers(config.Users, baseline); err != nil {
+		return fmt.Errorf("failed to store users: %v", err)
+	}
+	if err := s.storage.TouchDevices(unreported); err != nil {
+		return fmt.Errorf("failed to restore device activity: %v", err)
+	}
+	if err := s.state.SetTrafficBaseline(newBaseline); err != nil {
+		return fmt.Errorf("failed to save traffic baseline: %v", err)
+	}
+
+	s.configService.SetNodeGroups(config.Node.Groups)
+	s.configService.SetInboundTemplates(config.Inbounds)
+	return s.applyConfig(ctx, config.Inbounds)
+}
+
+// sendHeartbeat 上报本机sing-box和系统的运行状态
+func (s *AgentService) sendHeartbeat(ctx context.Context) error {
+	memoryUsed, memoryTotal := readMemory()
+	status := &models.NodeStatus{
+		SingBoxVersion: singBoxVersion(),
+		SingBoxRunning: s.configService.IsSingBoxRunning(),
+		UptimeSeconds:  readUptime(),
+		CPUPercent:     s.cpu.Sample(),
+		MemoryUsed:     memoryUsed,
+		MemoryTotal:    memoryTotal,
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(ctx, http.MethodPost, "/api/agent/heartbeat", bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to send heartbeat: %v", err)
+	}
+	return nil
+}
+
+// buildReport 生成基线之后本地新增的流量和上次上报后活动过的设备的上报，没有需要上报的内容时返回nil
+func (s *AgentService) buildReport(baseline map[string]int64) *models.TrafficReport {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return nil
+	}
+
+	usage := make(map[string]int64)
+	for _, user := range users {
+		known, exists := baseline[user.ID]
+		if !exists {
+			continue
+		}
+		if delta := user.TrafficUsed - known; delta > 0 {
+			usage[user.ID] = delta
+		}
+	}
+	devices := s.storage.DeviceActivitySince(s.lastDeviceReport)
+	if len(usage) == 0 && len(devices) == 0 {
+		return nil
+	}
+
+	return &models.TrafficReport{
+		ID:       uuid.New().String(),
+		Usage:    usage,
+		Inbounds: s.storage.TakeInboundTraffic(),
+		Devices:  devices,
+	}
+}
+
+// deliverReport 发送流量上报，确认后将上报的流量计入baseline并保存，同时清除未确认的上报
+func (s *AgentService) deliverReport(ctx context.Context, report *models.TrafficReport, baseline map[string]int64) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	if err := s.do(ctx, http.MethodPost, "/api/agent/traffic", bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to report traffic: %v", err)
+	}
+
+	for id, used := range report.Usage {
+		baseline[id] += used
+	}
+	if err := s.state.CompleteReport(baseline); err != nil {
+		return fmt.Errorf("failed to save traffic baseline: %v", err)
+	}
+	return nil
+}
+
+// fetchConfig 拉取中心管理端下发的配置
+func (s *AgentService) fetchConfig(ctx context.Context) (*AgentConfig, error) {
+	var config AgentConfig
+	if err := s.do(ctx, http.MethodGet, "/api/agent/config", nil, &config); err != nil {
+		return nil, fmt.Errorf("failed to fetch config: %v", err)
+	}
+	return &config, nil
+}
+
+// applyConfig 配置内容有变化时重新生成配置并重载sing-box
+func (s *AgentService) applyConfig(ctx context.Context, inbounds []Inbound) error {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	digest := configDigest(inbounds, s.configService.NodeGroups(), users)
+	if digest == s.lastDigest {
+		return nil
+	}
+
+	if err := s.configService.GenerateConfig(ctx); err != nil {
+		return err
+	}
+	if err := s.configService.ReloadSingBox(ctx); err != nil {
+		return err
+	}
+
+	s.lastDigest = digest
+	return nil
+}
+
+// do 向中心管理端发送请求，out不为nil时解析JSON响应
+func (s *AgentService) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.managerURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(NodeTokenHeader, s.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("manager returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// configDigest 计算影响sing-box配置的内容摘要：入站定义以及可连接本节点的用户的认证信息和可用入站
+// 用户的筛选条件与ConfigService.GenerateConfig一致，分组变化导致可连接用户变化时摘要随之变化
+func configDigest(inbounds []Inbound, nodeGroups []string, users []*models.User) string {
+	// 存储返回的用户顺序不固定，排序后再计算
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	encoder.Encode(inbounds)
+	for _, user := range users {
+		if !user.IsActive || user.IsExpired() || user.IsTrafficExceeded() || !user.CanAccessNode(nodeGroups) {
+			continue
+		}
+		allowedInbounds := append([]string(nil), user.AllowedInbounds...)
+		sort.Strings(allowedInbounds)
+		encoder.Encode([]interface{}{user.ID, user.Username, user.Password, allowedInbounds})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
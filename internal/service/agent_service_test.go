@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestConfigDigest(t *testing.T) {
+	inbounds := []Inbound{{Type: "vless", Tag: "vless-in", ListenPort: 443}}
+	newUser := func() *models.User {
+		return &models.User{
+			ID:              "u1",
+			Username:        "alice",
+			Password:        "secret",
+			IsActive:        true,
+			ExpiresAt:       time.Now().Add(24 * time.Hour),
+			TrafficLimit:    1000,
+			AllowedInbounds: []string{"vless-in", "trojan-in"},
+			AllowedGroups:   []string{"hk"},
+		}
+	}
+	base := configDigest(inbounds, []string{"hk"}, []*models.User{newUser()})
+
+	tests := []struct {
+		name       string
+		nodeGroups []string
+		modify     func(user *models.User)
+		changed    bool
+	}{
+		{"allowed inbounds changed", []string{"hk"}, func(user *models.User) { user.AllowedInbounds = []string{"vless-in"} }, true},
+		{"allowed inbounds cleared", []string{"hk"}, func(user *models.User) { user.AllowedInbounds = nil }, true},
+		{"allowed groups no longer match", []string{"hk"}, func(user *models.User) { user.AllowedGroups = []string{"jp"} }, true},
+		{"node groups changed", []string{"jp"}, func(user *models.User) {}, true},
+		{"password changed", []string{"hk"}, func(user *models.User) { user.Password = "rotated" }, true},
+		{"traffic exceeded", []string{"hk"}, func(user *models.User) { user.TrafficUsed = 1000 }, true},
+		{"allowed inbounds reordered", []string{"hk"}, func(user *models.User) { user.AllowedInbounds = []string{"trojan-in", "vless-in"} }, false},
+		{"traffic still under limit", []string{"hk"}, func(user *models.User) { user.TrafficUsed = 500 }, false},
+		{"another matching group added", []string{"hk"}, func(user *models.User) { user.AllowedGroups = []string{"hk", "jp"} }, false},
+	}
+	for _, tt := range tests {
+		user := newUser()
+		tt.modify(user)
+		got := configDigest(inbounds, tt.nodeGroups, []*models.User{user})
+		if (got != base) != tt.changed {
+			t.Errorf("%s: digest changed = %v, want %v", tt.name, got != base, tt.changed)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// 审计操作名称
+const (
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserDelete       = "user.delete"
+	AuditUserConnect      = "user.device_connect"
+	AuditUserDisconnect   = "user.device_disconnect"
+	AuditUserTraffic      = "user.traffic_update"
+	AuditUserSubRotate    = "user.subscription_rotate"
+	AuditUserSubFlag      = "user.subscription_flag"
+	AuditUserDeviceLimit  = "user.device_limit_violation"
+	AuditUserDeviceRename = "user.device_rename"
+	AuditUserDeviceRevoke = "user.device_revoke"
+	AuditUserSuspend      = "user.suspend"
+	AuditUserReactivate   = "user.reactivate"
+	AuditUserTrafficReset = "user.traffic_reset"
+	AuditUserDisable      = "user.disable"
+	AuditUserRenew        = "user.renew"
+	AuditUserTopUp        = "user.topup"
+	AuditUserTrialCreate  = "user.trial_create"
+	AuditUserTrialConvert = "user.trial_convert"
+	AuditConfigGenerate   = "config.generate"
+	AuditConfigReload     = "config.reload"
+	AuditConfigRestart    = "config.restart"
+	AuditConfigRealityKey = "config.reality_keypair"
+	AuditNodeCreate       = "node.create"
+	AuditNodeUpdate       = "node.update"
+	AuditNodeDelete       = "node.delete"
+	AuditNodeRotateToken  = "node.rotate_token"
+	AuditNodeTraffic      = "node.traffic_report"
+	AuditPlanCreate       = "plan.create"
+	AuditPlanUpdate       = "plan.update"
+	AuditPlanDelete       = "plan.delete"
+	AuditVoucherCreate    = "voucher.create"
+	AuditVoucherDisable   = "voucher.disable"
+	AuditVoucherRedeem    = "voucher.redeem"
+	AuditTrialInvite      = "trial.invite_create"
+)
+
+// redactedFields 审计差异中需要隐藏具体值的字段
+var redactedFields = map[string]bool{
+	"password":           true,
+	"subscription_token": true,
+}
+
+// Actor 发起操作的主体
+type Actor struct {
+	Type string
+	ID   string
+	Name string
+	IP   string
+}
+
+// systemActor 后台任务等非请求触发的操作者
+var systemActor = Actor{Type: models.ActorTypeSystem, Name: "system"}
+
+type actorContextKey struct{}
+
+// WithActor 将操作者写入上下文
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext 从上下文读取操作者，不存在时视为系统操作
+func ActorFromContext(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorContextKey{}).(Actor); ok {
+		return actor
+	}
+	return systemActor
+}
+
+// AuditService 审计日志服务
+type AuditService struct {
+	storage *storage.AuditStorage
+}
+
+// NewAuditService 创建审计服务
+func NewAuditService(storage *storage.AuditStorage) *AuditService {
+	return &AuditService{
+		storage: storage,
+	}
+}
+
+// Record 记录一次变更，before/after为变更前后的对象，创建时before为nil，删除时after为nil
+// 审计写入失败只记录日志，不影响业务操作
+func (s *AuditService) Record(ctx context.Context, action, targetType, targetID string, before, after interface{}) {
+	actor := ActorFromContext(ctx)
+	event := &models.AuditEvent{
+		ID:         uuid.New().String(),
+		Timestamp:  time.Now(),
+		ActorType:  actor.Type,
+		ActorID:    actor.ID,
+		Actor:      actor.Name,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    diffFields(before, after),
+		SourceIP:   actor.IP,
+	}
+
+	if err := s.storage.Append(event); err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit event", "action", action, "error", err)
+	}
+}
+
+// Query 查询审计事件，按时间倒序返回最多query.Limit条
+func (s *AuditService) Query(query *models.AuditQuery) ([]*models.AuditEvent, error) {
+	events := make([]*models.AuditEvent, 0)
+	err := s.storage.Scan(func(event *models.AuditEvent) bool {
+		if query.Matches(event) {
+			events = append(events, event)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件按时间顺序追加，反转后最新的在前
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	if query.Limit > 0 && len(events) > query.Limit {
+		events = events[:query.Limit]
+	}
+
+	return events, nil
+}
+
+// Export 以JSON Lines格式按时间顺序导出满足条件的审计事件
+func (s *AuditService) Export(w io.Writer, query *models.AuditQuery) error {
+	encoder := json.NewEncoder(w)
+	var writeErr error
+	err := s.storage.Scan(func(event *models.AuditEvent) bool {
+		if !query.Matches(event) {
+			return true
+		}
+		writeErr = encoder.Encode(event)
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// diffFields 比较两个对象JSON序列化后的字段，返回发生变化的字段
+func diffFields(before, after interface{}) map[string]models.FieldChange {
+	beforeFields := toFieldMap(before)
+	afterFields := toFieldMap(after)
+
+	changes := make(map[string]models.FieldChange)
+	for name, value := range beforeFields {
+		if afterValue, exists := afterFields[name]; !exists || !reflect.DeepEqual(value, afterValue) {
+			changes[name] = models.FieldChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, exists := beforeFields[name]; !exists {
+			changes[name] = models.FieldChange{After: value}
+		}
+	}
+
+	for name, change := range changes {
+		if redactedFields[name] {
+			if change.Before != nil {
+				change.Before = "***"
+			}
+			if change.After != nil {
+				change.After = "***"
+			}
+			changes[name] = change
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// toFieldMap 将对象转换为字段映射，nil返回空映射
+func toFieldMap(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
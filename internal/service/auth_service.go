@@ -0,0 +1,253 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix API密钥明文前缀，便于在日志和配置中识别
+const apiKeyPrefix = "sbm_"
+
+// bootstrapAdminUsername 首次启动时创建的超级管理员用户名
+const bootstrapAdminUsername = "admin"
+
+// ErrInvalidAPIKey 密钥不存在、已吊销或已过期
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// ErrKeyNotPermitted 创建者无权签发请求的密钥
+var ErrKeyNotPermitted = errors.New("api key not permitted")
+
+// AuthService API密钥认证服务
+type AuthService struct {
+	keys   *storage.APIKeyStorage
+	admins *storage.AdminStorage
+}
+
+// NewAuthService 创建认证服务
+func NewAuthService(keys *storage.APIKeyStorage, admins *storage.AdminStorage) *AuthService {
+	return &AuthService{
+		keys:   keys,
+		admins: admins,
+	}
+}
+
+// Bootstrap 首次启动时创建超级管理员和拥有全部权限的初始密钥
+// rawKey为空时随机生成并打印到日志，已存在任何密钥时不再创建
+// password非空且超级管理员尚未设置密码时，将其设为登录密码
+// 旧版本创建的未绑定管理员的密钥会被绑定到初始超级管理员
+func (s *AuthService) Bootstrap(rawKey, password string) error {
+	admin, err := s.bootstrapAdmin()
+	if err != nil {
+		return err
+	}
+
+	if password != "" && admin.PasswordHash == "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap admin password: %v", err)
+		}
+		admin, err = s.admins.ModifyAdmin(admin.ID, func(admin *models.Admin) error {
+			admin.PasswordHash = hash
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set bootstrap admin password: %v", err)
+		}
+		slog.Info("Set login password for admin from environment", "admin", admin.Username)
+	}
+
+	bound, err := s.keys.BindUnownedAPIKeys(admin.ID)
+	if err != nil {
+		return fmt.Errorf("failed to bind api keys to admin: %v", err)
+	}
+	if bound > 0 {
+		slog.Info("Bound existing API keys to admin", "count", bound, "admin", admin.Username)
+	}
+
+	if s.keys.Count() > 0 {
+		return nil
+	}
+
+	generated := rawKey == ""
+	if generated {
+		var err error
+		if rawKey, err = generateAPIKey(); err != nil {
+			return err
+		}
+	}
+
+	key := newAPIKey(admin.ID, "bootstrap", rawKey, []string{models.ScopeAll}, nil)
+	if err := s.keys.CreateAPIKey(key); err != nil {
+		return fmt.Errorf("failed to create bootstrap api key: %v", err)
+	}
+
+	if generated {
+		slog.Info("Generated bootstrap API key (shown only once)", "api_key", rawKey)
+	} else {
+		slog.Info("Created bootstrap API key from environment", "prefix", key.Prefix)
+	}
+	return nil
+}
+
+// bootstrapAdmin 返回一个可用的超级管理员，没有任何管理员时创建
+func (s *AuthService) bootstrapAdmin() (*models.Admin, error) {
+	if s.admins.Count() > 0 {
+		admins, err := s.admins.ListAdmins()
+		if err != nil {
+			return nil, err
+		}
+		for _, admin := range admins {
+			if admin.Role == models.RoleAdmin && admin.IsActive {
+				return admin, nil
+			}
+		}
+		return nil, fmt.Errorf("no active admin account found")
+	}
+
+	admin := &models.Admin{
+		ID:        uuid.New().String(),
+		Username:  bootstrapAdminUsername,
+		Role:      models.RoleAdmin,
+		CreatedAt: time.Now(),
+		IsActive:  true,
+	}
+	if err := s.admins.CreateAdmin(admin); err != nil {
+		return nil, fmt.Errorf("failed to create bootstrap admin: %v", err)
+	}
+
+	slog.Info("Created bootstrap admin", "admin", admin.Username)
+	return admin, nil
+}
+
+// CreateAPIKey 创建API密钥，返回的明文密钥只在此时可见
+// 请求未指定管理员时密钥绑定到创建者；只能授予创建者自己拥有的权限，
+// 只有拥有全部权限的超级管理员才能为其他管理员签发密钥
+func (s *AuthService) CreateAPIKey(req *models.CreateAPIKeyRequest, creator *models.Principal) (*models.APIKey, string, error) {
+	adminID := req.AdminID
+	if adminID == "" {
+		adminID = creator.Admin.ID
+	}
+	if adminID != creator.Admin.ID && !(creator.HasRole(models.RoleAdmin) && creator.HasScope(models.ScopeAll)) {
+		return nil, "", fmt.Errorf("%w: only a full admin may issue keys for other admins", ErrKeyNotPermitted)
+	}
+	if _, err := s.admins.GetAdmin(adminID); err != nil {
+		return nil, "", err
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !models.IsValidScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %s", scope)
+		}
+		if !creator.HasScope(scope) {
+			return nil, "", fmt.Errorf("%w: scope %s is not held by the caller", ErrKeyNotPermitted, scope)
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid expires_at format: %v", err)
+		}
+		expiresAt = &t
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := newAPIKey(adminID, req.Name, rawKey, req.Scopes, expiresAt)
+	if err := s.keys.CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+
+	return key, rawKey, nil
+}
+
+// Authenticate 校验明文密钥并返回对应的认证主体
+func (s *AuthService) Authenticate(rawKey string) (*models.Principal, error) {
+	key, err := s.keys.GetAPIKeyByHash(hashAPIKey(rawKey))
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// 吊销和过期的密钥返回同一错误，不向调用方透露密钥是否曾经有效
+	if key.Revoked || key.IsExpired() {
+		slog.Info("Rejected revoked or expired api key", "key_id", key.ID, "revoked", key.Revoked)
+		return nil, ErrInvalidAPIKey
+	}
+
+	admin, err := s.admins.GetAdmin(key.AdminID)
+	if err != nil {
+		return nil, fmt.Errorf("api key owner not found")
+	}
+
+	if !admin.IsActive {
+		return nil, fmt.Errorf("admin account is disabled")
+	}
+
+	if err := s.keys.TouchAPIKey(key.ID, time.Now()); err != nil {
+		slog.Warn("Failed to update api key last used time", "error", err)
+	}
+
+	return &models.Principal{Admin: admin, APIKey: key}, nil
+}
+
+// ListAPIKeys 列出所有API密钥
+func (s *AuthService) ListAPIKeys() ([]*models.APIKey, error) {
+	return s.keys.ListAPIKeys()
+}
+
+// RevokeAPIKey 吊销API密钥
+func (s *AuthService) RevokeAPIKey(id string) error {
+	return s.keys.RevokeAPIKey(id)
+}
+
+// newAPIKey 根据明文密钥构造API密钥记录
+func newAPIKey(adminID, name, rawKey string, scopes []string, expiresAt *time.Time) *models.APIKey {
+	prefix := rawKey
+	if len(prefix) > 12 {
+		prefix = prefix[:12]
+	}
+
+	return &models.APIKey{
+		ID:        uuid.New().String(),
+		AdminID:   adminID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// generateAPIKey 生成随机明文密钥
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %v", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashAPIKey 计算密钥哈希，密钥本身为高熵随机值，使用SHA-256即可
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
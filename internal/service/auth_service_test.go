@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+)
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	s := newTestServices(t)
+	keys, err := storage.NewAPIKeyStorage(filepath.Join(s.dir, "api_keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthService(keys, s.admins)
+	admin := s.addAdmin(t, &models.Admin{ID: "root", Username: "root", Role: models.RoleAdmin})
+
+	newKey := func(expiresAt *string) (*models.APIKey, string) {
+		key, raw, err := auth.CreateAPIKey(&models.CreateAPIKeyRequest{Name: "test", Scopes: []string{models.ScopeNodesAdmin}, ExpiresAt: expiresAt}, &models.Principal{Admin: admin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, raw
+	}
+	valid, validRaw := newKey(nil)
+	revoked, revokedRaw := newKey(nil)
+	if err := auth.RevokeAPIKey(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	_, expiredRaw := newKey(&past)
+
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"valid key", validRaw, nil},
+		{"unknown key", apiKeyPrefix + "unknown", ErrInvalidAPIKey},
+		{"revoked key", revokedRaw, ErrInvalidAPIKey},
+		{"expired key", expiredRaw, ErrInvalidAPIKey},
+	}
+	for _, tt := range tests {
+		_, err := auth.Authenticate(tt.raw)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// 使用时间写入新的记录，之前返回的密钥和吊销前的记录不变
+	if valid.LastUsedAt != nil || revoked.Revoked {
+		t.Errorf("earlier copies modified: last used %v, revoked %v", valid.LastUsedAt, revoked.Revoked)
+	}
+	stored, err := keys.GetAPIKey(valid.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastUsedAt == nil {
+		t.Error("last used time not recorded")
+	}
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	s := newTestServices(t)
+	keys, err := storage.NewAPIKeyStorage(filepath.Join(s.dir, "api_keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthService(keys, s.admins)
+	root := s.addAdmin(t, &models.Admin{ID: "root", Username: "root", Role: models.RoleAdmin})
+	other := s.addAdmin(t, &models.Admin{ID: "other", Username: "other", Role: models.RoleAdmin})
+
+	session := &models.Principal{Admin: root}
+	full := &models.Principal{Admin: root, APIKey: &models.APIKey{Scopes: []string{models.ScopeAll}}}
+	keysOnly := &models.Principal{Admin: root, APIKey: &models.APIKey{Scopes: []string{models.ScopeKeysAdmin, models.ScopeUsersRead}}}
+
+	tests := []struct {
+		name      string
+		creator   *models.Principal
+		adminID   string
+		scopes    []string
+		forbidden bool
+	}{
+		{"keys:admin key asks for all scopes", keysOnly, "", []string{models.ScopeAll}, true},
+		{"keys:admin key asks for an unheld scope", keysOnly, "", []string{models.ScopeUsersWrite}, true},
+		{"keys:admin key grants held scopes", keysOnly, "", []string{models.ScopeUsersRead}, false},
+		{"keys:admin key binds key to another admin", keysOnly, other.ID, []string{models.ScopeUsersRead}, true},
+		{"full key binds key to another admin", full, other.ID, []string{models.ScopeAll}, false},
+		{"login session grants all scopes", session, other.ID, []string{models.ScopeAll}, false},
+	}
+	for _, tt := range tests {
+		_, _, err := auth.CreateAPIKey(&models.CreateAPIKeyRequest{AdminID: tt.adminID, Name: tt.name, Scopes: tt.scopes}, tt.creator)
+		if errors.Is(err, ErrKeyNotPermitted) != tt.forbidden || !tt.forbidden && err != nil {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
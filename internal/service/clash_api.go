@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClashConnection sing-box Clash API返回的一条活动连接
+type ClashConnection struct {
+	ID       string `json:"id"`
+	Metadata struct {
+		Network    string `json:"network"`
+		Type       string `json:"type"`
+		SourceIP   string `json:"sourceIP"`
+		SourcePort string `json:"sourcePort"`
+		Host       string `json:"host"`
+	} `json:"metadata"`
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Start    time.Time `json:"start"`
+}
+
+// SourceAddress 返回连接的来源地址，格式与sing-box日志中的来源地址一致
+func (c *ClashConnection) SourceAddress() string {
+	return net.JoinHostPort(c.Metadata.SourceIP, c.Metadata.SourcePort)
+}
+
+// ClashAPIClient sing-box Clash API客户端，用于查询和关闭活动连接
+type ClashAPIClient struct {
+	baseURL string
+	secret  string
+	client  *http.Client
+}
+
+// NewClashAPIClient 创建Clash API客户端，baseURL形如 http://127.0.0.1:9090
+func NewClashAPIClient(baseURL, secret string) *ClashAPIClient {
+	return &ClashAPIClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ListenAddress 返回写入sing-box配置的external_controller地址
+func (c *ClashAPIClient) ListenAddress() (string, error) {
+	parsed, err := url.Parse(c.baseURL)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid clash api url: %s", c.baseURL)
+	}
+	return parsed.Host, nil
+}
+
+// Connections 查询当前所有活动连接
+func (c *ClashAPIClient) Connections(ctx context.Context) ([]ClashConnection, error) {
+	var result struct {
+		Connections []ClashConnection `json:"connections"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/connections", &result); err != nil {
+		return nil, fmt.Errorf("failed to list connections: %v", err)
+	}
+	return result.Connections, nil
+}
+
+// CloseConnection 关闭指定连接
+func (c *ClashAPIClient) CloseConnection(ctx context.Context, id string) error {
+	if err := c.do(ctx, http.MethodDelete, "/connections/"+url.PathEscape(id), nil); err != nil {
+		return fmt.Errorf("failed to close connection %s: %v", id, err)
+	}
+	return nil
+}
+
+// do 发送请求，out不为nil时解析JSON响应
+func (c *ClashAPIClient) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("clash api returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -1,13 +1,20 @@
 package service
 
 import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
+	"sing-box-manager/internal/metrics"
 	"sing-box-manager/internal/models"
 	"sing-box-manager/internal/storage"
 )
@@ -18,47 +25,137 @@ type ConfigService struct {
 	configPath  string
 	templatePath string
 	serverName  string
+	audit       *AuditService
+
+	// agent模式下由中心管理端下发的入站定义，为空时使用本地定义
+	inboundTemplates []Inbound
+	
+	// 本机所属的节点分组，只有可使用这些分组的用户会写入配置
+	nodeGroups []string
+	
+	// 由管理进程启动的sing-box的输出去向，为空时输出到标准输出
+	singBoxOutput io.Writer
+	
+	// 写入服务端配置的Clash API设置，为空时不开启
+	clashAPI *ClashAPIConfig
+
+	// 最近一次配置生成的结果，供健康检查使用
+	mutex           sync.RWMutex
+	lastGeneratedAt time.Time
+	lastGenerateErr error
 }
 
 // NewConfigService 创建配置服务
-func NewConfigService(storage *storage.JSONStorage, configPath, templatePath, serverName string) *ConfigService {
+func NewConfigService(storage *storage.JSONStorage, audit *AuditService, configPath, templatePath, serverName string, nodeGroups []string) *ConfigService {
 	return &ConfigService{
 		storage:      storage,
+		audit:        audit,
 		configPath:   configPath,
 		templatePath: templatePath,
 		serverName:   serverName,
+		nodeGroups:   models.NormalizeGroups(nodeGroups),
 	}
 }
 
-// SingBoxConfig sing-box配置结构
+// SingBoxConfig sing-box配置结构，服务端配置和客户端订阅共用
 type SingBoxConfig struct {
-	Log struct {
-		Level     string `json:"level"`
-		Timestamp bool   `json:"timestamp"`
-	} `json:"log"`
-	DNS struct {
-		Servers []struct {
-			Tag     string `json:"tag"`
-			Address string `json:"address"`
-			Detour  string `json:"detour,omitempty"`
-		} `json:"servers"`
-		Rules []struct {
-			DomainSuffix []string `json:"domain_suffix,omitempty"`
-			Server       string   `json:"server"`
-		} `json:"rules"`
-	} `json:"dns"`
-	Inbounds []Inbound `json:"inbounds"`
-	Outbounds []struct {
-		Type string `json:"type"`
-		Tag  string `json:"tag"`
-	} `json:"outbounds"`
-	Route struct {
-		Rules []struct {
-			Protocol    string `json:"protocol,omitempty"`
-			IPIsPrivate bool   `json:"ip_is_private,omitempty"`
-			Outbound    string `json:"outbound"`
-		} `json:"rules"`
-	} `json:"route"`
+	Log          LogConfig           `json:"log"`
+	DNS          DNSConfig           `json:"dns"`
+	Inbounds     []Inbound           `json:"inbounds"`
+	Outbounds    []Outbound          `json:"outbounds"`
+	Route        RouteConfig         `json:"route"`
+	Experimental *ExperimentalConfig `json:"experimental,omitempty"`
+}
+
+// ExperimentalConfig 实验性功能配置
+type ExperimentalConfig struct {
+	ClashAPI *ClashAPIConfig `json:"clash_api,omitempty"`
+}
+
+// ClashAPIConfig Clash API配置，管理端通过它查询和关闭活动连接
+type ClashAPIConfig struct {
+	ExternalController string `json:"external_controller"`
+	Secret             string `json:"secret,omitempty"`
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level        string `json:"level"`
+	Timestamp    bool   `json:"timestamp"`
+	DisableColor bool   `json:"disable_color,omitempty"`
+}
+
+// DNSConfig DNS配置
+type DNSConfig struct {
+	Servers []DNSServer `json:"servers"`
+	Rules   []DNSRule   `json:"rules"`
+	Final   string      `json:"final,omitempty"`
+}
+
+// DNSServer DNS服务器
+type DNSServer struct {
+	Tag     string `json:"tag"`
+	Address string `json:"address"`
+	Detour  string `json:"detour,omitempty"`
+}
+
+// DNSRule DNS规则
+type DNSRule struct {
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	Outbound     []string `json:"outbound,omitempty"`
+	Server       string   `json:"server"`
+}
+
+// Outbound 出站配置
+type Outbound struct {
+	Type       string             `json:"type"`
+	Tag        string             `json:"tag"`
+	Server     string             `json:"server,omitempty"`
+	ServerPort int                `json:"server_port,omitempty"`
+	Password   string             `json:"password,omitempty"`
+	UUID       string             `json:"uuid,omitempty"`
+	TLS        *OutboundTLSConfig `json:"tls,omitempty"`
+	// selector / urltest 分组
+	Outbounds []string `json:"outbounds,omitempty"`
+	Default   string   `json:"default,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Interval  string   `json:"interval,omitempty"`
+}
+
+// OutboundTLSConfig 客户端TLS配置
+type OutboundTLSConfig struct {
+	Enabled    bool                   `json:"enabled"`
+	ServerName string                 `json:"server_name"`
+	UTLS       *UTLSConfig            `json:"utls,omitempty"`
+	Reality    *OutboundRealityConfig `json:"reality,omitempty"`
+}
+
+// UTLSConfig uTLS指纹配置
+type UTLSConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// OutboundRealityConfig 客户端Reality配置
+type OutboundRealityConfig struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id"`
+}
+
+// RouteConfig 路由配置
+type RouteConfig struct {
+	Rules               []RouteRule `json:"rules"`
+	Final               string      `json:"final,omitempty"`
+	AutoDetectInterface bool        `json:"auto_detect_interface,omitempty"`
+}
+
+// RouteRule 路由规则
+type RouteRule struct {
+	Protocol     string   `json:"protocol,omitempty"`
+	IPIsPrivate  bool     `json:"ip_is_private,omitempty"`
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	Outbound     string   `json:"outbound"`
 }
 
 // Inbound 入站配置
@@ -70,7 +167,7 @@ type Inbound struct {
 	Sniff     bool      `json:"sniff"`
 	SniffOverrideDestination bool `json:"sniff_override_destination"`
 	TLS       *TLSConfig `json:"tls,omitempty"`
-	Users     []UserConfig `json:"users"`
+	Users     []UserConfig `json:"users,omitempty"`
 }
 
 // TLSConfig TLS配置
@@ -116,18 +213,80 @@ func (s *ConfigService) getRealityPrivateKey() string {
 	return "oNglJo5OpvsjAjIWnGgVaQ_EUQP5_YKnGmnNdvhTCXM"
 }
 
+// RealityPublicKey 根据当前Reality私钥计算客户端使用的公钥
+func (s *ConfigService) RealityPublicKey() (string, error) {
+	privateKey, err := base64.RawURLEncoding.DecodeString(s.getRealityPrivateKey())
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %v", err)
+	}
+	
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %v", err)
+	}
+	
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// ServerName 返回客户端连接使用的服务器地址
+func (s *ConfigService) ServerName() string {
+	return s.serverName
+}
+
 // GenerateConfig 生成sing-box配置
-func (s *ConfigService) GenerateConfig() error {
+func (s *ConfigService) GenerateConfig(ctx context.Context) error {
+	activeUsers, err := s.regenerate(ctx)
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditConfigGenerate, "config", s.configPath, nil, map[string]interface{}{
+		"active_users": activeUsers,
+	})
+	return nil
+}
+
+// regenerate 生成配置并记录结果供健康检查使用，返回写入配置的用户数
+func (s *ConfigService) regenerate(ctx context.Context) (int, error) {
+	start := time.Now()
+	activeUsers, err := s.generateConfig()
+	metrics.ConfigGenerations.WithLabelValues(metrics.Result(err)).Inc()
+	
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to generate sing-box config", "error", err, "duration", time.Since(start))
+	} else {
+		slog.InfoContext(ctx, "Generated sing-box config", "path", s.configPath, "active_users", activeUsers, "duration", time.Since(start))
+	}
+	
+	s.mutex.Lock()
+	s.lastGeneratedAt = time.Now()
+	s.lastGenerateErr = err
+	s.mutex.Unlock()
+	
+	return activeUsers, err
+}
+
+// LastGeneration 返回最近一次配置生成的时间和错误，从未生成时时间为零值
+func (s *ConfigService) LastGeneration() (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	
+	return s.lastGeneratedAt, s.lastGenerateErr
+}
+
+// generateConfig 生成并写入配置文件
+func (s *ConfigService) generateConfig() (int, error) {
 	// 获取所有活跃用户
 	users, err := s.storage.ListUsers()
 	if err != nil {
-		return fmt.Errorf("failed to get users: %v", err)
+		return 0, fmt.Errorf("failed to get users: %v", err)
 	}
 
-	// 过滤活跃且未过期的用户
+	// 过滤活跃、未过期且有权使用本节点的用户
+	nodeGroups := s.NodeGroups()
 	activeUsers := make([]*models.User, 0)
 	for _, user := range users {
-		if user.IsActive && !user.IsExpired() && !user.IsTrafficExceeded() {
+		if user.IsActive && !user.IsExpired() && !user.IsTrafficExceeded() && user.CanAccessNode(nodeGroups) {
 			activeUsers = append(activeUsers, user)
 		}
 	}
@@ -138,15 +297,14 @@ func (s *ConfigService) GenerateConfig() error {
 	// 保存配置文件
 	configData, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %v", err)
+		return 0, fmt.Errorf("failed to marshal config: %v", err)
 	}
 
 	if err := os.WriteFile(s.configPath, configData, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %v", err)
+		return 0, fmt.Errorf("failed to write config file: %v", err)
 	}
 
-	fmt.Printf("Generated sing-box config with %d active users\n", len(activeUsers))
-	return nil
+	return len(activeUsers), nil
 }
 
 // buildConfig 构建配置文件
@@ -156,26 +314,55 @@ func (s *ConfigService) buildConfig(users []*models.User) *SingBoxConfig {
 	// 基础配置
 	config.Log.Level = "info"
 	config.Log.Timestamp = true
+	config.Log.DisableColor = true
 
 	// DNS配置
-	config.DNS.Servers = []struct {
-		Tag     string `json:"tag"`
-		Address string `json:"address"`
-		Detour  string `json:"detour,omitempty"`
-	}{
+	config.DNS.Servers = []DNSServer{
 		{Tag: "cloudflare", Address: "1.1.1.1"},
 		{Tag: "local", Address: "local", Detour: "direct"},
 	}
 
-	config.DNS.Rules = []struct {
-		DomainSuffix []string `json:"domain_suffix,omitempty"`
-		Server       string   `json:"server"`
-	}{
+	config.DNS.Rules = []DNSRule{
 		{DomainSuffix: []string{".cn"}, Server: "local"},
 	}
 
 	// 入站配置
-	config.Inbounds = []Inbound{
+	config.Inbounds = s.buildInbounds(s.serverName, users)
+
+	// 出站配置
+	config.Outbounds = []Outbound{
+		{Type: "direct", Tag: "direct"},
+		{Type: "direct", Tag: "dns-out"},
+		{Type: "block", Tag: "block"},
+	}
+
+	// 路由配置
+	config.Route.Rules = []RouteRule{
+		{Protocol: "dns", Outbound: "dns-out"},
+		{IPIsPrivate: true, Outbound: "direct"},
+	}
+
+	// Clash API
+	s.mutex.RLock()
+	if s.clashAPI != nil {
+		config.Experimental = &ExperimentalConfig{ClashAPI: s.clashAPI}
+	}
+	s.mutex.RUnlock()
+
+	return config
+}
+
+// buildInbounds 构建入站配置，serverName为TLS证书域名
+func (s *ConfigService) buildInbounds(serverName string, users []*models.User) []Inbound {
+	s.mutex.RLock()
+	templates := s.inboundTemplates
+	s.mutex.RUnlock()
+	
+	if templates != nil {
+		return s.fillInboundUsers(templates, users)
+	}
+	
+	return []Inbound{
 		{
 			Type:                     "mixed",
 			Tag:                      "mixed-in",
@@ -194,11 +381,11 @@ func (s *ConfigService) buildConfig(users []*models.User) *SingBoxConfig {
 			SniffOverrideDestination: true,
 			TLS: &TLSConfig{
 				Enabled:         true,
-				ServerName:      s.serverName,
+				ServerName:      serverName,
 				CertificatePath: "configs/cert.pem",
 				KeyPath:         "configs/key.pem",
 			},
-			Users: s.buildTrojanUsers(users),
+			Users: s.buildTrojanUsers(usersForInbound("trojan-in", users)),
 		},
 		{
 			Type:                     "vless",
@@ -209,11 +396,11 @@ func (s *ConfigService) buildConfig(users []*models.User) *SingBoxConfig {
 			SniffOverrideDestination: true,
 			TLS: &TLSConfig{
 				Enabled:         true,
-				ServerName:      s.serverName,
+				ServerName:      serverName,
 				CertificatePath: "configs/cert.pem",
 				KeyPath:         "configs/key.pem",
 			},
-			Users: s.buildVlessUsers(users),
+			Users: s.buildVlessUsers(usersForInbound("vless-in", users)),
 		},
 		{
 			Type:                     "vless",
@@ -235,31 +422,69 @@ func (s *ConfigService) buildConfig(users []*models.User) *SingBoxConfig {
 					ShortID:    []string{"0123456789abcdef"},
 				},
 			},
-			Users: s.buildVlessUsers(users),
+			Users: s.buildVlessUsers(usersForInbound("vless-reality-in", users)),
 		},
 	}
+}
 
-	// 出站配置
-	config.Outbounds = []struct {
-		Type string `json:"type"`
-		Tag  string `json:"tag"`
-	}{
-		{Type: "direct", Tag: "direct"},
-		{Type: "direct", Tag: "dns-out"},
-		{Type: "block", Tag: "block"},
-	}
+// Inbounds 返回当前启用的入站定义(不含用户)
+func (s *ConfigService) Inbounds() []Inbound {
+	return s.buildInbounds(s.serverName, nil)
+}
 
-	// 路由配置
-	config.Route.Rules = []struct {
-		Protocol    string `json:"protocol,omitempty"`
-		IPIsPrivate bool   `json:"ip_is_private,omitempty"`
-		Outbound    string `json:"outbound"`
-	}{
-		{Protocol: "dns", Outbound: "dns-out"},
-		{IPIsPrivate: true, Outbound: "direct"},
+// InboundsFor 返回以指定地址作为TLS域名的入站定义(不含用户)，用于下发给边缘节点
+func (s *ConfigService) InboundsFor(serverName string) []Inbound {
+	return s.buildInbounds(serverName, nil)
+}
+
+// NodeGroups 返回本机所属的节点分组
+func (s *ConfigService) NodeGroups() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	
+	return s.nodeGroups
+}
+
+// SetNodeGroups 设置本机所属的节点分组，agent模式下由中心管理端下发
+func (s *ConfigService) SetNodeGroups(groups []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	s.nodeGroups = models.NormalizeGroups(groups)
+}
+
+// SetInboundTemplates 使用中心管理端下发的入站定义替换本地定义
+func (s *ConfigService) SetInboundTemplates(inbounds []Inbound) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	s.inboundTemplates = inbounds
+}
+
+// fillInboundUsers 复制入站定义并按协议填入用户
+func (s *ConfigService) fillInboundUsers(templates []Inbound, users []*models.User) []Inbound {
+	inbounds := make([]Inbound, 0, len(templates))
+	for _, inbound := range templates {
+		switch inbound.Type {
+		case "trojan":
+			inbound.Users = s.buildTrojanUsers(usersForInbound(inbound.Tag, users))
+		case "vless":
+			inbound.Users = s.buildVlessUsers(usersForInbound(inbound.Tag, users))
+		}
+		inbounds = append(inbounds, inbound)
 	}
+	return inbounds
+}
 
-	return config
+// usersForInbound 过滤出可以使用指定入站的用户
+func usersForInbound(tag string, users []*models.User) []*models.User {
+	allowed := make([]*models.User, 0, len(users))
+	for _, user := range users {
+		if user.CanUseInbound(tag) {
+			allowed = append(allowed, user)
+		}
+	}
+	return allowed
 }
 
 // buildTrojanUsers 构建Trojan用户配置
@@ -286,37 +511,110 @@ func (s *ConfigService) buildVlessUsers(users []*models.User) []UserConfig {
 	return userConfigs
 }
 
+// ApplyChanges 用户状态被系统自动修改后重新生成配置并重载sing-box，不记录配置操作的审计日志
+func (s *ConfigService) ApplyChanges(ctx context.Context) error {
+	if _, err := s.regenerate(ctx); err != nil {
+		return err
+	}
+	return s.reload(ctx)
+}
+
 // ReloadSingBox 重载sing-box配置
-func (s *ConfigService) ReloadSingBox() error {
+func (s *ConfigService) ReloadSingBox(ctx context.Context) error {
+	if err := s.reload(ctx); err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditConfigReload, "sing-box", "", nil, nil)
+	return nil
+}
+
+// reload 发送HUP信号重载配置，失败时重启sing-box
+func (s *ConfigService) reload(ctx context.Context) error {
+	start := time.Now()
 	cmd := exec.Command("pkill", "-HUP", "sing-box")
 	if err := cmd.Run(); err != nil {
-		fmt.Printf("Failed to reload sing-box: %v\n", err)
-		return s.RestartSingBox()
+		metrics.SingBoxReloads.WithLabelValues(metrics.Result(err)).Inc()
+		slog.WarnContext(ctx, "Failed to reload sing-box, restarting", "error", err)
+		return s.restart(ctx)
 	}
 	
-	fmt.Println("sing-box configuration reloaded")
+	metrics.SingBoxReloads.WithLabelValues(metrics.Result(nil)).Inc()
+	slog.InfoContext(ctx, "Reloaded sing-box configuration", "duration", time.Since(start))
 	return nil
 }
 
 // RestartSingBox 重启sing-box
-func (s *ConfigService) RestartSingBox() error {
+func (s *ConfigService) RestartSingBox(ctx context.Context) error {
+	if err := s.restart(ctx); err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditConfigRestart, "sing-box", "", nil, nil)
+	return nil
+}
+
+// StartSingBox 由管理进程启动sing-box，已有sing-box进程时会先将其结束，不记录审计日志
+func (s *ConfigService) StartSingBox(ctx context.Context) error {
+	return s.restart(ctx)
+}
+
+// SetClashAPI 在生成的服务端配置中开启Clash API
+func (s *ConfigService) SetClashAPI(controller, secret string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clashAPI = &ClashAPIConfig{ExternalController: controller, Secret: secret}
+}
+
+// SetSingBoxOutput 设置由管理进程启动的sing-box的日志输出，标准输出会同时保留一份
+func (s *ConfigService) SetSingBoxOutput(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.singBoxOutput = io.MultiWriter(os.Stdout, w)
+}
+
+// restart 结束并重新启动sing-box进程
+func (s *ConfigService) restart(ctx context.Context) error {
+	start := time.Now()
 	exec.Command("pkill", "sing-box").Run()
 	time.Sleep(2 * time.Second)
 	
+	s.mutex.RLock()
+	output := s.singBoxOutput
+	s.mutex.RUnlock()
+	if output == nil {
+		output = os.Stdout
+	}
+	
+	// 标准输出和错误输出使用同一个writer，exec保证不会并发写入
 	cmd := exec.Command("sing-box", "run", "-c", s.configPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = output
+	cmd.Stderr = output
 	
-	if err := cmd.Start(); err != nil {
+	err := cmd.Start()
+	metrics.SingBoxRestarts.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to start sing-box", "error", err)
 		return fmt.Errorf("failed to start sing-box: %v", err)
 	}
 	
-	fmt.Println("sing-box restarted")
+	// 回收进程，确保输出全部读取完毕
+	go func() {
+		err := cmd.Wait()
+		slog.Warn("sing-box exited", "pid", cmd.Process.Pid, "error", err)
+	}()
+	
+	slog.InfoContext(ctx, "Restarted sing-box", "pid", cmd.Process.Pid, "duration", time.Since(start))
 	return nil
 }
 
+// IsSingBoxRunning 检查sing-box进程是否在运行
+func (s *ConfigService) IsSingBoxRunning() bool {
+	return exec.Command("pgrep", "-x", "sing-box").Run() == nil
+}
+
 // GenerateRealityKeypair 生成Reality密钥对
-func (s *ConfigService) GenerateRealityKeypair() (map[string]string, error) {
+func (s *ConfigService) GenerateRealityKeypair(ctx context.Context) (map[string]string, error) {
 	cmd := exec.Command("sing-box", "generate", "reality-keypair")
 	output, err := cmd.Output()
 	if err != nil {
@@ -340,6 +638,9 @@ func (s *ConfigService) GenerateRealityKeypair() (map[string]string, error) {
 		os.WriteFile("configs/reality_private.key", []byte(privateKey), 0600)
 	}
 	
+	s.audit.Record(ctx, AuditConfigRealityKey, "config", "reality", nil, map[string]interface{}{
+		"public_key": result["public_key"],
+	})
 	return result, nil
 }
 
@@ -348,14 +649,13 @@ func (s *ConfigService) AutoReloadConfig() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 	
+	// 定时任务不属于管理操作，不写入审计日志，失败已在内部记录日志
+	ctx := context.Background()
 	for range ticker.C {
-		if err := s.GenerateConfig(); err != nil {
-			fmt.Printf("Failed to generate config: %v\n", err)
+		if _, err := s.regenerate(ctx); err != nil {
 			continue
 		}
 		
-		if err := s.ReloadSingBox(); err != nil {
-			fmt.Printf("Failed to reload sing-box: %v\n", err)
-		}
+		s.reload(ctx)
 	}
 }
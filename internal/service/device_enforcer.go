@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"sing-box-manager/internal/metrics"
+	"sing-box-manager/internal/models"
+)
+
+// deviceEnforceInterval 检查设备数限制的间隔
+const deviceEnforceInterval = 10 * time.Second
+
+// DeviceLimitEnforcer 通过Clash API检查每个用户的在线设备数，超出限制时关闭最新设备的连接
+// 用户已移除的设备在禁止期内重新连接时，其连接会被直接关闭
+// 用户从超出限制到回到限制以内记为一次违规，持续超限期间每次检查都会关闭连接但不重复计数
+// 在时间窗口内多次违规的用户会被临时停用
+type DeviceLimitEnforcer struct {
+	clash         *ClashAPIClient
+	tracker       *DeviceTracker
+	userService   *UserService
+	configService *ConfigService
+	audit         *AuditService
+
+	// violationLimit为0时只关闭连接，不停用用户
+	violationLimit  int
+	violationWindow time.Duration
+	suspendFor      time.Duration
+
+	violations map[string][]time.Time
+	// 当前处于超限状态的用户，回到限制以内后才会再次计数
+	overLimit map[string]bool
+}
+
+// NewDeviceLimitEnforcer 创建设备数限制执行服务
+func NewDeviceLimitEnforcer(clash *ClashAPIClient, tracker *DeviceTracker, userService *UserService, configService *ConfigService, audit *AuditService, violationLimit int, violationWindow, suspendFor time.Duration) *DeviceLimitEnforcer {
+	return &DeviceLimitEnforcer{
+		clash:           clash,
+		tracker:         tracker,
+		userService:     userService,
+		configService:   configService,
+		audit:           audit,
+		violationLimit:  violationLimit,
+		violationWindow: violationWindow,
+		suspendFor:      suspendFor,
+		violations:      make(map[string][]time.Time),
+		overLimit:       make(map[string]bool),
+	}
+}
+
+// Run 定期检查设备数限制，停用期结束的用户由UserMaintenance恢复
+func (e *DeviceLimitEnforcer) Run() {
+	ticker := time.NewTicker(deviceEnforceInterval)
+	defer ticker.Stop()
+
+	// 定时任务以系统身份记录审计日志
+	ctx := context.Background()
+	for range ticker.C {
+		if err := e.Enforce(ctx); err != nil {
+			slog.Warn("Failed to enforce device limits", "error", err)
+		}
+	}
+}
+
+// Enforce 检查一次所有活动连接，关闭超出设备数限制的连接
+func (e *DeviceLimitEnforcer) Enforce(ctx context.Context) error {
+	connections, err := e.clash.Connections(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 按用户和设备分组，已移除设备的连接直接关闭
+	now := time.Now()
+	users := make(map[string]*models.User)
+	byUser := make(map[string]map[string][]AttributedConnection)
+	for _, conn := range e.tracker.Attribute(connections) {
+		user, cached := users[conn.UserID]
+		if !cached {
+			user, _ = e.userService.GetUser(conn.UserID)
+			users[conn.UserID] = user
+		}
+		if user != nil && user.IsDeviceRevoked(conn.DeviceID, now) {
+			if err := e.clash.CloseConnection(ctx, conn.ID); err != nil {
+				slog.WarnContext(ctx, "Failed to close connection of revoked device", "user_id", conn.UserID, "connection_id", conn.ID, "error", err)
+				continue
+			}
+			metrics.ClosedConnections.Inc()
+			slog.InfoContext(ctx, "Closed connection of revoked device", "user_id", conn.UserID, "device_id", conn.DeviceID, "connection_id", conn.ID)
+			continue
+		}
+
+		devices, exists := byUser[conn.UserID]
+		if !exists {
+			devices = make(map[string][]AttributedConnection)
+			byUser[conn.UserID] = devices
+		}
+		devices[conn.DeviceID] = append(devices[conn.DeviceID], conn)
+	}
+
+	suspended := false
+	over := make(map[string]bool)
+
+	for userID, devices := range byUser {
+		user := users[userID]
+		if user == nil {
+			continue
+		}
+		// 未设置设备数限制的旧数据不做限制
+		if user.DeviceLimit <= 0 || len(devices) <= user.DeviceLimit {
+			continue
+		}
+
+		excess := newestDevices(devices, len(devices)-user.DeviceLimit)
+		closed := 0
+		for _, deviceID := range excess {
+			for _, conn := range devices[deviceID] {
+				if err := e.clash.CloseConnection(ctx, conn.ID); err != nil {
+					slog.WarnContext(ctx, "Failed to close connection", "user_id", userID, "connection_id", conn.ID, "error", err)
+					continue
+				}
+				closed++
+			}
+		}
+
+		over[userID] = true
+		metrics.ClosedConnections.Add(float64(closed))
+		slog.WarnContext(ctx, "User exceeded device limit", "user_id", userID, "username", user.Username,
+			"devices", len(devices), "device_limit", user.DeviceLimit, "closed_devices", excess, "closed_connections", closed)
+		if e.overLimit[userID] {
+			continue
+		}
+		e.overLimit[userID] = true
+
+		metrics.DeviceLimitViolations.Inc()
+		e.audit.Record(ctx, AuditUserDeviceLimit, "user", userID, nil, map[string]interface{}{
+			"devices":            len(devices),
+			"device_limit":       user.DeviceLimit,
+			"closed_devices":     excess,
+			"closed_connections": closed,
+		})
+
+		if e.recordViolation(userID) {
+			until := time.Now().Add(e.suspendFor)
+			if err := e.userService.SuspendUser(ctx, userID, until); err != nil {
+				slog.ErrorContext(ctx, "Failed to suspend user", "user_id", userID, "error", err)
+				continue
+			}
+			slog.WarnContext(ctx, "Suspended user for repeated device limit violations", "user_id", userID, "until", until)
+			suspended = true
+		}
+	}
+
+	// 本次检查未超限的用户结束超限状态，下次超限重新计数
+	for userID := range e.overLimit {
+		if !over[userID] {
+			delete(e.overLimit, userID)
+		}
+	}
+
+	if suspended {
+		return e.configService.ApplyChanges(ctx)
+	}
+	return nil
+}
+
+// recordViolation 记录一次违规，时间窗口内的违规次数达到上限时返回true并清空记录
+func (e *DeviceLimitEnforcer) recordViolation(userID string) bool {
+	if e.violationLimit <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-e.violationWindow)
+	recent := make([]time.Time, 0, len(e.violations[userID])+1)
+	for _, t := range e.violations[userID] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= e.violationLimit {
+		delete(e.violations, userID)
+		return true
+	}
+	e.violations[userID] = recent
+	return false
+}
+
+// newestDevices 返回最晚建立连接的count个设备，设备的建立时间取其最早的一条连接
+func newestDevices(devices map[string][]AttributedConnection, count int) []string {
+	type deviceStart struct {
+		id    string
+		start time.Time
+	}
+
+	starts := make([]deviceStart, 0, len(devices))
+	for id, conns := range devices {
+		start := conns[0].Start
+		for _, conn := range conns[1:] {
+			if conn.Start.Before(start) {
+				start = conn.Start
+			}
+		}
+		starts = append(starts, deviceStart{id: id, start: start})
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].start.After(starts[j].start)
+	})
+
+	newest := make([]string, 0, count)
+	for _, device := range starts[:count] {
+		newest = append(newest, device.id)
+	}
+	return newest
+}
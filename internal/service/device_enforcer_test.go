@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// fakeClashAPI 模拟sing-box Clash API，记录被关闭的连接
+type fakeClashAPI struct {
+	mutex       sync.Mutex
+	secret      string
+	connections []map[string]interface{}
+	closed      []string
+}
+
+func (f *fakeClashAPI) setConnections(sources ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.connections = nil
+	start := time.Now().Add(-time.Hour)
+	for i, source := range sources {
+		ip, port, _ := strings.Cut(source, ":")
+		f.connections = append(f.connections, map[string]interface{}{
+			"id":       source,
+			"metadata": map[string]string{"network": "tcp", "sourceIP": ip, "sourcePort": port},
+			"start":    start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+}
+
+func (f *fakeClashAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.secret {
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/connections":
+		json.NewEncoder(w).Encode(map[string]interface{}{"connections": f.connections})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/connections/"):
+		f.closed = append(f.closed, strings.TrimPrefix(r.URL.Path, "/connections/"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeClashAPI(t *testing.T) (*fakeClashAPI, *ClashAPIClient) {
+	t.Helper()
+	fake := &fakeClashAPI{secret: "s3cret"}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, NewClashAPIClient(server.URL+"/", fake.secret)
+}
+
+func TestClashAPIClient(t *testing.T) {
+	fake, client := newFakeClashAPI(t)
+	fake.setConnections("10.0.0.1:1000", "[2001:db8::1]:2000")
+	ctx := context.Background()
+
+	connections, err := client.Connections(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 2 || connections[0].SourceAddress() != "10.0.0.1:1000" || connections[0].Metadata.Network != "tcp" {
+		t.Fatalf("connections = %+v", connections)
+	}
+
+	if err := client.CloseConnection(ctx, "10.0.0.1:1000"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.closed) != 1 || fake.closed[0] != "10.0.0.1:1000" {
+		t.Fatalf("closed = %v", fake.closed)
+	}
+
+	unauthorized := NewClashAPIClient(strings.TrimSuffix(client.baseURL, "/"), "wrong")
+	if _, err := unauthorized.Connections(ctx); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want 401", err)
+	}
+	if address, err := client.ListenAddress(); err != nil || !strings.HasPrefix(address, "127.0.0.1:") {
+		t.Fatalf("listen address = %s, %v", address, err)
+	}
+}
+
+// noClientHints 测试中不推断客户端
+type noClientHints struct{}
+
+func (noClientHints) ClientHint(userID, deviceID string) string { return "" }
+
+func TestDeviceLimitEnforcerCountsEpisodes(t *testing.T) {
+	s := newTestServices(t)
+	user := &models.User{ID: "u1", Username: "alice", IsActive: true, DeviceLimit: 1}
+	if err := s.users.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从sing-box日志中识别两台设备的连接
+	tracker := NewDeviceTracker(nil, s.user, noClientHints{}, time.Hour)
+	for i, source := range []string{"10.0.0.1:1000", "10.0.0.2:2000"} {
+		id := string(rune('a' + i))
+		tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: id, Inbound: "vless-in", Message: "inbound connection from " + source})
+		tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: id, Inbound: "vless-in", User: "alice", Message: "inbound connection to example.com:443"})
+	}
+
+	// 配置写入不存在的目录，停用用户后重新生成配置失败，不会重载本机的sing-box
+	config := NewConfigService(s.users, s.audit, filepath.Join(s.dir, "missing", "sing-box.json"), "", "test", nil)
+	fake, client := newFakeClashAPI(t)
+	enforcer := NewDeviceLimitEnforcer(client, tracker, s.user, config, s.audit, 2, time.Hour, time.Hour)
+	ctx := context.Background()
+
+	steps := []struct {
+		name       string
+		sources    []string
+		closed     int
+		violations int
+		suspended  bool
+	}{
+		{"over limit", []string{"10.0.0.1:1000", "10.0.0.2:2000"}, 1, 1, false},
+		{"still over limit", []string{"10.0.0.1:1000", "10.0.0.2:2000"}, 2, 1, false},
+		{"back under limit", []string{"10.0.0.1:1000"}, 2, 1, false},
+		{"over limit again", []string{"10.0.0.1:1000", "10.0.0.2:2000"}, 3, 0, true},
+	}
+	for _, step := range steps {
+		fake.setConnections(step.sources...)
+		err := enforcer.Enforce(ctx)
+		if (err != nil) != step.suspended {
+			t.Fatalf("%s: err = %v", step.name, err)
+		}
+
+		if len(fake.closed) != step.closed {
+			t.Errorf("%s: closed = %v, want %d", step.name, fake.closed, step.closed)
+		}
+		for _, id := range fake.closed {
+			if id != "10.0.0.2:2000" {
+				t.Errorf("%s: closed older device connection %s", step.name, id)
+			}
+		}
+		if got := len(enforcer.violations["u1"]); got != step.violations {
+			t.Errorf("%s: violations = %d, want %d", step.name, got, step.violations)
+		}
+		current, _ := s.user.GetUser("u1")
+		if suspended := current.DisabledReason == models.DisabledReasonSuspended; suspended != step.suspended {
+			t.Errorf("%s: suspended = %v, want %v", step.name, suspended, step.suspended)
+		}
+	}
+}
+
+func TestDeviceLimitEnforcerClosesRevokedDevices(t *testing.T) {
+	s := newTestServices(t)
+	if err := s.users.CreateUser(&models.User{ID: "u1", Username: "alice", IsActive: true, DeviceLimit: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewDeviceTracker(nil, s.user, noClientHints{}, time.Hour)
+	for i, source := range []string{"10.0.0.1:1000", "10.0.0.2:2000"} {
+		id := string(rune('a' + i))
+		tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: id, Inbound: "vless-in", Message: "inbound connection from " + source})
+		tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: id, Inbound: "vless-in", User: "alice", Message: "inbound connection to example.com:443"})
+	}
+	tracker.flush()
+
+	fake, client := newFakeClashAPI(t)
+	fake.setConnections("10.0.0.1:1000", "10.0.0.2:2000")
+	devices := NewDeviceService(s.user)
+	devices.SetConnectionControl(client, tracker)
+
+	revoked := DeviceIDFromIP("10.0.0.2")
+	if closed, err := devices.RevokeDevice(context.Background(), "u1", revoked); err != nil || closed != 1 {
+		t.Fatalf("revoke = %d, %v", closed, err)
+	}
+
+	// 设备重新连接后，检查时关闭其连接且不会重新加入设备列表
+	tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: "c", Inbound: "vless-in", Message: "inbound connection from 10.0.0.2:3000"})
+	tracker.observe(&models.SingBoxLogEntry{Time: time.Now(), ConnectionID: "c", Inbound: "vless-in", User: "alice", Message: "inbound connection to example.com:443"})
+	fake.setConnections("10.0.0.1:1000", "10.0.0.2:3000")
+
+	enforcer := NewDeviceLimitEnforcer(client, tracker, s.user, s.config, s.audit, 0, time.Hour, time.Hour)
+	if err := enforcer.Enforce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.2:2000", "10.0.0.2:3000"}; strings.Join(fake.closed, ",") != strings.Join(want, ",") {
+		t.Fatalf("closed = %v, want %v", fake.closed, want)
+	}
+
+	tracker.flush()
+	user, _ := s.user.GetUser("u1")
+	if ids := user.ConnectedDeviceIDs(); len(ids) != 1 || ids[0] != DeviceIDFromIP("10.0.0.1") {
+		t.Fatalf("devices = %v", ids)
+	}
+}
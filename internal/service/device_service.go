@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// revokedDeviceTTL 被移除的设备禁止重新连接的时间
+// 设备按来源IP识别，IP可能被重新分配给其他设备，因此禁止记录会过期
+const revokedDeviceTTL = 24 * time.Hour
+
+// DeviceService 用户设备管理服务，供管理员和用户自助门户使用
+type DeviceService struct {
+	userService *UserService
+
+	// 由管理进程启动sing-box时才有设备追踪；同时开启Clash API时，移除设备会断开其活动连接
+	clash   *ClashAPIClient
+	tracker *DeviceTracker
+}
+
+// NewDeviceService 创建设备管理服务
+func NewDeviceService(userService *UserService) *DeviceService {
+	return &DeviceService{
+		userService: userService,
+	}
+}
+
+// SetConnectionControl 设置设备追踪服务和用于断开活动连接的Clash API客户端，clash可以为nil
+func (s *DeviceService) SetConnectionControl(clash *ClashAPIClient, tracker *DeviceTracker) {
+	s.clash = clash
+	s.tracker = tracker
+}
+
+// ListDevices 获取用户的设备列表，按首次出现时间排列
+func (s *DeviceService) ListDevices(userID string) ([]models.Device, error) {
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]models.Device, len(user.Devices))
+	copy(devices, user.Devices)
+	return devices, nil
+}
+
+// RenameDevice 修改设备名称
+func (s *DeviceService) RenameDevice(ctx context.Context, userID, deviceID, name string) (*models.Device, error) {
+	return s.userService.RenameDevice(ctx, userID, deviceID, name)
+}
+
+// RevokeDevice 移除设备并断开其活动连接，返回断开的连接数
+// 设备在revokedDeviceTTL内重新连接时，设备数限制检查会关闭其连接，这需要开启Clash API
+// 同一用户的所有设备共用一份凭据，未开启Clash API或需要永久阻止该设备时，只能修改用户密码并轮换订阅令牌
+func (s *DeviceService) RevokeDevice(ctx context.Context, userID, deviceID string) (int, error) {
+	if err := s.userService.RevokeDevice(ctx, userID, deviceID); err != nil {
+		return 0, err
+	}
+
+	return s.closeConnections(ctx, userID, deviceID), nil
+}
+
+// closeConnections 断开设备的活动连接，无法查询连接时只写日志
+func (s *DeviceService) closeConnections(ctx context.Context, userID, deviceID string) int {
+	if s.tracker == nil {
+		return 0
+	}
+	// 丢弃追踪中的记录，避免刚移除的设备随下次写入重新出现
+	defer s.tracker.Forget(userID, deviceID)
+	if s.clash == nil {
+		return 0
+	}
+
+	connections, err := s.clash.Connections(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list connections of revoked device", "user_id", userID, "device_id", deviceID, "error", err)
+		return 0
+	}
+
+	closed := 0
+	for _, conn := range s.tracker.Attribute(connections) {
+		if conn.UserID != userID || conn.DeviceID != deviceID {
+			continue
+		}
+		if err := s.clash.CloseConnection(ctx, conn.ID); err != nil {
+			slog.WarnContext(ctx, "Failed to close connection", "user_id", userID, "connection_id", conn.ID, "error", err)
+			continue
+		}
+		closed++
+	}
+	return closed
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// 入站建立连接时的日志内容，记录客户端来源地址
+var inboundConnectionFrom = regexp.MustCompile(`inbound connection from (\S+)$`)
+
+// deviceFlushInterval 设备活动写入存储并清理空闲设备的间隔
+const deviceFlushInterval = 30 * time.Second
+
+// connectionSourceTTL 连接来源地址的保留时间，同一连接的用户日志通常紧随其后
+const connectionSourceTTL = time.Minute
+
+// connectionSource 连接ID对应的客户端来源
+type connectionSource struct {
+	address string
+	ip      string
+	seenAt  time.Time
+}
+
+// connectionOwner 已识别出所属用户的连接
+type connectionOwner struct {
+	userID   string
+	deviceID string
+	ip       string
+	seenAt   time.Time
+}
+
+// DeviceClientHints 根据订阅拉取记录推断设备使用的客户端
+type DeviceClientHints interface {
+	// ClientHint 返回用户最近从该设备拉取订阅时的User-Agent，没有记录时返回空字符串
+	ClientHint(userID, deviceID string) string
+}
+
+// AttributedConnection 已归属到用户设备的活动连接
+type AttributedConnection struct {
+	ClashConnection
+	UserID   string
+	DeviceID string
+}
+
+// DeviceTracker 从sing-box连接日志中识别用户的真实设备
+// sing-box先输出带来源地址的"inbound connection from"日志，再输出带用户名的日志，两者通过连接ID关联
+// 连接日志不包含客户端指纹，设备按来源IP区分，IPv6按/64前缀区分以兼容临时地址
+type DeviceTracker struct {
+	logs        *SingBoxLogService
+	userService *UserService
+	hints       DeviceClientHints
+	idleTimeout time.Duration
+
+	mutex       sync.Mutex
+	connections map[string]connectionSource
+	// 按来源地址记录已识别用户的连接，供通过Clash API查询到的连接归属用户
+	owners  map[string]connectionOwner
+	pending models.DeviceActivity
+}
+
+// NewDeviceTracker 创建设备追踪服务，idleTimeout为设备无新连接后被移除的时间
+func NewDeviceTracker(logs *SingBoxLogService, userService *UserService, hints DeviceClientHints, idleTimeout time.Duration) *DeviceTracker {
+	return &DeviceTracker{
+		logs:        logs,
+		userService: userService,
+		hints:       hints,
+		idleTimeout: idleTimeout,
+		connections: make(map[string]connectionSource),
+		owners:      make(map[string]connectionOwner),
+		pending:     make(models.DeviceActivity),
+	}
+}
+
+// Run 观察sing-box日志，定期写入设备活动并移除空闲设备
+// 日志同步传给observe而不是通过订阅通道，日志突增时也不会漏掉连接的来源地址
+func (t *DeviceTracker) Run() {
+	t.logs.Observe(t.observe)
+
+	ticker := time.NewTicker(deviceFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.flush()
+	}
+}
+
+// observe 处理一行日志，记录连接来源或将来源归属到用户，只修改内存中的记录
+func (t *DeviceTracker) observe(entry *models.SingBoxLogEntry) {
+	if entry.ConnectionID == "" || entry.Inbound == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if match := inboundConnectionFrom.FindStringSubmatch(entry.Message); match != nil {
+		if ip := sourceIP(match[1]); ip != "" {
+			t.connections[entry.ConnectionID] = connectionSource{address: match[1], ip: ip, seenAt: entry.Time}
+		}
+	}
+	if entry.User == "" {
+		return
+	}
+
+	source, exists := t.connections[entry.ConnectionID]
+	if !exists {
+		return
+	}
+	delete(t.connections, entry.ConnectionID)
+
+	user, err := t.userService.GetUserByUsername(entry.User)
+	if err != nil {
+		return
+	}
+	deviceID := DeviceIDFromIP(source.ip)
+	t.owners[source.address] = connectionOwner{userID: user.ID, deviceID: deviceID, ip: source.ip, seenAt: entry.Time}
+	t.pending.Record(user.ID, deviceID, models.DeviceSighting{
+		LastSeen:  entry.Time,
+		IP:        source.ip,
+		UserAgent: t.hints.ClientHint(user.ID, deviceID),
+	})
+}
+
+// Attribute 将Clash API查询到的活动连接归属到用户设备，无法识别的连接被忽略
+// 仍然活动的连接会刷新设备的活动时间，长时间保持的连接不会被当作空闲设备移除；已关闭连接的记录随之清理
+func (t *DeviceTracker) Attribute(connections []ClashConnection) []AttributedConnection {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	open := make(map[string]bool, len(connections))
+	attributed := make([]AttributedConnection, 0, len(connections))
+	for _, conn := range connections {
+		address := conn.SourceAddress()
+		open[address] = true
+
+		owner, exists := t.owners[address]
+		if !exists {
+			continue
+		}
+		owner.seenAt = now
+		t.owners[address] = owner
+		t.pending.Record(owner.userID, owner.deviceID, models.DeviceSighting{LastSeen: now, IP: owner.ip})
+
+		attributed = append(attributed, AttributedConnection{
+			ClashConnection: conn,
+			UserID:          owner.userID,
+			DeviceID:        owner.deviceID,
+		})
+	}
+
+	// 刚识别出的连接可能还未出现在查询结果中，保留一段时间再清理
+	cutoff := now.Add(-connectionSourceTTL)
+	for address, owner := range t.owners {
+		if !open[address] && owner.seenAt.Before(cutoff) {
+			delete(t.owners, address)
+		}
+	}
+	return attributed
+}
+
+// ActiveSources 返回各用户当前仍在使用的来源IP，键为用户ID
+func (t *DeviceTracker) ActiveSources() map[string][]string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	seen := make(map[string]bool)
+	sources := make(map[string][]string)
+	for _, owner := range t.owners {
+		key := owner.userID + "/" + owner.ip
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		sources[owner.userID] = append(sources[owner.userID], owner.ip)
+	}
+	for _, ips := range sources {
+		sort.Strings(ips)
+	}
+	return sources
+}
+
+// Forget 丢弃设备尚未写入的活动和连接记录，设备被移除后不会因旧连接重新出现
+func (t *DeviceTracker) Forget(userID, deviceID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.pending[userID], deviceID)
+	for address, owner := range t.owners {
+		if owner.userID == userID && owner.deviceID == deviceID {
+			delete(t.owners, address)
+		}
+	}
+}
+
+// flush 写入待保存的设备活动，清理过期的连接记录并移除空闲设备
+func (t *DeviceTracker) flush() {
+	t.mutex.Lock()
+	pending := t.pending
+	t.pending = make(models.DeviceActivity)
+	cutoff := time.Now().Add(-connectionSourceTTL)
+	for id, source := range t.connections {
+		if source.seenAt.Before(cutoff) {
+			delete(t.connections, id)
+		}
+	}
+	// 未开启Clash API时无法得知连接何时关闭，按空闲超时清理
+	idleCutoff := time.Now().Add(-t.idleTimeout)
+	for address, owner := range t.owners {
+		if owner.seenAt.Before(idleCutoff) {
+			delete(t.owners, address)
+		}
+	}
+	t.mutex.Unlock()
+
+	if err := t.userService.RecordDeviceActivity(pending); err != nil {
+		slog.Error("Failed to record device activity", "error", err)
+	}
+
+	removed, err := t.userService.ExpireIdleDevices(t.idleTimeout)
+	if err != nil {
+		slog.Error("Failed to expire idle devices", "error", err)
+		return
+	}
+	if removed > 0 {
+		slog.Info("Expired idle devices", "removed", removed, "idle_timeout", t.idleTimeout.String())
+	}
+}
+
+// sourceIP 从 IP:端口 格式的来源地址中取出IP
+func sourceIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// DeviceIDFromIP 根据来源IP生成设备ID
+// 使用地址的哈希而不是地址本身，设备ID会出现在面向用户的链接中
+func DeviceIDFromIP(ip string) string {
+	key := ip
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if v4 := parsed.To4(); v4 != nil {
+			key = v4.String()
+		} else {
+			key = (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+		}
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "ip-" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/storage"
+)
+
+// 健康检查状态
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+)
+
+// HealthCheck 单项检查结果
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport 就绪检查报告
+type HealthReport struct {
+	Status    string        `json:"status"`
+	Checks    []HealthCheck `json:"checks"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Ready 是否所有检查均通过
+func (r *HealthReport) Ready() bool {
+	return r.Status == HealthStatusOK
+}
+
+// udpSocketTables 内核UDP套接字表，探测UDP入站时读取
+var udpSocketTables = []string{"/proc/net/udp", "/proc/net/udp6"}
+
+// udpStateUnconnected 套接字表中未连接(监听中)的UDP套接字状态
+const udpStateUnconnected = "07"
+
+// HealthService 健康检查服务
+type HealthService struct {
+	storage        *storage.JSONStorage
+	configService  *ConfigService
+	probeHost      string
+	timeout        time.Duration
+	udpTables      []string
+	singBoxRunning func() bool
+}
+
+// NewHealthService 创建健康检查服务，probeHost为探测TCP入站端口时使用的地址
+func NewHealthService(storage *storage.JSONStorage, configService *ConfigService, probeHost string) *HealthService {
+	return &HealthService{
+		storage:        storage,
+		configService:  configService,
+		probeHost:      probeHost,
+		timeout:        time.Second,
+		udpTables:      udpSocketTables,
+		singBoxRunning: configService.IsSingBoxRunning,
+	}
+}
+
+// CheckReadiness 执行全部就绪检查
+func (s *HealthService) CheckReadiness() *HealthReport {
+	checks := []HealthCheck{
+		s.checkStorage(),
+		s.checkConfig(),
+		s.checkSingBox(),
+	}
+	checks = append(checks, s.checkInbounds()...)
+
+	report := &HealthReport{
+		Status:    HealthStatusOK,
+		Checks:    checks,
+		CheckedAt: time.Now(),
+	}
+	for _, check := range checks {
+		if check.Status != HealthStatusOK {
+			report.Status = HealthStatusFail
+			break
+		}
+	}
+
+	return report
+}
+
+// checkStorage 检查用户数据是否加载成功
+func (s *HealthService) checkStorage() HealthCheck {
+	if err := s.storage.LoadError(); err != nil {
+		return failedCheck("storage", fmt.Sprintf("failed to load user data: %v", err))
+	}
+	return HealthCheck{Name: "storage", Status: HealthStatusOK}
+}
+
+// checkConfig 检查最近一次配置生成是否成功
+func (s *HealthService) checkConfig() HealthCheck {
+	generatedAt, err := s.configService.LastGeneration()
+	if generatedAt.IsZero() {
+		return failedCheck("config", "config has not been generated yet")
+	}
+	if err != nil {
+		return failedCheck("config", fmt.Sprintf("last config generation failed: %v", err))
+	}
+	return HealthCheck{
+		Name:    "config",
+		Status:  HealthStatusOK,
+		Message: "generated at " + generatedAt.Format(time.RFC3339),
+	}
+}
+
+// checkSingBox 检查sing-box进程是否存活
+func (s *HealthService) checkSingBox() HealthCheck {
+	if !s.singBoxRunning() {
+		return failedCheck("sing-box", "sing-box process is not running")
+	}
+	return HealthCheck{Name: "sing-box", Status: HealthStatusOK}
+}
+
+// checkInbounds 检查每个启用的入站端口是否在监听
+func (s *HealthService) checkInbounds() []HealthCheck {
+	checks := make([]HealthCheck, 0)
+	for _, inbound := range s.configService.Inbounds() {
+		for _, network := range inboundNetworks(inbound.Type) {
+			name := fmt.Sprintf("inbound:%s/%s", inbound.Tag, network)
+
+			var address string
+			var err error
+			if network == "udp" {
+				address = ":" + strconv.Itoa(inbound.ListenPort)
+				err = probeUDP(s.udpTables, inbound.ListenPort)
+			} else {
+				address = net.JoinHostPort(s.probeHost, strconv.Itoa(inbound.ListenPort))
+				err = probeTCP(address, s.timeout)
+			}
+
+			if err != nil {
+				checks = append(checks, failedCheck(name, err.Error()))
+				continue
+			}
+			checks = append(checks, HealthCheck{Name: name, Status: HealthStatusOK, Message: address})
+		}
+	}
+	return checks
+}
+
+// inboundNetworks 返回入站类型使用的传输层协议
+func inboundNetworks(inboundType string) []string {
+	switch inboundType {
+	case "hysteria", "hysteria2", "tuic":
+		return []string{"udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// probeTCP 检查TCP端口是否接受连接
+func probeTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return fmt.Errorf("tcp port not accepting connections: %v", err)
+	}
+	return conn.Close()
+}
+
+// probeUDP 检查是否有套接字绑定了该UDP端口
+// UDP无连接，无法通过拨号判断；这里读取内核套接字表而不是尝试绑定端口，
+// 避免sing-box启动或重启期间探测临时占用端口导致其绑定失败
+func probeUDP(tables []string, port int) error {
+	read := 0
+	for _, table := range tables {
+		data, err := os.ReadFile(table)
+		if errors.Is(err, fs.ErrNotExist) {
+			// 未启用IPv6时没有udp6表
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("udp probe failed: %v", err)
+		}
+		read++
+		if udpTableHasPort(string(data), port) {
+			return nil
+		}
+	}
+	if read == 0 {
+		return fmt.Errorf("udp probe failed: no udp socket table available")
+	}
+	return fmt.Errorf("udp port is not bound")
+}
+
+// udpTableHasPort 解析/proc/net/udp格式的套接字表，判断是否有未连接的套接字绑定了该端口
+func udpTableHasPort(table string, port int) bool {
+	for _, line := range strings.Split(table, "\n") {
+		// 格式: sl local_address rem_address st ...，地址为十六进制的 IP:端口，表头行的st列不会匹配
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != udpStateUnconnected {
+			continue
+		}
+		sep := strings.LastIndex(fields[1], ":")
+		if sep < 0 {
+			continue
+		}
+		localPort, err := strconv.ParseUint(fields[1][sep+1:], 16, 16)
+		if err == nil && int(localPort) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// failedCheck 构造失败的检查结果
+func failedCheck(name, message string) HealthCheck {
+	return HealthCheck{Name: name, Status: HealthStatusFail, Message: message}
+}
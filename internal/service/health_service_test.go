@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeUDPTable 写入/proc/net/udp格式的套接字表，ports为处于监听状态的端口
+func writeUDPTable(t *testing.T, dir string, ports ...int) string {
+	t.Helper()
+	table := "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n"
+	for i, port := range ports {
+		table += fmt.Sprintf("  %d: 00000000:%04X 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 %d 2 0000000000000000 0\n", i, port, 1000+i)
+	}
+	// 已连接的套接字不算监听
+	table += "  99: 0100007F:1F90 0100007F:0035 01 00000000:00000000 00:00000000 00000000     0        0 2000 2 0000000000000000 0\n"
+
+	path := filepath.Join(dir, "udp")
+	if err := os.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckReadiness(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	tcpPort := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	const udpPort = 8443
+
+	tests := []struct {
+		name      string
+		tcpPort   int
+		udpListen []int
+		udpTables func(dir, table string) []string
+		running   bool
+		generate  bool
+		ready     bool
+		failed    string
+	}{
+		{"all checks pass", tcpPort, []int{udpPort}, nil, true, true, true, ""},
+		{"udp6 table missing", tcpPort, []int{udpPort}, func(dir, table string) []string { return []string{table, filepath.Join(dir, "udp6")} }, true, true, true, ""},
+		{"sing-box not running", tcpPort, []int{udpPort}, nil, false, true, false, "sing-box"},
+		{"config not generated", tcpPort, []int{udpPort}, nil, true, false, false, "config"},
+		{"tcp port closed", closedPort, []int{udpPort}, nil, true, true, false, "inbound:vless-in/tcp"},
+		{"udp port not bound", tcpPort, []int{udpPort + 1}, nil, true, true, false, "inbound:hy2-in/udp"},
+		{"no udp table", tcpPort, []int{udpPort}, func(dir, table string) []string { return []string{filepath.Join(dir, "udp6")} }, true, true, false, "inbound:hy2-in/udp"},
+	}
+	for _, tt := range tests {
+		s := newTestServices(t)
+		s.config.SetInboundTemplates([]Inbound{
+			{Type: "vless", Tag: "vless-in", Listen: "::", ListenPort: tt.tcpPort},
+			{Type: "hysteria2", Tag: "hy2-in", Listen: "::", ListenPort: udpPort},
+		})
+		if tt.generate {
+			if err := s.config.GenerateConfig(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		health := NewHealthService(s.users, s.config, "127.0.0.1")
+		table := writeUDPTable(t, s.dir, tt.udpListen...)
+		health.udpTables = []string{table}
+		if tt.udpTables != nil {
+			health.udpTables = tt.udpTables(s.dir, table)
+		}
+		running := tt.running
+		health.singBoxRunning = func() bool { return running }
+
+		report := health.CheckReadiness()
+		if report.Ready() != tt.ready {
+			t.Errorf("%s: ready = %v, want %v (checks %+v)", tt.name, report.Ready(), tt.ready, report.Checks)
+		}
+		for _, check := range report.Checks {
+			if failed := check.Status != HealthStatusOK; failed != (check.Name == tt.failed) {
+				t.Errorf("%s: check %s status = %s (%s)", tt.name, check.Name, check.Status, check.Message)
+			}
+		}
+	}
+}
+
+func TestProbeUDPDoesNotBindPort(t *testing.T) {
+	if _, err := os.Stat(udpSocketTables[0]); err != nil {
+		t.Skip("udp socket table not available")
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	if err := probeUDP(udpSocketTables, port); err != nil {
+		t.Fatalf("bound port: %v", err)
+	}
+	conn.Close()
+	if err := probeUDP(udpSocketTables, port); err == nil {
+		t.Fatal("released port reported as bound")
+	}
+}
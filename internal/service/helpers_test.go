@@ -0,0 +1,95 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+)
+
+// testServices 测试用的服务集合，数据保存在临时目录中
+type testServices struct {
+	dir      string
+	users    *storage.JSONStorage
+	plans    *storage.PlanStorage
+	admins   *storage.AdminStorage
+	sessions *storage.SessionStorage
+	ledger   *storage.LedgerStorage
+	audit    *AuditService
+	user     *UserService
+	plan     *PlanService
+	admin    *AdminService
+	config   *ConfigService
+	vouchers *storage.VoucherStorage
+	trials   *storage.TrialStorage
+}
+
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	users := storage.NewJSONStorage(path("users.json"))
+	if err := users.LoadError(); err != nil {
+		t.Fatal(err)
+	}
+	plans, err := storage.NewPlanStorage(path("plans.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admins, err := storage.NewAdminStorage(path("admins.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := storage.NewLedgerStorage(path("ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditStorage, err := storage.NewAuditStorage(path("audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vouchers, err := storage.NewVoucherStorage(path("vouchers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trials, err := storage.NewTrialStorage(path("trials.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := storage.NewSessionStorage(path("sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServices{dir: dir, users: users, plans: plans, admins: admins, sessions: sessions, ledger: ledger, vouchers: vouchers, trials: trials}
+	s.audit = NewAuditService(auditStorage)
+	s.user = NewUserService(users, plans, ledger, s.audit)
+	s.config = NewConfigService(users, s.audit, path("sing-box.json"), path("template.json"), "test", nil)
+	s.user.SetConfigService(s.config)
+	s.plan = NewPlanService(plans, s.user, s.config, s.audit)
+	s.admin = NewAdminService(admins, sessions)
+	return s
+}
+
+// addPlan 直接写入套餐
+func (s *testServices) addPlan(t *testing.T, plan *models.Plan) *models.Plan {
+	t.Helper()
+	plan.CreatedAt = time.Now()
+	if err := s.plans.CreatePlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+// addAdmin 直接写入管理员
+func (s *testServices) addAdmin(t *testing.T, admin *models.Admin) *models.Admin {
+	t.Helper()
+	admin.IsActive = true
+	if err := s.admins.CreateAdmin(admin); err != nil {
+		t.Fatal(err)
+	}
+	return admin
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 用户状态，按优先级判定：禁用 > 过期 > 流量超限 > 活跃
+const (
+	userStateActive   = "active"
+	userStateExpired  = "expired"
+	userStateExceeded = "exceeded"
+	userStateDisabled = "disabled"
+)
+
+var (
+	usersDesc       = prometheus.NewDesc("sbm_users", "Number of users by state.", []string{"state"}, nil)
+	trafficUsedDesc = prometheus.NewDesc("sbm_users_traffic_used_bytes", "Total traffic used by all users in bytes.", nil, nil)
+	nodesDesc       = prometheus.NewDesc("sbm_nodes", "Number of enabled edge nodes by state.", []string{"state"}, nil)
+	singBoxUpDesc   = prometheus.NewDesc("sbm_singbox_up", "Whether the local sing-box process is running.", nil, nil)
+)
+
+// MetricsCollector 在每次抓取时统计用户、节点和sing-box进程状态
+type MetricsCollector struct {
+	userService   *UserService
+	nodeService   *NodeService
+	configService *ConfigService
+}
+
+// NewMetricsCollector 创建状态指标采集器
+func NewMetricsCollector(userService *UserService, nodeService *NodeService, configService *ConfigService) *MetricsCollector {
+	return &MetricsCollector{
+		userService:   userService,
+		nodeService:   nodeService,
+		configService: configService,
+	}
+}
+
+// Describe 实现prometheus.Collector
+func (m *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- trafficUsedDesc
+	ch <- nodesDesc
+	ch <- singBoxUpDesc
+}
+
+// Collect 实现prometheus.Collector
+func (m *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	if users, err := m.userService.ListUsers(); err == nil {
+		states := map[string]int{
+			userStateActive:   0,
+			userStateExpired:  0,
+			userStateExceeded: 0,
+			userStateDisabled: 0,
+		}
+		var trafficUsed int64
+		for _, user := range users {
+			trafficUsed += user.TrafficUsed
+			switch {
+			case !user.IsActive:
+				states[userStateDisabled]++
+			case user.IsExpired():
+				states[userStateExpired]++
+			case user.IsTrafficExceeded():
+				states[userStateExceeded]++
+			default:
+				states[userStateActive]++
+			}
+		}
+
+		for state, count := range states {
+			ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(count), state)
+		}
+		ch <- prometheus.MustNewConstMetric(trafficUsedDesc, prometheus.GaugeValue, float64(trafficUsed))
+	}
+
+	if nodes, err := m.nodeService.ListNodes(); err == nil {
+		var online, offline int
+		for _, node := range nodes {
+			if !node.Enabled {
+				continue
+			}
+			if m.nodeService.IsOnline(node) {
+				online++
+			} else {
+				offline++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(nodesDesc, prometheus.GaugeValue, float64(online), "online")
+		ch <- prometheus.MustNewConstMetric(nodesDesc, prometheus.GaugeValue, float64(offline), "offline")
+	}
+
+	var up float64
+	if m.configService.IsSingBoxRunning() {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(singBoxUpDesc, prometheus.GaugeValue, up)
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/metrics"
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateReport 节点重发了已处理过的流量上报
+var ErrDuplicateReport = errors.New("traffic report already applied")
+
+// nodeTokenPrefix 节点令牌前缀，便于与管理API密钥区分
+const nodeTokenPrefix = "sbn_"
+
+// nodeActiveUserWindow 统计节点活跃用户数的时间窗口
+const nodeActiveUserWindow = 24 * time.Hour
+
+// AgentConfig 下发给边缘节点的用户和入站定义
+type AgentConfig struct {
+	Node     *models.Node   `json:"node"`
+	Users    []*models.User `json:"users"`
+	Inbounds []Inbound      `json:"inbounds"`
+}
+
+// NodeService 边缘节点管理服务
+type NodeService struct {
+	storage       *storage.NodeStorage
+	traffic       *storage.NodeTrafficStorage
+	userService   *UserService
+	configService *ConfigService
+	audit         *AuditService
+	offlineAfter  time.Duration
+}
+
+// NewNodeService 创建节点服务，超过offlineAfter未收到心跳的节点视为离线
+func NewNodeService(storage *storage.NodeStorage, traffic *storage.NodeTrafficStorage, userService *UserService, configService *ConfigService, audit *AuditService, offlineAfter time.Duration) *NodeService {
+	return &NodeService{
+		storage:       storage,
+		traffic:       traffic,
+		userService:   userService,
+		configService: configService,
+		audit:         audit,
+		offlineAfter:  offlineAfter,
+	}
+}
+
+// CreateNode 注册节点，返回节点及其明文令牌，令牌只在此时返回一次
+func (s *NodeService) CreateNode(ctx context.Context, req *models.CreateNodeRequest) (*models.Node, string, error) {
+	token, err := generateNodeToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	node := &models.Node{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Address:   strings.TrimSpace(req.Address),
+		Groups:    models.NormalizeGroups(req.Groups),
+		TokenHash: hashAPIKey(token),
+		Enabled:   true,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.storage.CreateNode(node); err != nil {
+		return nil, "", err
+	}
+
+	s.audit.Record(ctx, AuditNodeCreate, "node", node.ID, nil, node.Sanitized())
+	return node, token, nil
+}
+
+// GetNode 获取节点
+func (s *NodeService) GetNode(id string) (*models.Node, error) {
+	return s.storage.GetNode(id)
+}
+
+// ListNodes 列出所有节点
+func (s *NodeService) ListNodes() ([]*models.Node, error) {
+	return s.storage.ListNodes()
+}
+
+// ListOnlineNodes 列出所有启用且在线的节点
+func (s *NodeService) ListOnlineNodes() ([]*models.Node, error) {
+	nodes, err := s.storage.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	online := make([]*models.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Enabled && s.IsOnline(node) {
+			online = append(online, node)
+		}
+	}
+	return online, nil
+}
+
+// IsOnline 检查节点是否按时发送心跳
+func (s *NodeService) IsOnline(node *models.Node) bool {
+	return node.IsOnline(s.offlineAfter)
+}
+
+// UpdateNode 更新节点
+func (s *NodeService) UpdateNode(ctx context.Context, id string, req *models.UpdateNodeRequest) (*models.Node, error) {
+	var before *models.Node
+	node, err := s.storage.ModifyNode(id, func(node *models.Node) error {
+		before = node.Sanitized()
+		if req.Name != nil {
+			node.Name = *req.Name
+		}
+		if req.Address != nil {
+			node.Address = strings.TrimSpace(*req.Address)
+		}
+		if req.Groups != nil {
+			node.Groups = models.NormalizeGroups(*req.Groups)
+		}
+		if req.Enabled != nil {
+			node.Enabled = *req.Enabled
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditNodeUpdate, "node", id, before, node.Sanitized())
+	return node, nil
+}
+
+// DeleteNode 删除节点，节点令牌随即失效
+func (s *NodeService) DeleteNode(ctx context.Context, id string) error {
+	node, err := s.storage.GetNode(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteNode(id); err != nil {
+		return err
+	}
+	if err := s.traffic.DeleteNode(id); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditNodeDelete, "node", id, node.Sanitized(), nil)
+	return nil
+}
+
+// RotateToken 为节点签发新令牌，旧令牌立即失效
+func (s *NodeService) RotateToken(ctx context.Context, id string) (string, error) {
+	token, err := generateNodeToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.storage.ModifyNode(id, func(node *models.Node) error {
+		node.TokenHash = hashAPIKey(token)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.audit.Record(ctx, AuditNodeRotateToken, "node", id, nil, nil)
+	return token, nil
+}
+
+// Authenticate 校验节点令牌，返回对应的启用节点
+func (s *NodeService) Authenticate(token string) (*models.Node, error) {
+	if !strings.HasPrefix(token, nodeTokenPrefix) {
+		return nil, fmt.Errorf("invalid node token")
+	}
+
+	node, err := s.storage.GetNodeByTokenHash(hashAPIKey(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid node token")
+	}
+
+	if !node.Enabled {
+		return nil, fmt.Errorf("node is disabled")
+	}
+
+	return node, nil
+}
+
+// AgentConfig 生成下发给节点的配置，只包含有权使用该节点的用户，并记录节点的同步时间
+func (s *NodeService) AgentConfig(node *models.Node) (*AgentConfig, error) {
+	users, err := s.userService.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	// 节点不提供订阅服务，不下发订阅令牌
+	agentUsers := make([]*models.User, 0, len(users))
+	for _, user := range users {
+		if !user.CanAccessNode(node.Groups) {
+			continue
+		}
+		clone := user.Clone()
+		clone.SubscriptionToken = ""
+		agentUsers = append(agentUsers, clone)
+	}
+
+	if err := s.storage.TouchNode(node.ID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &AgentConfig{
+		Node:     node.Sanitized(),
+		Users:    agentUsers,
+		Inbounds: s.configService.InboundsFor(node.Address),
+	}, nil
+}
+
+// Heartbeat 记录节点心跳
+func (s *NodeService) Heartbeat(node *models.Node, status *models.NodeStatus) error {
+	return s.storage.RecordHeartbeat(node.ID, status, time.Now())
+}
+
+// ReportTraffic 将节点上报的流量增量累加到用户总用量和该节点的流量统计，并记录节点上活动过的设备，未知用户(如已被删除)会被忽略
+// 整份上报的用户流量一次写入，并只记录一条审计日志；重发的上报返回ErrDuplicateReport，不会重复计入
+func (s *NodeService) ReportTraffic(ctx context.Context, node *models.Node, report *models.TrafficReport) error {
+	usage := make(map[string]int64, len(report.Usage))
+	for userID, bytesUsed := range report.Usage {
+		if bytesUsed <= 0 {
+			continue
+		}
+		if _, err := s.userService.GetUser(userID); err != nil {
+			continue
+		}
+		usage[userID] = bytesUsed
+	}
+
+	// 先记录上报ID再写入用户流量：两次写入之间出错时宁可少计一次，也不会在重发时重复计入
+	duplicate, err := s.traffic.ApplyReport(node.ID, report.ID, usage)
+	if err != nil {
+		return err
+	}
+	if duplicate {
+		return ErrDuplicateReport
+	}
+
+	applied, err := s.userService.ApplyTrafficDeltas(usage)
+	if err != nil {
+		if revertErr := s.traffic.RevertReport(node.ID, report.ID, usage); revertErr != nil {
+			slog.ErrorContext(ctx, "Failed to revert node traffic report", "node", node.Name, "report_id", report.ID, "error", revertErr)
+		}
+		return err
+	}
+
+	if err := s.userService.RecordDeviceActivity(report.Devices); err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		return nil
+	}
+
+	var total int64
+	for _, bytesUsed := range applied {
+		total += bytesUsed
+	}
+	for inbound, bytesUsed := range inboundTraffic(total, report.Inbounds) {
+		metrics.AddTraffic(node.Name, inbound, bytesUsed)
+	}
+
+	s.audit.Record(ctx, AuditNodeTraffic, "node", node.ID, nil, map[string]interface{}{
+		"report_id": report.ID,
+		"users":     len(applied),
+		"bytes":     total,
+	})
+	return nil
+}
+
+// Stats 返回节点的在线状态和各用户在该节点上的流量，按流量从高到低排序
+// 活跃用户数只统计nodeActiveUserWindow内上报过流量的用户
+func (s *NodeService) Stats(id string) (*models.NodeStats, error) {
+	node, err := s.storage.GetNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	users := s.traffic.NodeTraffic(id)
+	activeSince := time.Now().Add(-nodeActiveUserWindow)
+	var total int64
+	active := 0
+	for i := range users {
+		total += users[i].BytesUsed
+		if users[i].LastActiveAt != nil && users[i].LastActiveAt.After(activeSince) {
+			active++
+		}
+		if user, err := s.userService.GetUser(users[i].UserID); err == nil {
+			users[i].Username = user.Username
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].BytesUsed > users[j].BytesUsed
+	})
+
+	return &models.NodeStats{
+		Node:         node.Sanitized(),
+		Online:       s.IsOnline(node),
+		TotalTraffic: total,
+		ActiveUsers:  active,
+		Users:        users,
+	}, nil
+}
+
+// inboundTraffic 按入站拆分实际计入的流量，未标明入站的部分记在空标签下
+// 部分用户已被删除时各入站之和可能超过total，按total截断
+func inboundTraffic(total int64, inbounds map[string]int64) map[string]int64 {
+	tags := make([]string, 0, len(inbounds))
+	for tag := range inbounds {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	traffic := make(map[string]int64, len(tags)+1)
+	remaining := total
+	for _, tag := range tags {
+		bytesUsed := inbounds[tag]
+		if bytesUsed > remaining {
+			bytesUsed = remaining
+		}
+		if bytesUsed <= 0 {
+			continue
+		}
+		traffic[tag] = bytesUsed
+		remaining -= bytesUsed
+	}
+	if remaining > 0 {
+		traffic[""] = remaining
+	}
+	return traffic
+}
+
+// generateNodeToken 生成节点令牌
+func generateNodeToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate node token: %v", err)
+	}
+	return nodeTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
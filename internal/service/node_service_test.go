@@ -0,0 +1,26 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInboundTraffic(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int64
+		inbounds map[string]int64
+		want     map[string]int64
+	}{
+		{"no inbound tags", 100, nil, map[string]int64{"": 100}},
+		{"fully tagged", 100, map[string]int64{"vless": 60, "trojan": 40}, map[string]int64{"vless": 60, "trojan": 40}},
+		{"partly tagged", 100, map[string]int64{"vless": 30}, map[string]int64{"vless": 30, "": 70}},
+		{"tags exceed applied traffic", 50, map[string]int64{"trojan": 40, "vless": 40}, map[string]int64{"trojan": 40, "vless": 10}},
+		{"nothing applied", 0, map[string]int64{"vless": 40}, map[string]int64{}},
+	}
+	for _, tt := range tests {
+		if got := inboundTraffic(tt.total, tt.inbounds); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
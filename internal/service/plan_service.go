@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// PlanService 套餐模板管理服务
+type PlanService struct {
+	storage       *storage.PlanStorage
+	userService   *UserService
+	configService *ConfigService
+	audit         *AuditService
+}
+
+// NewPlanService 创建套餐服务
+func NewPlanService(storage *storage.PlanStorage, userService *UserService, configService *ConfigService, audit *AuditService) *PlanService {
+	return &PlanService{
+		storage:       storage,
+		userService:   userService,
+		configService: configService,
+		audit:         audit,
+	}
+}
+
+// CreatePlan 创建套餐
+func (s *PlanService) CreatePlan(ctx context.Context, req *models.CreatePlanRequest) (*models.Plan, error) {
+	if !models.IsValidResetCycle(req.TrafficResetCycle) {
+		return nil, fmt.Errorf("invalid traffic_reset_cycle: %s", req.TrafficResetCycle)
+	}
+	inbounds := models.NormalizeInbounds(req.AllowedInbounds)
+	if err := s.validateInbounds(inbounds); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	plan := &models.Plan{
+		ID:                uuid.New().String(),
+		Name:              strings.TrimSpace(req.Name),
+		DurationDays:      req.DurationDays,
+		TrafficLimit:      req.TrafficLimit,
+		DeviceLimit:       req.DeviceLimit,
+		SpeedLimit:        models.NormalizeSpeedLimit(req.SpeedLimit),
+		TrafficResetCycle: req.TrafficResetCycle,
+		AllowedInbounds:   inbounds,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	if err := s.storage.CreatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditPlanCreate, "plan", plan.ID, nil, plan)
+	return plan, nil
+}
+
+// GetPlan 获取套餐
+func (s *PlanService) GetPlan(id string) (*models.Plan, error) {
+	return s.storage.GetPlan(id)
+}
+
+// ListPlans 列出所有套餐
+func (s *PlanService) ListPlans() ([]*models.Plan, error) {
+	return s.storage.ListPlans()
+}
+
+// UpdatePlan 更新套餐，只影响之后按该套餐创建或更新的用户
+func (s *PlanService) UpdatePlan(ctx context.Context, id string, req *models.UpdatePlanRequest) (*models.Plan, error) {
+	plan, err := s.storage.GetPlan(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *plan
+	if req.Name != nil {
+		updated.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.DurationDays != nil {
+		updated.DurationDays = *req.DurationDays
+	}
+	if req.TrafficLimit != nil {
+		updated.TrafficLimit = *req.TrafficLimit
+	}
+	if req.DeviceLimit != nil {
+		updated.DeviceLimit = *req.DeviceLimit
+	}
+	if req.SpeedLimit != nil {
+		updated.SpeedLimit = models.NormalizeSpeedLimit(req.SpeedLimit)
+	}
+	if req.TrafficResetCycle != nil {
+		if !models.IsValidResetCycle(*req.TrafficResetCycle) {
+			return nil, fmt.Errorf("invalid traffic_reset_cycle: %s", *req.TrafficResetCycle)
+		}
+		updated.TrafficResetCycle = *req.TrafficResetCycle
+	}
+	if req.AllowedInbounds != nil {
+		updated.AllowedInbounds = models.NormalizeInbounds(*req.AllowedInbounds)
+		if err := s.validateInbounds(updated.AllowedInbounds); err != nil {
+			return nil, err
+		}
+	}
+	if updated.Name == "" {
+		return nil, fmt.Errorf("plan name is required")
+	}
+	updated.UpdatedAt = time.Now()
+
+	if err := s.storage.UpdatePlan(id, &updated); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditPlanUpdate, "plan", id, plan, &updated)
+	return &updated, nil
+}
+
+// DeletePlan 删除套餐，仍有用户使用的套餐不能删除，以免统计时无法对应
+func (s *PlanService) DeletePlan(ctx context.Context, id string) error {
+	plan, err := s.storage.GetPlan(id)
+	if err != nil {
+		return err
+	}
+
+	users, err := s.userService.ListUsers()
+	if err != nil {
+		return err
+	}
+	assigned := 0
+	for _, user := range users {
+		if user.PlanID == id {
+			assigned++
+		}
+	}
+	if assigned > 0 {
+		return fmt.Errorf("plan %s is assigned to %d users", plan.Name, assigned)
+	}
+
+	if err := s.storage.DeletePlan(id); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditPlanDelete, "plan", id, plan, nil)
+	return nil
+}
+
+// PlanStats 统计各套餐的用户数，键为套餐ID
+func (s *PlanService) PlanStats() (map[string]int, error) {
+	users, err := s.userService.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]int)
+	for _, user := range users {
+		if user.PlanID != "" {
+			stats[user.PlanID]++
+		}
+	}
+	return stats, nil
+}
+
+// validateInbounds 检查入站标签是否都已定义
+func (s *PlanService) validateInbounds(tags []string) error {
+	return checkInbounds(s.configService, tags)
+}
+
+// checkInbounds 检查入站标签是否都是配置中定义的入站
+func checkInbounds(configService *ConfigService, tags []string) error {
+	known := make(map[string]bool)
+	for _, inbound := range configService.Inbounds() {
+		known[inbound.Tag] = true
+	}
+
+	for _, tag := range tags {
+		if !known[tag] {
+			return fmt.Errorf("unknown inbound: %s", tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// 二维码图片格式
+const (
+	QRCodeFormatPNG = "png"
+	QRCodeFormatSVG = "svg"
+)
+
+// 二维码尺寸范围(像素)
+const (
+	DefaultQRCodeSize = 256
+	minQRCodeSize     = 64
+	maxQRCodeSize     = 1024
+)
+
+// ErrUnsupportedQRCodeFormat 不支持的二维码格式
+var ErrUnsupportedQRCodeFormat = errors.New("unsupported qrcode format")
+
+// RenderQRCode 将内容渲染为PNG或SVG二维码，返回图片数据及其Content-Type
+func RenderQRCode(content, format string, size int) ([]byte, string, error) {
+	if size < minQRCodeSize || size > maxQRCodeSize {
+		return nil, "", fmt.Errorf("qrcode size must be between %d and %d", minQRCodeSize, maxQRCodeSize)
+	}
+
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode qrcode: %v", err)
+	}
+
+	switch format {
+	case QRCodeFormatPNG:
+		data, err := code.PNG(size)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to render qrcode: %v", err)
+		}
+		return data, "image/png", nil
+	case QRCodeFormatSVG:
+		return renderQRCodeSVG(code.Bitmap(), size), "image/svg+xml", nil
+	default:
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedQRCodeFormat, format)
+	}
+}
+
+// renderQRCodeSVG 将二维码点阵渲染为SVG，每个深色模块绘制为1x1的矩形并整体缩放
+func renderQRCodeSVG(bitmap [][]bool, size int) []byte {
+	modules := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, modules, modules)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`, modules, modules)
+	b.WriteString(`<path fill="#000000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return []byte(b.String())
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiterSweepSize 记录的键超过该数量时清理已过期的记录，防止内存无限增长
+const attemptLimiterSweepSize = 10000
+
+// attemptLimiter 按键(用户名、IP等)统计时间窗口内的失败次数，达到上限后拒绝尝试直到窗口过去
+// 记录只保存在内存中，进程重启后清空
+type attemptLimiter struct {
+	limit  int
+	window time.Duration
+
+	mutex    sync.Mutex
+	failures map[string][]time.Time
+}
+
+// newAttemptLimiter 创建失败次数限制器，limit为0表示不限制
+func newAttemptLimiter(limit int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		limit:    limit,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// Allow 检查键在时间窗口内的失败次数是否未达到上限
+func (l *attemptLimiter) Allow(key string, now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.recent(key, now)) < l.limit
+}
+
+// Fail 记录一次失败
+func (l *attemptLimiter) Fail(key string, now time.Time) {
+	if l.limit <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.failures) >= attemptLimiterSweepSize {
+		for k := range l.failures {
+			l.recent(k, now)
+		}
+	}
+	l.failures[key] = append(l.recent(key, now), now)
+}
+
+// Reset 清除键的失败记录，成功后调用
+func (l *attemptLimiter) Reset(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.failures, key)
+}
+
+// recent 返回时间窗口内的失败记录并丢弃过期的记录，调用方需持有锁
+func (l *attemptLimiter) recent(key string, now time.Time) []time.Time {
+	cutoff := now.Add(-l.window)
+	failures := l.failures[key]
+	i := 0
+	for i < len(failures) && !failures[i].After(cutoff) {
+		i++
+	}
+	if i == len(failures) {
+		delete(l.failures, key)
+		return nil
+	}
+	failures = failures[i:]
+	l.failures[key] = failures
+	return failures
+}
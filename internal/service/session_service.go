@@ -0,0 +1,310 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// jwtIssuer JWT签发者，同时用作TOTP验证器中显示的名称
+const jwtIssuer = "sing-box-manager"
+
+// 登录失败次数限制：同一用户名或同一IP在时间窗口内失败过多后暂时拒绝登录
+const (
+	loginFailureWindow      = 15 * time.Minute
+	loginMaxUserFailures    = 5
+	loginMaxAddressFailures = 20
+)
+
+var (
+	// ErrTOTPRequired 账号启用了二次验证但登录请求未携带验证码
+	ErrTOTPRequired = errors.New("totp code required")
+	// ErrLoginLocked 登录失败次数过多
+	ErrLoginLocked = errors.New("too many failed login attempts, try again later")
+	// errInvalidTOTP 验证码错误或已被使用
+	errInvalidTOTP = errors.New("invalid totp code")
+)
+
+// accessClaims 访问令牌声明
+type accessClaims struct {
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// SessionService 管理员登录会话服务
+type SessionService struct {
+	admins     *storage.AdminStorage
+	sessions   *storage.SessionStorage
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	// 按用户名和客户端IP统计的登录失败次数，密码和TOTP验证码错误都会计入
+	userFailures    *attemptLimiter
+	addressFailures *attemptLimiter
+}
+
+// NewSessionService 创建会话服务
+func NewSessionService(admins *storage.AdminStorage, sessions *storage.SessionStorage, secret []byte, accessTTL, refreshTTL time.Duration) *SessionService {
+	return &SessionService{
+		admins:     admins,
+		sessions:   sessions,
+		secret:     secret,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+
+		userFailures:    newAttemptLimiter(loginMaxUserFailures, loginFailureWindow),
+		addressFailures: newAttemptLimiter(loginMaxAddressFailures, loginFailureWindow),
+	}
+}
+
+// Login 校验用户名密码(及TOTP验证码)并创建会话
+// 同一用户名或IP失败次数过多时暂时拒绝登录，用户名不存在时同样计算一次bcrypt，响应时间不会泄露用户名是否存在
+func (s *SessionService) Login(req *models.LoginRequest, clientIP string) (*models.TokenPair, error) {
+	now := time.Now()
+	if !s.userFailures.Allow(req.Username, now) || !s.addressFailures.Allow(clientIP, now) {
+		return nil, ErrLoginLocked
+	}
+	fail := func() {
+		s.userFailures.Fail(req.Username, now)
+		s.addressFailures.Fail(clientIP, now)
+	}
+
+	admin, err := s.admins.GetAdminByUsername(req.Username)
+	if err != nil {
+		checkPassword(dummyPasswordHash(), req.Password)
+		fail()
+		return nil, fmt.Errorf("invalid username or password")
+	}
+	if !checkPassword(admin.PasswordHash, req.Password) {
+		fail()
+		return nil, fmt.Errorf("invalid username or password")
+	}
+
+	if !admin.IsActive {
+		return nil, fmt.Errorf("admin account is disabled")
+	}
+
+	if admin.TOTPEnabled {
+		if req.TOTPCode == "" {
+			return nil, ErrTOTPRequired
+		}
+		if admin, err = s.consumeTOTP(admin.ID, req.TOTPCode, nil); err != nil {
+			fail()
+			return nil, err
+		}
+	}
+	s.userFailures.Reset(req.Username)
+
+	sessionID := uuid.New().String()
+	refreshToken, refreshHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		ID:          sessionID,
+		AdminID:     admin.ID,
+		RefreshHash: refreshHash,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(s.refreshTTL),
+		ClientIP:    clientIP,
+	}
+	if err := s.sessions.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(admin, session.ID, refreshToken)
+}
+
+// Refresh 使用刷新令牌换取新的令牌对，旧刷新令牌随即失效
+func (s *SessionService) Refresh(refreshToken string) (*models.TokenPair, error) {
+	sessionID, _, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	newToken, newHash, err := newRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessions.RotateRefreshToken(sessionID, hashAPIKey(refreshToken), newHash, time.Now().Add(s.refreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := s.admins.GetAdmin(session.AdminID)
+	if err != nil || !admin.IsActive {
+		s.sessions.RevokeSession(session.ID)
+		return nil, fmt.Errorf("admin account is unavailable")
+	}
+
+	return s.issueTokens(admin, session.ID, newToken)
+}
+
+// Logout 吊销会话，会话下已签发的访问令牌同时失效
+func (s *SessionService) Logout(sessionID string) error {
+	return s.sessions.RevokeSession(sessionID)
+}
+
+// Authenticate 校验访问令牌并返回认证主体
+func (s *SessionService) Authenticate(accessToken string) (*models.Principal, error) {
+	claims := &accessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(jwtIssuer))
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token: %v", err)
+	}
+
+	session, err := s.sessions.GetSession(claims.SessionID)
+	if err != nil || !session.IsValid() {
+		return nil, fmt.Errorf("session has expired or been revoked")
+	}
+
+	admin, err := s.admins.GetAdmin(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("admin not found")
+	}
+
+	if !admin.IsActive {
+		return nil, fmt.Errorf("admin account is disabled")
+	}
+
+	return &models.Principal{Admin: admin, SessionID: session.ID}, nil
+}
+
+// ChangePassword 修改自己的密码，并吊销该账号的所有会话
+func (s *SessionService) ChangePassword(admin *models.Admin, req *models.ChangePasswordRequest) error {
+	if !checkPassword(admin.PasswordHash, req.CurrentPassword) {
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.admins.ModifyAdmin(admin.ID, func(admin *models.Admin) error {
+		admin.PasswordHash = hash
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeAdminSessions(admin.ID)
+}
+
+// SetupTOTP 为管理员生成新的TOTP密钥，需调用EnableTOTP验证后才会生效
+func (s *SessionService) SetupTOTP(admin *models.Admin) (string, string, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := s.admins.ModifyAdmin(admin.ID, func(admin *models.Admin) error {
+		if admin.TOTPEnabled {
+			return fmt.Errorf("totp is already enabled")
+		}
+		admin.TOTPSecret = secret
+		admin.TOTPLastStep = 0
+		return nil
+	}); err != nil {
+		return "", "", err
+	}
+
+	return secret, totpURL(jwtIssuer, admin.Username, secret), nil
+}
+
+// EnableTOTP 校验验证码并启用二次验证
+func (s *SessionService) EnableTOTP(admin *models.Admin, code string) error {
+	if admin.TOTPSecret == "" {
+		return fmt.Errorf("totp has not been set up")
+	}
+
+	_, err := s.consumeTOTP(admin.ID, code, func(admin *models.Admin) {
+		admin.TOTPEnabled = true
+	})
+	return err
+}
+
+// DisableTOTP 校验验证码并关闭二次验证
+func (s *SessionService) DisableTOTP(admin *models.Admin, code string) error {
+	if !admin.TOTPEnabled {
+		return fmt.Errorf("totp is not enabled")
+	}
+
+	_, err := s.consumeTOTP(admin.ID, code, func(admin *models.Admin) {
+		admin.TOTPEnabled = false
+		admin.TOTPSecret = ""
+		admin.TOTPLastStep = 0
+	})
+	return err
+}
+
+// consumeTOTP 在持有存储锁时校验验证码并记录其时间步，同一时间步及更早的验证码不能再次使用
+// 校验通过后由apply修改管理员记录，返回修改后的记录
+func (s *SessionService) consumeTOTP(adminID, code string, apply func(admin *models.Admin)) (*models.Admin, error) {
+	return s.admins.ModifyAdmin(adminID, func(admin *models.Admin) error {
+		step, ok := verifyTOTP(admin.TOTPSecret, code, time.Now())
+		if !ok || step <= admin.TOTPLastStep {
+			return errInvalidTOTP
+		}
+		admin.TOTPLastStep = step
+		if apply != nil {
+			apply(admin)
+		}
+		return nil
+	})
+}
+
+// issueTokens 签发访问令牌并与刷新令牌组成令牌对
+func (s *SessionService) issueTokens(admin *models.Admin, sessionID, refreshToken string) (*models.TokenPair, error) {
+	now := time.Now()
+	claims := accessClaims{
+		SessionID: sessionID,
+		Role:      admin.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   admin.ID,
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		},
+	}
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %v", err)
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL.Seconds()),
+	}, nil
+}
+
+// newRefreshToken 为会话生成刷新令牌及其哈希
+// 令牌格式为 <会话ID>.<随机部分>，便于刷新时直接定位会话
+func newRefreshToken(sessionID string) (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	token := sessionID + "." + base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashAPIKey(token), nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func newTestSessionService(t *testing.T) (*SessionService, *testServices, *models.Admin) {
+	t.Helper()
+	s := newTestServices(t)
+	admin, err := s.admin.CreateAdmin(&models.CreateAdminRequest{Username: "root", Password: "correct-horse", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSessionService(s.admins, s.sessions, []byte("secret"), time.Minute, time.Hour), s, admin
+}
+
+func TestLoginLockout(t *testing.T) {
+	sessions, _, _ := newTestSessionService(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		ip       string
+		want     error
+	}{
+		{"unknown user", "nobody", "whatever", "10.0.0.1", errLoginFailed},
+		{"wrong password 1", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"wrong password 2", "root", "wrong", "10.0.0.2", errLoginFailed},
+		{"successful login resets user failures", "root", "correct-horse", "10.0.0.3", nil},
+		{"wrong password 3", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"wrong password 4", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"wrong password 5", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"wrong password 6", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"wrong password 7", "root", "wrong", "10.0.0.1", errLoginFailed},
+		{"locked even with correct password", "root", "correct-horse", "10.0.0.4", ErrLoginLocked},
+	}
+	for _, tt := range tests {
+		_, err := sessions.Login(&models.LoginRequest{Username: tt.username, Password: tt.password}, tt.ip)
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.want == errLoginFailed && (err == nil || errors.Is(err, ErrLoginLocked)):
+			t.Errorf("%s: err = %v, want invalid credentials", tt.name, err)
+		case tt.want == ErrLoginLocked && !errors.Is(err, ErrLoginLocked):
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrLoginLocked)
+		}
+	}
+}
+
+func TestLoginRejectsReusedTOTPCode(t *testing.T) {
+	sessions, s, admin := newTestSessionService(t)
+
+	secret, _, err := sessions.SetupTOTP(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	step := time.Now().Unix() / int64(totpPeriod.Seconds())
+
+	admin, _ = s.admins.GetAdmin(admin.ID)
+	if err := sessions.EnableTOTP(admin, totpCode(key, step-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"code already used to enable totp", totpCode(key, step-1), false},
+		{"current code", totpCode(key, step), true},
+		{"current code replayed", totpCode(key, step), false},
+		{"next code", totpCode(key, step+1), true},
+	}
+	for _, tt := range tests {
+		_, err := sessions.Login(&models.LoginRequest{Username: "root", Password: "correct-horse", TOTPCode: tt.code}, "10.0.0.1")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestSetPasswordRevokesSessions(t *testing.T) {
+	sessions, s, admin := newTestSessionService(t)
+
+	tokens, err := sessions.Login(&models.LoginRequest{Username: "root", Password: "correct-horse"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessions.Authenticate(tokens.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.admin.SetPassword(admin.ID, "battery-staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessions.Authenticate(tokens.AccessToken); err == nil {
+		t.Fatal("access token still valid after password reset")
+	}
+	if _, err := sessions.Refresh(tokens.RefreshToken); err == nil {
+		t.Fatal("refresh token still valid after password reset")
+	}
+	// 已返回给调用方的记录不会被修改
+	if !checkPassword(admin.PasswordHash, "correct-horse") {
+		t.Fatal("previously returned admin was mutated")
+	}
+	if _, err := sessions.Login(&models.LoginRequest{Username: "root", Password: "battery-staple"}, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errLoginFailed 表示期望用户名或密码错误
+var errLoginFailed = errors.New("login failed")
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// sing-box日志格式：[时区 日期 时间 ]级别[启动秒数] [连接ID 耗时] 内容
+var singBoxLogLine = regexp.MustCompile(`^(?:([+-]\d{4} \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) )?(TRACE|DEBUG|INFO|WARN|ERROR|FATAL|PANIC)(?:\[\d+\])? (?:\[(\d+) [^\]]*\] )?(.*)$`)
+
+// 入站日志内容：inbound/类型[入站标签]: [用户名] ...
+var singBoxInboundLog = regexp.MustCompile(`^inbound/[\w-]+\[([^\]]+)\]: (?:\[([^\]]+)\] )?`)
+
+// 终端颜色控制符
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// singBoxLogTimeLayout sing-box开启timestamp后的时间格式
+const singBoxLogTimeLayout = "-0700 2006-01-02 15:04:05"
+
+// singBoxLogSubscriberBuffer 实时订阅通道的缓冲大小，消费过慢时丢弃新日志，不能丢日志的消费者使用Observe
+const singBoxLogSubscriberBuffer = 64
+
+// singBoxLogMaxLine 单行日志最大长度，超出后不再等待换行直接作为一行处理
+const singBoxLogMaxLine = 64 * 1024
+
+// SingBoxLogService 采集sing-box进程输出，保存在有界环形缓冲区中并写入轮转文件，同时推送给实时订阅者
+type SingBoxLogService struct {
+	file io.Writer
+
+	mutex   sync.RWMutex
+	entries []*models.SingBoxLogEntry
+	next    int
+	full    bool
+	partial []byte
+
+	subscribers map[chan *models.SingBoxLogEntry]struct{}
+	observers   []func(entry *models.SingBoxLogEntry)
+}
+
+// NewSingBoxLogService 创建sing-box日志服务，bufferSize为内存中保留的日志行数，file为nil时不写文件
+func NewSingBoxLogService(bufferSize int, file io.Writer) *SingBoxLogService {
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	return &SingBoxLogService{
+		file:        file,
+		entries:     make([]*models.SingBoxLogEntry, bufferSize),
+		subscribers: make(map[chan *models.SingBoxLogEntry]struct{}),
+	}
+}
+
+// Write 实现io.Writer，按行解析sing-box输出，不完整的行留到下次写入时拼接
+func (s *SingBoxLogService) Write(p []byte) (int, error) {
+	if s.file != nil {
+		if _, err := s.file.Write(p); err != nil {
+			slog.Warn("Failed to write sing-box log file", "error", err)
+		}
+	}
+
+	s.mutex.Lock()
+	data := append(s.partial, p...)
+	lines := make([]string, 0)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i+1:]
+	}
+	if len(data) > singBoxLogMaxLine {
+		lines = append(lines, string(data))
+		data = nil
+	}
+	s.partial = append([]byte(nil), data...)
+	s.mutex.Unlock()
+
+	for _, line := range lines {
+		if entry := parseSingBoxLogLine(line, time.Now()); entry != nil {
+			for _, observe := range s.add(entry) {
+				observe(entry)
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// add 写入环形缓冲区并推送给订阅者，返回需要在锁外调用的观察者
+func (s *SingBoxLogService) add(entry *models.SingBoxLogEntry) []func(entry *models.SingBoxLogEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries[s.next] = entry
+	s.next = (s.next + 1) % len(s.entries)
+	if s.next == 0 {
+		s.full = true
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+	return s.observers
+}
+
+// Query 按时间顺序返回满足条件的日志，limit大于0时只返回最新的limit条
+func (s *SingBoxLogService) Query(query *models.SingBoxLogQuery) []*models.SingBoxLogEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ordered := s.entries[:s.next]
+	if s.full {
+		ordered = append(append([]*models.SingBoxLogEntry{}, s.entries[s.next:]...), s.entries[:s.next]...)
+	}
+
+	result := make([]*models.SingBoxLogEntry, 0)
+	for _, entry := range ordered {
+		if query.Matches(entry) {
+			result = append(result, entry)
+		}
+	}
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[len(result)-query.Limit:]
+	}
+	return result
+}
+
+// Subscribe 订阅新产生的日志，使用完毕后必须调用返回的取消函数
+func (s *SingBoxLogService) Subscribe() (<-chan *models.SingBoxLogEntry, func()) {
+	ch := make(chan *models.SingBoxLogEntry, singBoxLogSubscriberBuffer)
+
+	s.mutex.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mutex.Unlock()
+
+	cancel := func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Observe 注册观察者，每条日志都会按写入顺序同步传给观察者，不会像订阅那样在消费过慢时丢弃
+// 观察者在写入sing-box输出的协程中调用，必须尽快返回
+func (s *SingBoxLogService) Observe(observe func(entry *models.SingBoxLogEntry)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// 复制后追加，add返回的旧切片不受影响
+	observers := make([]func(entry *models.SingBoxLogEntry), len(s.observers), len(s.observers)+1)
+	copy(observers, s.observers)
+	s.observers = append(observers, observe)
+}
+
+// parseSingBoxLogLine 解析一行sing-box日志，无法识别格式的行按info级别原样保留，空行返回nil
+func parseSingBoxLogLine(line string, now time.Time) *models.SingBoxLogEntry {
+	line = strings.TrimSpace(ansiEscape.ReplaceAllString(line, ""))
+	if line == "" {
+		return nil
+	}
+
+	match := singBoxLogLine.FindStringSubmatch(line)
+	if match == nil {
+		return &models.SingBoxLogEntry{Time: now, Level: "info", Message: line}
+	}
+
+	entry := &models.SingBoxLogEntry{
+		Time:         now,
+		Level:        strings.ToLower(match[2]),
+		ConnectionID: match[3],
+		Message:      match[4],
+	}
+	if match[1] != "" {
+		if t, err := time.Parse(singBoxLogTimeLayout, match[1]); err == nil {
+			entry.Time = t
+		}
+	}
+
+	if inbound := singBoxInboundLog.FindStringSubmatch(entry.Message); inbound != nil {
+		entry.Inbound = inbound[1]
+		entry.User = inbound[2]
+	}
+	return entry
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestParseSingBoxLogLine(t *testing.T) {
+	now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		line string
+		want *models.SingBoxLogEntry
+	}{
+		{"empty line", "   ", nil},
+		{
+			"plain info",
+			"INFO[0000] sing-box started (0.12s)",
+			&models.SingBoxLogEntry{Time: now, Level: "info", Message: "sing-box started (0.12s)"},
+		},
+		{
+			"connection source",
+			"INFO[0042] [3417504962 0ms] inbound/vless[vless-in]: inbound connection from 10.0.0.1:51234",
+			&models.SingBoxLogEntry{Time: now, Level: "info", ConnectionID: "3417504962", Inbound: "vless-in", Message: "inbound/vless[vless-in]: inbound connection from 10.0.0.1:51234"},
+		},
+		{
+			"connection user",
+			"INFO[0042] [3417504962 1ms] inbound/vless[vless-in]: [alice] inbound connection to example.com:443",
+			&models.SingBoxLogEntry{Time: now, Level: "info", ConnectionID: "3417504962", Inbound: "vless-in", User: "alice", Message: "inbound/vless[vless-in]: [alice] inbound connection to example.com:443"},
+		},
+		{
+			"timestamp and colors",
+			"+0800 2026-01-15 20:00:01 \x1b[31mERROR\x1b[0m[0001] [12 5ms] inbound/trojan[trojan-in]: process connection: EOF",
+			&models.SingBoxLogEntry{Time: time.Date(2026, 1, 15, 12, 0, 1, 0, time.UTC), Level: "error", ConnectionID: "12", Inbound: "trojan-in", Message: "inbound/trojan[trojan-in]: process connection: EOF"},
+		},
+		{
+			"unknown format",
+			"panic: runtime error",
+			&models.SingBoxLogEntry{Time: now, Level: "info", Message: "panic: runtime error"},
+		},
+	}
+	for _, tt := range tests {
+		got := parseSingBoxLogLine(tt.line, now)
+		if tt.want == nil || got == nil {
+			if got != tt.want {
+				t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+			}
+			continue
+		}
+		if !got.Time.Equal(tt.want.Time) {
+			t.Errorf("%s: time = %v, want %v", tt.name, got.Time, tt.want.Time)
+		}
+		got.Time = tt.want.Time
+		if *got != *tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSingBoxLogObserverSeesEveryEntry(t *testing.T) {
+	logs := NewSingBoxLogService(10, nil)
+	var observed []string
+	logs.Observe(func(entry *models.SingBoxLogEntry) {
+		observed = append(observed, entry.Message)
+	})
+	entries, cancel := logs.Subscribe()
+	defer cancel()
+
+	// 超过订阅通道和环形缓冲区的容量，拆成两次写入并在行中间断开
+	var lines strings.Builder
+	total := singBoxLogSubscriberBuffer * 2
+	for i := 0; i < total; i++ {
+		fmt.Fprintf(&lines, "INFO[0000] line %d\n", i)
+	}
+	data := lines.String()
+	half := len(data)/2 + 3
+	logs.Write([]byte(data[:half]))
+	logs.Write([]byte(data[half:]))
+
+	if len(observed) != total {
+		t.Fatalf("observed %d entries, want %d", len(observed), total)
+	}
+	for i, message := range observed {
+		if want := fmt.Sprintf("line %d", i); message != want {
+			t.Fatalf("entry %d = %q, want %q", i, message, want)
+		}
+	}
+	if len(entries) != singBoxLogSubscriberBuffer {
+		t.Errorf("subscriber buffered %d entries, want %d", len(entries), singBoxLogSubscriberBuffer)
+	}
+}
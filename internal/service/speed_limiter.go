@@ -0,0 +1,456 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// speedLimitInterval 根据在线设备刷新限速规则的间隔
+const speedLimitInterval = 10 * time.Second
+
+// minSpeedLimitBurst 自动计算的突发流量下限 (字节)，过小会导致限速明显低于设定值
+const minSpeedLimitBurst = 16 * 1024
+
+// speedLimitHandle 限速使用的HTB根qdisc句柄，只修改和清理该句柄下的规则
+const speedLimitHandle = "1b5a:"
+
+// 限速过滤器使用的优先级范围，入方向可能有其他程序的过滤器，只清理该范围内的过滤器
+// tc自动分配的优先级从49152开始递减，不会落入该范围
+const (
+	speedLimitPrioBase = 2000
+	speedLimitPrioEnd  = 12000
+)
+
+// speedRate 限速速率和突发流量
+type speedRate struct {
+	kbps  int64
+	burst int64
+}
+
+// speedLimitRules 根据在线设备计算出的期望限速规则
+type speedLimitRules struct {
+	download map[string]speedRate // 用户ID -> 下行速率，同一用户的所有设备共享
+	egress   map[string]string    // 地址段 -> 下行限速的用户ID
+	ingress  map[string]speedRate // 地址段 -> 上行速率，按设备限速
+	// 多个用户共用的地址段 -> 用户ID，该地址段只按第一个有限速的用户限速
+	conflicts map[string][]string
+}
+
+// tcState 已下发到网卡的限速规则，用于增量更新
+type tcState struct {
+	root         bool                 // 已创建HTB根qdisc
+	ingressQdisc bool                 // 网卡已有ingress qdisc
+	classes      map[string]int       // 用户ID -> HTB class编号
+	download     map[string]speedRate // 用户ID -> 下行速率
+	egress       map[string]string    // 地址段 -> 用户ID
+	ingress      map[string]speedRate // 地址段 -> 上行速率
+	prios        map[string]int       // 地址段 -> 过滤器优先级，出入方向共用
+}
+
+// newTCState 创建空的规则状态
+func newTCState() tcState {
+	return tcState{
+		classes:  make(map[string]int),
+		download: make(map[string]speedRate),
+		egress:   make(map[string]string),
+		ingress:  make(map[string]speedRate),
+		prios:    make(map[string]int),
+	}
+}
+
+// clone 复制规则状态，计算新状态时不修改已下发的状态
+func (s tcState) clone() tcState {
+	clone := newTCState()
+	clone.root = s.root
+	clone.ingressQdisc = s.ingressQdisc
+	for k, v := range s.classes {
+		clone.classes[k] = v
+	}
+	for k, v := range s.download {
+		clone.download[k] = v
+	}
+	for k, v := range s.egress {
+		clone.egress[k] = v
+	}
+	for k, v := range s.ingress {
+		clone.ingress[k] = v
+	}
+	for k, v := range s.prios {
+		clone.prios[k] = v
+	}
+	return clone
+}
+
+// SpeedLimiter 通过Linux tc按来源IP限制用户带宽
+// sing-box不支持按用户限速，由设备追踪识别出的用户来源IP生成限速规则：
+// 下行在网卡出方向使用专用句柄的HTB，同一用户的所有设备共享一个速率；上行在入方向按设备限速
+// 规则按变化增量更新，不会删除网卡上其他程序的qdisc和过滤器
+// 多个用户共用同一出口IP时，该IP只归入其中一个用户，并记录冲突日志
+type SpeedLimiter struct {
+	iface       string
+	tracker     *DeviceTracker
+	userService *UserService
+
+	// 已下发的规则，ready为false时需先清理上次运行或下发失败遗留的规则
+	state     tcState
+	ready     bool
+	conflicts string
+}
+
+// NewSpeedLimiter 创建带宽限制服务，iface为代理流量经过的网卡
+func NewSpeedLimiter(iface string, tracker *DeviceTracker, userService *UserService) *SpeedLimiter {
+	return &SpeedLimiter{
+		iface:       iface,
+		tracker:     tracker,
+		userService: userService,
+		state:       newTCState(),
+	}
+}
+
+// Run 定期根据在线设备更新限速规则
+func (l *SpeedLimiter) Run() {
+	ticker := time.NewTicker(speedLimitInterval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if err := l.Apply(); err != nil {
+			slog.Warn("Failed to apply speed limits", "interface", l.iface, "error", err)
+		}
+	}
+}
+
+// Apply 根据用户限速和在线设备计算规则变化并下发
+func (l *SpeedLimiter) Apply() error {
+	if !l.ready {
+		if err := l.reset(); err != nil {
+			return err
+		}
+		l.ready = true
+	}
+
+	rules := l.buildRules(l.tracker.ActiveSources())
+	l.logConflicts(rules.conflicts)
+
+	commands, next := l.diff(l.state, rules)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command("tc", "-force", "-batch", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		// 部分命令可能已生效，下次重新清理后整体下发
+		l.ready = false
+		return fmt.Errorf("tc failed: %v: %s", err, bytes.TrimSpace(output))
+	}
+
+	l.state = next
+	slog.Info("Applied speed limits", "interface", l.iface, "changes", len(commands), "users", len(next.download), "sources", len(next.prios))
+	return nil
+}
+
+// reset 清理上次运行遗留的规则：删除专用句柄的HTB根qdisc和入方向限速优先级范围内的过滤器
+func (l *SpeedLimiter) reset() error {
+	l.state = newTCState()
+
+	output, err := exec.Command("tc", "qdisc", "show", "dev", l.iface, "root").Output()
+	if err != nil {
+		return fmt.Errorf("failed to query qdisc: %v", err)
+	}
+	if kind, handle := parseQdisc(string(output)); kind == "htb" && handle == speedLimitHandle {
+		if output, err := exec.Command("tc", "qdisc", "del", "dev", l.iface, "root", "handle", speedLimitHandle).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove stale speed limits: %v: %s", err, bytes.TrimSpace(output))
+		}
+	}
+
+	output, err = exec.Command("tc", "qdisc", "show", "dev", l.iface, "ingress").Output()
+	if err != nil {
+		return fmt.Errorf("failed to query ingress qdisc: %v", err)
+	}
+	if strings.TrimSpace(string(output)) == "" {
+		return nil
+	}
+	l.state.ingressQdisc = true
+
+	output, err = exec.Command("tc", "filter", "show", "dev", l.iface, "ingress").Output()
+	if err != nil {
+		return fmt.Errorf("failed to query ingress filters: %v", err)
+	}
+	for _, prio := range parseFilterPrios(string(output)) {
+		if prio < speedLimitPrioBase || prio >= speedLimitPrioEnd {
+			continue
+		}
+		if output, err := exec.Command("tc", "filter", "del", "dev", l.iface, "ingress", "prio", strconv.Itoa(prio)).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to remove stale ingress filter: %v: %s", err, bytes.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// logConflicts 记录被多个用户共用的来源地址段，冲突未变化时不重复记录
+func (l *SpeedLimiter) logConflicts(conflicts map[string][]string) {
+	prefixes := make([]string, 0, len(conflicts))
+	for prefix := range conflicts {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var key strings.Builder
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&key, "%s=%s;", prefix, strings.Join(conflicts[prefix], ","))
+	}
+	if key.String() == l.conflicts {
+		return
+	}
+	l.conflicts = key.String()
+
+	for _, prefix := range prefixes {
+		slog.Warn("Source address is shared by several users, they share one speed limit",
+			"interface", l.iface, "source", prefix, "users", conflicts[prefix])
+	}
+}
+
+// buildRules 根据在线设备计算期望的限速规则
+func (l *SpeedLimiter) buildRules(sources map[string][]string) speedLimitRules {
+	rules := speedLimitRules{
+		download:  make(map[string]speedRate),
+		egress:    make(map[string]string),
+		ingress:   make(map[string]speedRate),
+		conflicts: make(map[string][]string),
+	}
+
+	userIDs := make([]string, 0, len(sources))
+	for userID := range sources {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	// 共用同一地址段的用户无法区分，限速会同时作用于所有用户
+	users := make(map[string][]string)
+	for _, userID := range userIDs {
+		for _, ip := range sources[userID] {
+			if prefix := sourcePrefix(ip); prefix != "" && !slices.Contains(users[prefix], userID) {
+				users[prefix] = append(users[prefix], userID)
+			}
+		}
+	}
+	for prefix, shared := range users {
+		if len(shared) > 1 {
+			rules.conflicts[prefix] = shared
+		}
+	}
+
+	assigned := make(map[string]bool)
+	for _, userID := range userIDs {
+		user, err := l.userService.GetUser(userID)
+		if err != nil || user.SpeedLimit.IsZero() {
+			continue
+		}
+		limit := user.SpeedLimit
+
+		for _, ip := range sources[userID] {
+			prefix := sourcePrefix(ip)
+			if prefix == "" || assigned[prefix] {
+				continue
+			}
+			assigned[prefix] = true
+
+			if limit.DownloadKbps > 0 {
+				rules.download[userID] = speedRate{kbps: limit.DownloadKbps, burst: speedLimitBurst(limit, limit.DownloadKbps)}
+				rules.egress[prefix] = userID
+			}
+			if limit.UploadKbps > 0 {
+				rules.ingress[prefix] = speedRate{kbps: limit.UploadKbps, burst: speedLimitBurst(limit, limit.UploadKbps)}
+			}
+		}
+	}
+	return rules
+}
+
+// diff 生成从已下发状态更新到期望规则的tc批处理命令，返回命令和更新后的状态
+// 先创建或修改class，再删除和替换过滤器，最后删除不再使用的class
+func (l *SpeedLimiter) diff(state tcState, rules speedLimitRules) ([]string, tcState) {
+	next := state.clone()
+	var commands, removals []string
+
+	userIDs := sortedKeys(rules.download)
+	if len(userIDs) > 0 && !next.root {
+		// 替换网卡默认的根qdisc，未匹配任何用户的流量不经过HTB分类，不受限速影响
+		commands = append(commands, fmt.Sprintf("qdisc replace dev %s root handle %s htb", l.iface, speedLimitHandle))
+		next.root = true
+	}
+	for _, userID := range userIDs {
+		rate := rules.download[userID]
+		classID, exists := next.classes[userID]
+		if !exists {
+			classID = freeSlot(next.classes, 1)
+			next.classes[userID] = classID
+		}
+		if exists && next.download[userID] == rate {
+			continue
+		}
+		action := "add"
+		if exists {
+			action = "change"
+		}
+		commands = append(commands, fmt.Sprintf("class %s dev %s parent %s classid %s%x htb rate %dkbit ceil %dkbit burst %db",
+			action, l.iface, speedLimitHandle, speedLimitHandle, classID, rate.kbps, rate.kbps, rate.burst))
+		next.download[userID] = rate
+	}
+	for _, userID := range sortedKeys(next.classes) {
+		if _, keep := rules.download[userID]; keep {
+			continue
+		}
+		removals = append(removals, fmt.Sprintf("class del dev %s classid %s%x", l.iface, speedLimitHandle, next.classes[userID]))
+		delete(next.classes, userID)
+		delete(next.download, userID)
+	}
+
+	if len(rules.ingress) > 0 && !next.ingressQdisc {
+		commands = append(commands, fmt.Sprintf("qdisc add dev %s handle ffff: ingress", l.iface))
+		next.ingressQdisc = true
+	}
+
+	// 删除不再需要的过滤器，地址段的两个方向都不再需要时释放优先级
+	for _, prefix := range sortedKeys(next.egress) {
+		if _, keep := rules.egress[prefix]; !keep {
+			commands = append(commands, fmt.Sprintf("filter del dev %s parent %s prio %d", l.iface, speedLimitHandle, next.prios[prefix]))
+			delete(next.egress, prefix)
+		}
+	}
+	for _, prefix := range sortedKeys(next.ingress) {
+		if _, keep := rules.ingress[prefix]; !keep {
+			commands = append(commands, fmt.Sprintf("filter del dev %s parent ffff: prio %d", l.iface, next.prios[prefix]))
+			delete(next.ingress, prefix)
+		}
+	}
+	for _, prefix := range sortedKeys(next.prios) {
+		_, egress := next.egress[prefix]
+		_, ingress := next.ingress[prefix]
+		if !egress && !ingress {
+			delete(next.prios, prefix)
+		}
+	}
+
+	// 新增或变化的过滤器使用替换命令，同一优先级和句柄的过滤器被原地更新
+	prio := func(prefix string) int {
+		if _, exists := next.prios[prefix]; !exists {
+			next.prios[prefix] = freeSlot(next.prios, speedLimitPrioBase)
+		}
+		return next.prios[prefix]
+	}
+	for _, prefix := range sortedKeys(rules.egress) {
+		userID := rules.egress[prefix]
+		if current, exists := next.egress[prefix]; exists && current == userID {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("filter replace dev %s parent %s protocol %s prio %d handle 1 flower dst_ip %s classid %s%x",
+			l.iface, speedLimitHandle, tcProtocol(prefix), prio(prefix), prefix, speedLimitHandle, next.classes[userID]))
+		next.egress[prefix] = userID
+	}
+	for _, prefix := range sortedKeys(rules.ingress) {
+		rate := rules.ingress[prefix]
+		if current, exists := next.ingress[prefix]; exists && current == rate {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("filter replace dev %s parent ffff: protocol %s prio %d handle 1 flower src_ip %s action police rate %dkbit burst %db drop",
+			l.iface, tcProtocol(prefix), prio(prefix), prefix, rate.kbps, rate.burst))
+		next.ingress[prefix] = rate
+	}
+
+	return append(commands, removals...), next
+}
+
+// speedLimitBurst 返回突发流量大小 (字节)，未设置时取100毫秒的流量
+func speedLimitBurst(limit *models.SpeedLimit, kbps int64) int64 {
+	if limit.BurstKB > 0 {
+		return limit.BurstKB * 1024
+	}
+	burst := kbps * 1000 / 8 / 10
+	if burst < minSpeedLimitBurst {
+		burst = minSpeedLimitBurst
+	}
+	return burst
+}
+
+// sourcePrefix 返回限速规则匹配的地址段，与设备ID一致，IPv6按/64前缀匹配
+func sourcePrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.String() + "/32"
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// tcProtocol 返回地址段对应的过滤器协议
+func tcProtocol(prefix string) string {
+	if strings.Contains(prefix, ":") {
+		return "ipv6"
+	}
+	return "ip"
+}
+
+// freeSlot 返回从start开始第一个未被使用的编号，释放的编号可以被复用
+func freeSlot(used map[string]int, start int) int {
+	taken := make(map[int]bool, len(used))
+	for _, slot := range used {
+		taken[slot] = true
+	}
+	for slot := start; ; slot++ {
+		if !taken[slot] {
+			return slot
+		}
+	}
+}
+
+// sortedKeys 返回排序后的键，使生成的命令顺序稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseQdisc 从tc qdisc show的输出中解析根qdisc的类型和句柄
+func parseQdisc(output string) (kind, handle string) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "qdisc" {
+		return "", ""
+	}
+	return fields[1], fields[2]
+}
+
+// parseFilterPrios 从tc filter show的输出中解析所有过滤器的优先级
+func parseFilterPrios(output string) []int {
+	seen := make(map[int]bool)
+	var prios []int
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] != "pref" {
+				continue
+			}
+			if prio, err := strconv.Atoi(fields[i+1]); err == nil && !seen[prio] {
+				seen[prio] = true
+				prios = append(prios, prio)
+			}
+		}
+	}
+	sort.Ints(prios)
+	return prios
+}
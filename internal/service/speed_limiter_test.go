@@ -0,0 +1,152 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestSpeedLimiterBuildRules(t *testing.T) {
+	s := newTestServices(t)
+	for _, user := range []*models.User{
+		{ID: "a", Username: "a", SpeedLimit: &models.SpeedLimit{DownloadKbps: 8000, UploadKbps: 800}},
+		{ID: "b", Username: "b", SpeedLimit: &models.SpeedLimit{DownloadKbps: 4000, BurstKB: 64}},
+		{ID: "c", Username: "c"},
+		{ID: "d", Username: "d"},
+	} {
+		if err := s.users.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limiter := NewSpeedLimiter("eth0", nil, s.user)
+
+	rules := limiter.buildRules(map[string][]string{
+		"a": {"10.0.0.1", "2001:db8::1"},
+		"b": {"10.0.0.1", "10.0.0.2"},
+		"c": {"10.0.0.3"},
+		"d": {"10.0.0.3"},
+	})
+
+	if want := map[string]speedRate{"a": {8000, 100000}, "b": {4000, 65536}}; !reflect.DeepEqual(rules.download, want) {
+		t.Errorf("download = %v, want %v", rules.download, want)
+	}
+	if want := map[string]string{"10.0.0.1/32": "a", "2001:db8::/64": "a", "10.0.0.2/32": "b"}; !reflect.DeepEqual(rules.egress, want) {
+		t.Errorf("egress = %v, want %v", rules.egress, want)
+	}
+	if want := map[string]speedRate{"10.0.0.1/32": {800, minSpeedLimitBurst}, "2001:db8::/64": {800, minSpeedLimitBurst}}; !reflect.DeepEqual(rules.ingress, want) {
+		t.Errorf("ingress = %v, want %v", rules.ingress, want)
+	}
+	if want := map[string][]string{"10.0.0.1/32": {"a", "b"}, "10.0.0.3/32": {"c", "d"}}; !reflect.DeepEqual(rules.conflicts, want) {
+		t.Errorf("conflicts = %v, want %v", rules.conflicts, want)
+	}
+}
+
+func TestSpeedLimiterDiff(t *testing.T) {
+	limiter := &SpeedLimiter{iface: "eth0"}
+	rate := func(kbps int64) speedRate { return speedRate{kbps: kbps, burst: 1000} }
+
+	steps := []struct {
+		name  string
+		rules speedLimitRules
+		want  []string
+	}{
+		{
+			name: "first user",
+			rules: speedLimitRules{
+				download: map[string]speedRate{"a": rate(100)},
+				egress:   map[string]string{"10.0.0.1/32": "a"},
+				ingress:  map[string]speedRate{"10.0.0.1/32": rate(50)},
+			},
+			want: []string{
+				"qdisc replace dev eth0 root handle 1b5a: htb",
+				"class add dev eth0 parent 1b5a: classid 1b5a:1 htb rate 100kbit ceil 100kbit burst 1000b",
+				"qdisc add dev eth0 handle ffff: ingress",
+				"filter replace dev eth0 parent 1b5a: protocol ip prio 2000 handle 1 flower dst_ip 10.0.0.1/32 classid 1b5a:1",
+				"filter replace dev eth0 parent ffff: protocol ip prio 2000 handle 1 flower src_ip 10.0.0.1/32 action police rate 50kbit burst 1000b drop",
+			},
+		},
+		{
+			name: "unchanged",
+			rules: speedLimitRules{
+				download: map[string]speedRate{"a": rate(100)},
+				egress:   map[string]string{"10.0.0.1/32": "a"},
+				ingress:  map[string]speedRate{"10.0.0.1/32": rate(50)},
+			},
+		},
+		{
+			name: "rate change and new device",
+			rules: speedLimitRules{
+				download: map[string]speedRate{"a": rate(200)},
+				egress:   map[string]string{"10.0.0.1/32": "a", "2001:db8::/64": "a"},
+				ingress:  map[string]speedRate{"10.0.0.1/32": rate(50)},
+			},
+			want: []string{
+				"class change dev eth0 parent 1b5a: classid 1b5a:1 htb rate 200kbit ceil 200kbit burst 1000b",
+				"filter replace dev eth0 parent 1b5a: protocol ipv6 prio 2001 handle 1 flower dst_ip 2001:db8::/64 classid 1b5a:1",
+			},
+		},
+		{
+			name: "source moves to another user",
+			rules: speedLimitRules{
+				download: map[string]speedRate{"b": rate(300)},
+				egress:   map[string]string{"10.0.0.1/32": "b"},
+			},
+			want: []string{
+				"class add dev eth0 parent 1b5a: classid 1b5a:2 htb rate 300kbit ceil 300kbit burst 1000b",
+				"filter del dev eth0 parent 1b5a: prio 2001",
+				"filter del dev eth0 parent ffff: prio 2000",
+				"filter replace dev eth0 parent 1b5a: protocol ip prio 2000 handle 1 flower dst_ip 10.0.0.1/32 classid 1b5a:2",
+				"class del dev eth0 classid 1b5a:1",
+			},
+		},
+		{
+			name:  "all devices offline",
+			rules: speedLimitRules{},
+			want: []string{
+				"filter del dev eth0 parent 1b5a: prio 2000",
+				"class del dev eth0 classid 1b5a:2",
+			},
+		},
+	}
+
+	state := newTCState()
+	for _, step := range steps {
+		commands, next := limiter.diff(state, step.rules)
+		if strings.Join(commands, "\n") != strings.Join(step.want, "\n") {
+			t.Fatalf("%s:\ngot:\n%s\nwant:\n%s", step.name, strings.Join(commands, "\n"), strings.Join(step.want, "\n"))
+		}
+		state = next
+	}
+	if len(state.prios) != 0 || len(state.classes) != 0 || !state.root || !state.ingressQdisc {
+		t.Fatalf("final state = %+v", state)
+	}
+}
+
+func TestParseTCOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		kind   string
+		handle string
+	}{
+		{"qdisc htb 1b5a: root refcnt 2 r2q 10 default 0 direct_packets_stat 0\n", "htb", "1b5a:"},
+		{"qdisc fq_codel 0: root refcnt 2 limit 10240p flows 1024\n", "fq_codel", "0:"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if kind, handle := parseQdisc(tt.output); kind != tt.kind || handle != tt.handle {
+			t.Errorf("parseQdisc(%q) = %s %s", tt.output, kind, handle)
+		}
+	}
+
+	filters := `filter parent ffff: protocol ip pref 49152 u32 chain 0
+filter parent ffff: protocol ip pref 2000 flower chain 0
+filter parent ffff: protocol ip pref 2000 flower chain 0 handle 0x1
+  dst_ip 10.0.0.1
+filter parent ffff: protocol ipv6 pref 2003 flower chain 0 handle 0x1
+`
+	if got, want := parseFilterPrios(filters), []int{2000, 2003, 49152}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseFilterPrios = %v, want %v", got, want)
+	}
+}
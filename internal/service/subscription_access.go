@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// subscriptionTokenPrefixLen 拉取记录中保留的令牌前缀长度
+const subscriptionTokenPrefixLen = 8
+
+// clientHintTTL 订阅拉取记录用于识别设备客户端的有效期
+const clientHintTTL = 24 * time.Hour
+
+// subscriptionLogPruneInterval 清理过期拉取记录的间隔
+const subscriptionLogPruneInterval = time.Hour
+
+// minSubscriptionLogRetention 拉取记录的最短保留时长，重启后需要从中恢复当天的IP统计
+const minSubscriptionLogRetention = 48 * time.Hour
+
+// clientHint 某台设备最近一次拉取订阅时的User-Agent
+type clientHint struct {
+	userAgent string
+	seenAt    time.Time
+}
+
+// dailyIPSet 单个用户当天拉取订阅的不同IP
+type dailyIPSet struct {
+	day string
+	ips map[string]bool
+}
+
+// RecordAccess 记录一次订阅拉取，并在当天不同IP数超过限制时标记用户
+// 记录失败只写日志，不影响订阅下发
+func (s *SubscriptionService) RecordAccess(ctx context.Context, user *models.User, ip, userAgent, format string) {
+	access := &models.SubscriptionAccess{
+		Timestamp:   time.Now(),
+		UserID:      user.ID,
+		TokenPrefix: tokenPrefix(user.SubscriptionToken),
+		IP:          ip,
+		UserAgent:   userAgent,
+		Format:      format,
+	}
+	if err := s.accessLog.Append(access); err != nil {
+		slog.ErrorContext(ctx, "Failed to write subscription access", "user_id", user.ID, "error", err)
+	}
+
+	// 客户端拉取订阅与连接代理通常来自同一IP，据此记录设备使用的客户端
+	deviceID := DeviceIDFromIP(ip)
+	s.rememberClient(user.ID, deviceID, userAgent, access.Timestamp)
+	if err := s.userService.NoteDeviceClient(user.ID, deviceID, userAgent); err != nil {
+		slog.WarnContext(ctx, "Failed to record device client", "user_id", user.ID, "error", err)
+	}
+
+	if s.maxIPsPerDay <= 0 || user.SubscriptionFlagged {
+		return
+	}
+
+	if s.trackIP(user.ID, ip, access.Timestamp) > s.maxIPsPerDay {
+		if err := s.userService.FlagSubscription(ctx, user.ID, user.SubscriptionToken); err != nil {
+			slog.ErrorContext(ctx, "Failed to flag subscription", "user_id", user.ID, "error", err)
+		}
+	}
+}
+
+// AccessLog 查询订阅拉取记录，按时间倒序返回最多query.Limit条
+func (s *SubscriptionService) AccessLog(query *models.SubscriptionAccessQuery) ([]*models.SubscriptionAccess, error) {
+	records := make([]*models.SubscriptionAccess, 0)
+	err := s.accessLog.Scan(func(access *models.SubscriptionAccess) bool {
+		if query.Matches(access) {
+			records = append(records, access)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件按时间顺序追加，反转后最新的在前
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	if query.Limit > 0 && len(records) > query.Limit {
+		records = records[:query.Limit]
+	}
+
+	return records, nil
+}
+
+// RunLogRetention 定期删除超过保留时长的拉取记录，保留时长不足两天时按两天计算
+func (s *SubscriptionService) RunLogRetention(retention time.Duration) {
+	retention = max(retention, minSubscriptionLogRetention)
+
+	ticker := time.NewTicker(subscriptionLogPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := s.accessLog.Prune(time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("Failed to prune subscription access log", "error", err)
+		}
+		if removed > 0 {
+			slog.Info("Pruned subscription access log", "removed", removed)
+		}
+		<-ticker.C
+	}
+}
+
+// ForgetAccess 清除用户当天的IP统计，令牌轮换后重新计数
+func (s *SubscriptionService) ForgetAccess(userID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.dailyIPs, userID)
+}
+
+// ClientHint 返回用户最近从该设备拉取订阅时的User-Agent，实现DeviceClientHints
+func (s *SubscriptionService) ClientHint(userID, deviceID string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hint, exists := s.clientHints[userID+"/"+deviceID]
+	if !exists || time.Since(hint.seenAt) > clientHintTTL {
+		return ""
+	}
+	return hint.userAgent
+}
+
+// rememberClient 记录设备拉取订阅的客户端，同时清理过期的记录
+func (s *SubscriptionService) rememberClient(userID, deviceID, userAgent string, at time.Time) {
+	if userAgent == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, hint := range s.clientHints {
+		if at.Sub(hint.seenAt) > clientHintTTL {
+			delete(s.clientHints, key)
+		}
+	}
+	s.clientHints[userID+"/"+deviceID] = clientHint{userAgent: userAgent, seenAt: at}
+}
+
+// trackIP 记录用户当天的拉取IP，返回当天不同IP数
+func (s *SubscriptionService) trackIP(userID, ip string, at time.Time) int {
+	day := at.Format("2006-01-02")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	set, exists := s.dailyIPs[userID]
+	if !exists || set.day != day {
+		set = &dailyIPSet{day: day, ips: make(map[string]bool)}
+		s.dailyIPs[userID] = set
+	}
+	set.ips[ip] = true
+
+	return len(set.ips)
+}
+
+// loadDailyIPs 从拉取日志恢复当天的IP统计，避免重启后计数归零
+// 只统计当前令牌的记录，轮换前的拉取不计入
+func (s *SubscriptionService) loadDailyIPs() {
+	if s.maxIPsPerDay <= 0 {
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	query := &models.SubscriptionAccessQuery{From: today}
+
+	err := s.accessLog.Scan(func(access *models.SubscriptionAccess) bool {
+		if !query.Matches(access) {
+			return true
+		}
+		user, err := s.userService.GetUser(access.UserID)
+		if err != nil || tokenPrefix(user.SubscriptionToken) != access.TokenPrefix {
+			return true
+		}
+		s.trackIP(access.UserID, access.IP, access.Timestamp)
+		return true
+	})
+	if err != nil {
+		slog.Error("Failed to load subscription access log", "error", err)
+	}
+}
+
+// tokenPrefix 返回令牌前缀
+func tokenPrefix(token string) string {
+	if len(token) > subscriptionTokenPrefixLen {
+		return token[:subscriptionTokenPrefixLen]
+	}
+	return token
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+
+	"sing-box-manager/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Clash代理组名称
+const (
+	clashGroupSelect  = "Proxy"
+	clashGroupURLTest = "Auto"
+)
+
+// urlTestURL 自动测速使用的地址
+const urlTestURL = "https://www.gstatic.com/generate_204"
+
+// clashConfig Clash Meta / Mihomo 配置
+type clashConfig struct {
+	MixedPort   int               `yaml:"mixed-port"`
+	AllowLan    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	LogLevel    string            `yaml:"log-level"`
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+// clashProxy Clash代理节点
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	Password          string            `yaml:"password,omitempty"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	Network           string            `yaml:"network"`
+	UDP               bool              `yaml:"udp"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts,omitempty"`
+}
+
+// clashRealityOpts Reality参数
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id"`
+}
+
+// clashProxyGroup Clash代理组
+type clashProxyGroup struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Proxies  []string `yaml:"proxies"`
+	URL      string   `yaml:"url,omitempty"`
+	Interval int      `yaml:"interval,omitempty"`
+}
+
+// clashRules 默认分流规则：局域网和国内直连，其余走代理
+var clashRules = []string{
+	"DOMAIN-SUFFIX,local,DIRECT",
+	"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+	"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+	"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+	"GEOIP,LAN,DIRECT,no-resolve",
+	"DOMAIN-SUFFIX,cn,DIRECT",
+	"GEOIP,CN,DIRECT",
+	"MATCH," + clashGroupSelect,
+}
+
+// RenderClash 生成Clash Meta / Mihomo格式的订阅
+func (s *SubscriptionService) RenderClash(user *models.User) ([]byte, error) {
+	endpoints, err := s.Endpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := make([]clashProxy, 0, len(endpoints))
+	names := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		proxy := clashProxyFor(user, endpoint)
+		proxies = append(proxies, proxy)
+		names = append(names, proxy.Name)
+	}
+
+	config := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "info",
+		Proxies:   proxies,
+		ProxyGroups: []clashProxyGroup{
+			{
+				Name:    clashGroupSelect,
+				Type:    "select",
+				Proxies: append([]string{clashGroupURLTest}, names...),
+			},
+			{
+				Name:     clashGroupURLTest,
+				Type:     "url-test",
+				Proxies:  names,
+				URL:      urlTestURL,
+				Interval: 300,
+			},
+		},
+		Rules: clashRules,
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&config); err != nil {
+		return nil, fmt.Errorf("failed to render clash subscription: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// clashProxyFor 将端点转换为Clash代理节点
+func clashProxyFor(user *models.User, endpoint ShareEndpoint) clashProxy {
+	proxy := clashProxy{
+		Name:    endpointName(endpoint),
+		Type:    endpoint.Protocol,
+		Server:  endpoint.Server,
+		Port:    endpoint.Port,
+		Network: "tcp",
+		UDP:     true,
+		TLS:     endpoint.Security != "",
+	}
+
+	switch endpoint.Protocol {
+	case "trojan":
+		proxy.Password = user.Password
+		proxy.SNI = endpoint.SNI
+	case "vless":
+		proxy.UUID = user.ID
+		proxy.ServerName = endpoint.SNI
+	}
+
+	if endpoint.Security == "reality" {
+		proxy.ClientFingerprint = endpoint.Fingerprint
+		proxy.RealityOpts = &clashRealityOpts{
+			PublicKey: endpoint.PublicKey,
+			ShortID:   endpoint.ShortID,
+		}
+	}
+
+	return proxy
+}
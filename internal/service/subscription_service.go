@@ -0,0 +1,317 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+)
+
+// realityFingerprint Reality客户端使用的uTLS指纹
+const realityFingerprint = "chrome"
+
+// 订阅格式
+const (
+	SubscriptionFormatBase64  = "base64"
+	SubscriptionFormatClash   = "clash"
+	SubscriptionFormatSingBox = "singbox"
+)
+
+var (
+	// ErrSubscriptionNotFound 订阅令牌不存在
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrSubscriptionDisabled 用户已被禁用
+	ErrSubscriptionDisabled = errors.New("subscription is disabled")
+	// ErrUnsupportedFormat 不支持的订阅格式
+	ErrUnsupportedFormat = errors.New("unsupported subscription format")
+	// ErrInboundNotFound 指定的入站不存在或不支持分享
+	ErrInboundNotFound = errors.New("inbound not found")
+)
+
+// clashUserAgents 自动识别为Clash系客户端的User-Agent关键字
+var clashUserAgents = []string{"clash", "mihomo", "stash"}
+
+// singBoxUserAgents 自动识别为sing-box客户端的User-Agent关键字
+var singBoxUserAgents = []string{"sing-box", "sfa", "sfi", "sfm"}
+
+// DetectSubscriptionFormat 根据显式指定的格式或客户端User-Agent确定订阅格式
+func DetectSubscriptionFormat(format, userAgent string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	for _, keyword := range clashUserAgents {
+		if strings.Contains(userAgent, keyword) {
+			return SubscriptionFormatClash
+		}
+	}
+	for _, keyword := range singBoxUserAgents {
+		if strings.HasPrefix(userAgent, keyword) {
+			return SubscriptionFormatSingBox
+		}
+	}
+
+	return SubscriptionFormatBase64
+}
+
+// ShareEndpoint 客户端可连接的入站端点，由服务端入站定义推导而来
+type ShareEndpoint struct {
+	Tag         string
+	Protocol    string // trojan / vless
+	Server      string
+	Port        int
+	Security    string // tls / reality
+	SNI         string
+	PublicKey   string
+	ShortID     string
+	Fingerprint string
+}
+
+// SubscriptionService 用户订阅服务
+type SubscriptionService struct {
+	userService   *UserService
+	configService *ConfigService
+	nodeService   *NodeService
+	includeLocal  bool
+	accessLog     *storage.SubscriptionLogStorage
+	maxIPsPerDay  int
+
+	mutex    sync.Mutex
+	dailyIPs map[string]*dailyIPSet
+	// 最近拉取订阅的客户端，键为用户ID和设备ID
+	clientHints map[string]clientHint
+}
+
+// NewSubscriptionService 创建订阅服务
+// includeLocal表示订阅中是否包含本机的入站，maxIPsPerDay为单个订阅每天允许的不同拉取IP数，超过后标记用户，0表示不限制
+func NewSubscriptionService(userService *UserService, configService *ConfigService, nodeService *NodeService, includeLocal bool, accessLog *storage.SubscriptionLogStorage, maxIPsPerDay int) *SubscriptionService {
+	s := &SubscriptionService{
+		userService:   userService,
+		configService: configService,
+		nodeService:   nodeService,
+		includeLocal:  includeLocal,
+		accessLog:     accessLog,
+		maxIPsPerDay:  maxIPsPerDay,
+		dailyIPs:      make(map[string]*dailyIPSet),
+		clientHints:   make(map[string]clientHint),
+	}
+	s.loadDailyIPs()
+	return s
+}
+
+// GetSubscriber 根据订阅令牌获取用户
+func (s *SubscriptionService) GetSubscriber(token string) (*models.User, error) {
+	user, err := s.userService.GetUserBySubscriptionToken(token)
+	if err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	if !user.IsActive {
+		return nil, ErrSubscriptionDisabled
+	}
+
+	return user, nil
+}
+
+// Endpoints 返回本机及所有在线节点中用户有权使用的、需要用户认证的入站对应的客户端端点
+func (s *SubscriptionService) Endpoints(user *models.User) ([]ShareEndpoint, error) {
+	servers := make([]string, 0)
+	if s.includeLocal && user.CanAccessNode(s.configService.NodeGroups()) {
+		servers = append(servers, s.configService.ServerName())
+	}
+
+	nodes, err := s.nodeService.ListOnlineNodes()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, node := range nodes {
+		if user.CanAccessNode(node.Groups) {
+			servers = append(servers, node.Address)
+		}
+	}
+
+	endpoints := make([]ShareEndpoint, 0)
+	for _, server := range servers {
+		serverEndpoints, err := s.serverEndpoints(server)
+		if err != nil {
+			return nil, err
+		}
+		for _, endpoint := range serverEndpoints {
+			if user.CanUseInbound(endpoint.Tag) {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+
+	return endpoints, nil
+}
+
+// serverEndpoints 返回指定服务器上的客户端端点，各节点使用相同的入站定义
+func (s *SubscriptionService) serverEndpoints(server string) ([]ShareEndpoint, error) {
+	endpoints := make([]ShareEndpoint, 0)
+	for _, inbound := range s.configService.InboundsFor(server) {
+		if inbound.Type != "trojan" && inbound.Type != "vless" {
+			continue
+		}
+
+		endpoint := ShareEndpoint{
+			Tag:      inbound.Tag,
+			Protocol: inbound.Type,
+			Server:   server,
+			Port:     inbound.ListenPort,
+		}
+
+		if inbound.TLS != nil && inbound.TLS.Enabled {
+			endpoint.Security = "tls"
+			endpoint.SNI = inbound.TLS.ServerName
+
+			if reality := inbound.TLS.Reality; reality != nil && reality.Enabled {
+				publicKey, err := s.configService.RealityPublicKey()
+				if err != nil {
+					return nil, err
+				}
+				endpoint.Security = "reality"
+				endpoint.PublicKey = publicKey
+				endpoint.Fingerprint = realityFingerprint
+				if len(reality.ShortID) > 0 {
+					endpoint.ShortID = reality.ShortID[0]
+				}
+			}
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, nil
+}
+
+// ShareLinks 生成用户在每个端点上的分享链接
+func (s *SubscriptionService) ShareLinks(user *models.User) ([]string, error) {
+	endpoints, err := s.Endpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		links = append(links, shareLink(user, endpoint))
+	}
+
+	return links, nil
+}
+
+// Render 按指定格式生成订阅内容，返回内容及其Content-Type
+func (s *SubscriptionService) Render(user *models.User, format string) ([]byte, string, error) {
+	switch format {
+	case SubscriptionFormatBase64:
+		content, err := s.RenderBase64(user)
+		return content, "text/plain; charset=utf-8", err
+	case SubscriptionFormatClash:
+		content, err := s.RenderClash(user)
+		return content, "text/yaml; charset=utf-8", err
+	case SubscriptionFormatSingBox:
+		content, err := s.RenderSingBox(user)
+		return content, "application/json; charset=utf-8", err
+	default:
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// UserInfo 生成subscription-userinfo响应头，客户端据此显示剩余流量和到期时间
+// 用户只记录总用量，上传量固定为0，总用量计入下载
+func (s *SubscriptionService) UserInfo(user *models.User) string {
+	var expire int64
+	if !user.ExpiresAt.IsZero() {
+		expire = user.ExpiresAt.Unix()
+	}
+
+	return fmt.Sprintf("upload=0; download=%d; total=%d; expire=%d", user.TrafficUsed, user.TrafficLimit, expire)
+}
+
+// FileExtension 返回订阅格式对应的文件扩展名
+func FileExtension(format string) string {
+	switch format {
+	case SubscriptionFormatClash:
+		return ".yaml"
+	case SubscriptionFormatSingBox:
+		return ".json"
+	default:
+		return ".txt"
+	}
+}
+
+// ShareLink 生成用户在指定入站上的分享链接，inboundTag为空时使用第一个可用入站
+func (s *SubscriptionService) ShareLink(user *models.User, inboundTag string) (string, error) {
+	endpoints, err := s.Endpoints(user)
+	if err != nil {
+		return "", err
+	}
+
+	for _, endpoint := range endpoints {
+		if inboundTag == "" || endpoint.Tag == inboundTag {
+			return shareLink(user, endpoint), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrInboundNotFound, inboundTag)
+}
+
+// RenderBase64 生成通用订阅格式：分享链接按行拼接后进行base64编码
+func (s *SubscriptionService) RenderBase64(user *models.User) ([]byte, error) {
+	links, err := s.ShareLinks(user)
+	if err != nil {
+		return nil, err
+	}
+
+	content := strings.Join(links, "\n")
+	return []byte(base64.StdEncoding.EncodeToString([]byte(content))), nil
+}
+
+// shareLink 生成单个端点的分享链接
+func shareLink(user *models.User, endpoint ShareEndpoint) string {
+	query := url.Values{}
+	query.Set("type", "tcp")
+
+	if endpoint.Security != "" {
+		query.Set("security", endpoint.Security)
+		query.Set("sni", endpoint.SNI)
+	}
+
+	if endpoint.Security == "reality" {
+		query.Set("pbk", endpoint.PublicKey)
+		query.Set("sid", endpoint.ShortID)
+		query.Set("fp", endpoint.Fingerprint)
+	}
+
+	credential := user.Password
+	if endpoint.Protocol == "vless" {
+		credential = user.ID
+		query.Set("encryption", "none")
+	}
+
+	link := url.URL{
+		Scheme:   endpoint.Protocol,
+		User:     url.User(credential),
+		Host:     net.JoinHostPort(endpoint.Server, strconv.Itoa(endpoint.Port)),
+		RawQuery: query.Encode(),
+		Fragment: endpointName(endpoint),
+	}
+	return link.String()
+}
+
+// endpointName 客户端中显示的节点名称
+func endpointName(endpoint ShareEndpoint) string {
+	return fmt.Sprintf("%s-%s", endpoint.Server, endpoint.Tag)
+}
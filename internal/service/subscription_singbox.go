@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"sing-box-manager/internal/models"
+)
+
+// sing-box客户端出站分组标签
+const (
+	singBoxSelectorTag = "proxy"
+	singBoxURLTestTag  = "auto"
+)
+
+// RenderSingBox 生成sing-box客户端(SFA/SFI/SFM)完整配置
+func (s *SubscriptionService) RenderSingBox(user *models.User) ([]byte, error) {
+	endpoints, err := s.Endpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := make([]Outbound, 0, len(endpoints))
+	tags := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		outbound := singBoxOutboundFor(user, endpoint)
+		proxies = append(proxies, outbound)
+		tags = append(tags, outbound.Tag)
+	}
+
+	config := &SingBoxConfig{}
+	config.Log = LogConfig{Level: "info", Timestamp: true}
+
+	config.DNS = DNSConfig{
+		Servers: []DNSServer{
+			{Tag: "remote", Address: "https://1.1.1.1/dns-query", Detour: singBoxSelectorTag},
+			{Tag: "local", Address: "local", Detour: "direct"},
+		},
+		Rules: []DNSRule{
+			// 解析代理服务器地址本身必须走本地DNS
+			{Outbound: []string{"any"}, Server: "local"},
+			{DomainSuffix: []string{".cn"}, Server: "local"},
+		},
+		Final: "remote",
+	}
+
+	config.Inbounds = []Inbound{
+		{
+			Type:       "mixed",
+			Tag:        "mixed-in",
+			Listen:     "127.0.0.1",
+			ListenPort: 2080,
+			Sniff:      true,
+		},
+	}
+
+	config.Outbounds = append([]Outbound{
+		{
+			Type:      "selector",
+			Tag:       singBoxSelectorTag,
+			Outbounds: append([]string{singBoxURLTestTag}, tags...),
+			Default:   singBoxURLTestTag,
+		},
+		{
+			Type:      "urltest",
+			Tag:       singBoxURLTestTag,
+			Outbounds: tags,
+			URL:       urlTestURL,
+			Interval:  "5m",
+		},
+	}, proxies...)
+	config.Outbounds = append(config.Outbounds,
+		Outbound{Type: "direct", Tag: "direct"},
+		Outbound{Type: "block", Tag: "block"},
+		Outbound{Type: "dns", Tag: "dns-out"},
+	)
+
+	config.Route = RouteConfig{
+		Rules: []RouteRule{
+			{Protocol: "dns", Outbound: "dns-out"},
+			{IPIsPrivate: true, Outbound: "direct"},
+			{DomainSuffix: []string{".cn"}, Outbound: "direct"},
+		},
+		Final:               singBoxSelectorTag,
+		AutoDetectInterface: true,
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render sing-box subscription: %v", err)
+	}
+	return data, nil
+}
+
+// singBoxOutboundFor 将端点转换为sing-box客户端出站
+func singBoxOutboundFor(user *models.User, endpoint ShareEndpoint) Outbound {
+	outbound := Outbound{
+		Type:       endpoint.Protocol,
+		Tag:        endpointName(endpoint),
+		Server:     endpoint.Server,
+		ServerPort: endpoint.Port,
+	}
+
+	switch endpoint.Protocol {
+	case "trojan":
+		outbound.Password = user.Password
+	case "vless":
+		outbound.UUID = user.ID
+	}
+
+	if endpoint.Security != "" {
+		outbound.TLS = &OutboundTLSConfig{
+			Enabled:    true,
+			ServerName: endpoint.SNI,
+		}
+	}
+
+	if endpoint.Security == "reality" {
+		outbound.TLS.UTLS = &UTLSConfig{
+			Enabled:     true,
+			Fingerprint: endpoint.Fingerprint,
+		}
+		outbound.TLS.Reality = &OutboundRealityConfig{
+			Enabled:   true,
+			PublicKey: endpoint.PublicKey,
+			ShortID:   endpoint.ShortID,
+		}
+	}
+
+	return outbound
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cpuSampler 根据/proc/stat两次采样之间的差值计算CPU使用率
+type cpuSampler struct {
+	mutex     sync.Mutex
+	lastTotal uint64
+	lastIdle  uint64
+}
+
+// Sample 返回自上次采样以来的CPU使用率(百分比)，首次采样返回自开机以来的平均值
+func (c *cpuSampler) Sample() float64 {
+	total, idle, err := readCPUTimes()
+	if err != nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	deltaTotal := total - c.lastTotal
+	deltaIdle := idle - c.lastIdle
+	c.lastTotal, c.lastIdle = total, idle
+
+	if deltaTotal == 0 {
+		return 0
+	}
+	return float64(deltaTotal-deltaIdle) / float64(deltaTotal) * 100
+}
+
+// readCPUTimes 读取/proc/stat中所有CPU的累计时间和空闲时间(含iowait)
+func readCPUTimes() (uint64, uint64, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, os.ErrInvalid
+	}
+
+	var total, idle uint64
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += value
+		// 第4、5列分别为idle和iowait
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return total, idle, nil
+}
+
+// readMemory 从/proc/meminfo读取已用和总内存(字节)
+func readMemory() (int64, int64) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	var total, available int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value * 1024
+		case "MemAvailable:":
+			available = value * 1024
+		}
+	}
+	return total - available, total
+}
+
+// readUptime 从/proc/uptime读取系统运行时间(秒)
+func readUptime() int64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(uptime)
+}
+
+// singBoxVersion 返回本机sing-box的版本号，未安装时返回空字符串
+func singBoxVersion() string {
+	output, err := exec.Command("sing-box", "version").Output()
+	if err != nil {
+		return ""
+	}
+
+	// 首行格式为 "sing-box version 1.8.0"
+	line, _, _ := strings.Cut(string(output), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// trialInviteIssuer 试用邀请的签发者，与访问令牌区分，两者不能互相冒用
+const trialInviteIssuer = "sing-box-manager/trial"
+
+// defaultTrialInviteTTL 试用邀请的默认有效期
+const defaultTrialInviteTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrTrialNotConfigured 未指定试用套餐且未配置默认试用套餐
+	ErrTrialNotConfigured = errors.New("trial plan is not configured")
+	// ErrTrialInviteInvalid 试用邀请无效或已过期
+	ErrTrialInviteInvalid = errors.New("invalid or expired trial invite")
+	// ErrTrialEmailUsed 该邮箱已开通过试用
+	ErrTrialEmailUsed = errors.New("a trial has already been issued for this email")
+	// ErrTrialRateLimited 同一IP开通试用过于频繁
+	ErrTrialRateLimited = errors.New("too many trials from this address, try again later")
+	// ErrTrialInviteUsed 试用邀请的开通次数已用完
+	ErrTrialInviteUsed = errors.New("trial invite has been used up")
+)
+
+// trialInviteClaims 试用邀请声明，Subject为签发邀请的管理员
+type trialInviteClaims struct {
+	PlanID  string `json:"plan"`
+	OwnerID string `json:"owner,omitempty"`
+	MaxUses int    `json:"max_uses"`
+	jwt.RegisteredClaims
+}
+
+// TrialService 试用开通服务，按试用套餐创建短期用户，并按邮箱和IP限制开通次数
+// 试用用户按套餐续期 (管理员续期或兑换套餐兑换码) 时转为正式用户，用户ID和密码不变，客户端配置无需更新
+type TrialService struct {
+	storage      *storage.TrialStorage
+	planService  *PlanService
+	userService  *UserService
+	adminService *AdminService
+	audit        *AuditService
+	secret       []byte
+
+	// 未指定套餐时使用的试用套餐，以及同一IP在ipWindow内最多开通的次数，ipLimit为0表示不限制
+	defaultPlanID string
+	ipLimit       int
+	ipWindow      time.Duration
+}
+
+// NewTrialService 创建试用服务，secret用于签名试用邀请
+func NewTrialService(storage *storage.TrialStorage, planService *PlanService, userService *UserService, adminService *AdminService, audit *AuditService, secret []byte, defaultPlanID string, ipLimit int, ipWindow time.Duration) *TrialService {
+	return &TrialService{
+		storage:       storage,
+		planService:   planService,
+		userService:   userService,
+		adminService:  adminService,
+		audit:         audit,
+		secret:        secret,
+		defaultPlanID: defaultPlanID,
+		ipLimit:       ipLimit,
+		ipWindow:      ipWindow,
+	}
+}
+
+// CreateInvite 签发试用邀请，分销商签发的邀请开通的用户归其所有
+func (s *TrialService) CreateInvite(ctx context.Context, req *models.CreateTrialInviteRequest, admin *models.Admin) (*models.TrialInvite, error) {
+	plan, err := s.resolvePlan(req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := defaultTrialInviteTTL
+	if req.ValidHours > 0 {
+		ttl = time.Duration(req.ValidHours) * time.Hour
+	}
+
+	maxUses := 1
+	if req.MaxUses > 0 {
+		maxUses = req.MaxUses
+	}
+
+	now := time.Now()
+	invite := &models.TrialInvite{
+		ID:        uuid.New().String(),
+		PlanID:    plan.ID,
+		MaxUses:   maxUses,
+		ExpiresAt: now.Add(ttl),
+	}
+	if admin.Role == models.RoleReseller {
+		invite.OwnerID = admin.ID
+	}
+
+	claims := trialInviteClaims{
+		PlanID:  invite.PlanID,
+		OwnerID: invite.OwnerID,
+		MaxUses: invite.MaxUses,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    trialInviteIssuer,
+			Subject:   admin.ID,
+			ID:        invite.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(invite.ExpiresAt),
+		},
+	}
+	if invite.Token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret); err != nil {
+		return nil, fmt.Errorf("failed to sign trial invite: %v", err)
+	}
+
+	s.audit.Record(ctx, AuditTrialInvite, "trial_invite", invite.ID, nil, map[string]interface{}{
+		"plan_id":    invite.PlanID,
+		"owner_id":   invite.OwnerID,
+		"max_uses":   invite.MaxUses,
+		"expires_at": invite.ExpiresAt,
+	})
+	return invite, nil
+}
+
+// CreateTrial 管理员为邮箱直接开通试用，不受IP频率限制
+func (s *TrialService) CreateTrial(ctx context.Context, req *models.CreateTrialRequest, admin *models.Admin) (*models.User, error) {
+	plan, err := s.resolvePlan(req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.TrialRecord{
+		Email:  models.NormalizeEmail(req.Email),
+		PlanID: plan.ID,
+	}
+	if admin.Role == models.RoleReseller {
+		record.OwnerID = admin.ID
+	}
+	return s.provision(ctx, record, 0)
+}
+
+// ClaimTrial 通过试用邀请自助开通试用，同一邮箱只能开通一次，同一IP受频率限制，每个邀请最多开通MaxUses次
+func (s *TrialService) ClaimTrial(ctx context.Context, req *models.ClaimTrialRequest, ip string) (*models.User, error) {
+	claims := &trialInviteClaims{}
+	_, err := jwt.ParseWithClaims(req.Invite, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(trialInviteIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, ErrTrialInviteInvalid
+	}
+
+	// 签发邀请的分销商被停用或删除后，邀请随之失效
+	if claims.OwnerID != "" {
+		owner, err := s.adminService.GetAdmin(claims.OwnerID)
+		if err != nil || !owner.IsActive {
+			return nil, ErrTrialInviteInvalid
+		}
+	}
+
+	plan, err := s.planService.GetPlan(claims.PlanID)
+	if err != nil {
+		return nil, ErrTrialInviteInvalid
+	}
+
+	email := models.NormalizeEmail(req.Email)
+	ctx = WithActor(ctx, Actor{
+		Type: models.ActorTypeUser,
+		Name: email,
+		IP:   ip,
+	})
+	return s.provision(ctx, &models.TrialRecord{
+		Email:    email,
+		PlanID:   plan.ID,
+		OwnerID:  claims.OwnerID,
+		IP:       ip,
+		InviteID: claims.ID,
+	}, claims.MaxUses)
+}
+
+// ListTrials 列出试用记录，ownerID不为空时只列出该分销商的记录
+func (s *TrialService) ListTrials(ownerID string) ([]*models.TrialRecord, error) {
+	trials, err := s.storage.ListTrials()
+	if err != nil {
+		return nil, err
+	}
+	if ownerID == "" {
+		return trials, nil
+	}
+
+	owned := make([]*models.TrialRecord, 0)
+	for _, trial := range trials {
+		if trial.OwnerID == ownerID {
+			owned = append(owned, trial)
+		}
+	}
+	return owned, nil
+}
+
+// resolvePlan 获取指定的试用套餐，未指定时使用默认试用套餐
+func (s *TrialService) resolvePlan(planID string) (*models.Plan, error) {
+	if planID == "" {
+		planID = s.defaultPlanID
+	}
+	if planID == "" {
+		return nil, ErrTrialNotConfigured
+	}
+	return s.planService.GetPlan(planID)
+}
+
+// provision 占用试用名额后按套餐创建试用用户，创建失败时释放名额
+// inviteUses为邀请的最多开通次数，大于0时同时按IP限制频率，管理员直接开通时为0
+func (s *TrialService) provision(ctx context.Context, record *models.TrialRecord, inviteUses int) (*models.User, error) {
+	var reseller *models.Admin
+	if record.OwnerID != "" {
+		var err error
+		if reseller, err = s.adminService.GetAdmin(record.OwnerID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	record.CreatedAt = now
+	err := s.storage.Reserve(record, func(trials []*models.TrialRecord) error {
+		recent, used := 0, 0
+		for _, trial := range trials {
+			if trial.Email == record.Email {
+				return ErrTrialEmailUsed
+			}
+			if trial.IP == record.IP && now.Sub(trial.CreatedAt) < s.ipWindow {
+				recent++
+			}
+			if record.InviteID != "" && trial.InviteID == record.InviteID {
+				used++
+			}
+		}
+		if inviteUses > 0 {
+			if used >= inviteUses {
+				return ErrTrialInviteUsed
+			}
+			if s.ipLimit > 0 && recent >= s.ipLimit {
+				return ErrTrialRateLimited
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 分销商的配额在创建用户时检查，超出配额时释放名额
+	user, err := s.createTrialUser(ctx, record, reseller)
+	if err != nil {
+		if releaseErr := s.storage.Release(record.Email); releaseErr != nil {
+			slog.ErrorContext(ctx, "Failed to release trial reservation", "email", record.Email, "error", releaseErr)
+		}
+		return nil, err
+	}
+
+	if err := s.storage.SetUserID(record.Email, user.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to record trial user", "email", record.Email, "user_id", user.ID, "error", err)
+	}
+	record.UserID = user.ID
+
+	s.audit.Record(ctx, AuditUserTrialCreate, "user", user.ID, nil, record)
+	return user, nil
+}
+
+// createTrialUser 以随机用户名和密码创建试用用户，有效期和各项限制取自套餐
+func (s *TrialService) createTrialUser(ctx context.Context, record *models.TrialRecord, reseller *models.Admin) (*models.User, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate trial credentials: %v", err)
+	}
+
+	req := &models.CreateUserRequest{
+		Username: "trial-" + hex.EncodeToString(buf[:4]),
+		Password: hex.EncodeToString(buf[4:]),
+		PlanID:   record.PlanID,
+		OwnerID:  record.OwnerID,
+		Trial:    true,
+	}
+	if err := s.userService.ResolveCreatePlan(req); err != nil {
+		return nil, err
+	}
+	return s.userService.CreateUser(ctx, req, reseller)
+}
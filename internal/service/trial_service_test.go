@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func newTestTrialService(t *testing.T, ipLimit int) (*TrialService, *testServices) {
+	t.Helper()
+	s := newTestServices(t)
+	s.addPlan(t, &models.Plan{ID: "trial", Name: "trial", DurationDays: 1, TrafficLimit: 100, DeviceLimit: 1})
+	return NewTrialService(s.trials, s.plan, s.user, s.admin, s.audit, []byte("secret"), "trial", ipLimit, time.Hour), s
+}
+
+func TestClaimTrialLimits(t *testing.T) {
+	trials, s := newTestTrialService(t, 2)
+	ctx := context.Background()
+	admin := s.addAdmin(t, &models.Admin{ID: "admin", Username: "admin", Role: models.RoleAdmin})
+	reseller := s.addAdmin(t, &models.Admin{ID: "reseller", Username: "reseller", Role: models.RoleReseller, MaxUsers: 1})
+
+	single, err := trials.CreateInvite(ctx, &models.CreateTrialInviteRequest{}, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := trials.CreateInvite(ctx, &models.CreateTrialInviteRequest{MaxUses: 5}, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, err := trials.CreateInvite(ctx, &models.CreateTrialInviteRequest{MaxUses: 5}, reseller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.MaxUses != 1 || shared.MaxUses != 5 {
+		t.Fatalf("max uses = %d, %d", single.MaxUses, shared.MaxUses)
+	}
+
+	tests := []struct {
+		name   string
+		invite string
+		email  string
+		ip     string
+		want   error
+	}{
+		{"first claim", single.Token, "a@example.com", "10.0.0.1", nil},
+		{"single-use invite is used up", single.Token, "b@example.com", "10.0.0.2", ErrTrialInviteUsed},
+		{"same email again", shared.Token, "A@example.com ", "10.0.0.3", ErrTrialEmailUsed},
+		{"second claim from ip", shared.Token, "c@example.com", "10.0.0.1", nil},
+		{"ip limit reached", shared.Token, "d@example.com", "10.0.0.1", ErrTrialRateLimited},
+		{"tampered invite", shared.Token + "x", "e@example.com", "10.0.0.4", ErrTrialInviteInvalid},
+		{"reseller invite", owned.Token, "f@example.com", "10.0.0.5", nil},
+		{"reseller quota exceeded", owned.Token, "g@example.com", "10.0.0.6", errQuota},
+	}
+	for _, tt := range tests {
+		user, err := trials.ClaimTrial(ctx, &models.ClaimTrialRequest{Invite: tt.invite, Email: tt.email}, tt.ip)
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.want == nil:
+			if !user.Trial || user.TrafficLimit != 100 {
+				t.Errorf("%s: user = %+v", tt.name, user)
+			}
+		case tt.want == errQuota:
+			if err == nil {
+				t.Errorf("%s: expected quota error", tt.name)
+			}
+		case !errors.Is(err, tt.want):
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// 失败的开通不占用名额
+	records, _ := trials.ListTrials("")
+	if len(records) != 3 {
+		t.Fatalf("trial records = %d, want 3", len(records))
+	}
+	if owned, _ := trials.ListTrials(reseller.ID); len(owned) != 1 {
+		t.Fatalf("reseller trial records = %d, want 1", len(owned))
+	}
+}
+
+// errQuota 表示期望分销商配额错误
+var errQuota = errors.New("quota")
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// userMaintenanceInterval 检查用户状态的间隔
+const userMaintenanceInterval = time.Minute
+
+// UserMaintenance 定期维护用户状态：停用到期或流量用尽的用户，按重置周期清零已用流量，
+// 恢复已到自动恢复时间的用户
+type UserMaintenance struct {
+	userService   *UserService
+	configService *ConfigService
+}
+
+// NewUserMaintenance 创建用户状态维护服务
+func NewUserMaintenance(userService *UserService, configService *ConfigService) *UserMaintenance {
+	return &UserMaintenance{
+		userService:   userService,
+		configService: configService,
+	}
+}
+
+// Run 定期维护用户状态，有用户恢复时重新生成配置
+func (m *UserMaintenance) Run() {
+	ticker := time.NewTicker(userMaintenanceInterval)
+	defer ticker.Stop()
+
+	// 定时任务以系统身份记录审计日志
+	ctx := context.Background()
+	for range ticker.C {
+		m.runOnce(ctx)
+	}
+}
+
+// runOnce 执行一次维护
+func (m *UserMaintenance) runOnce(ctx context.Context) {
+	// 到期和超限的用户在生成配置时已被排除，停用只是记录原因，无需重新生成配置
+	disabled, err := m.userService.DisableExhaustedUsers(ctx)
+	if err != nil {
+		slog.Error("Failed to disable exhausted users", "error", err)
+	}
+	if disabled > 0 {
+		slog.Info("Disabled expired or exhausted users", "count", disabled)
+	}
+
+	reset, err := m.userService.ResetTrafficCycles(ctx)
+	if err != nil {
+		slog.Error("Failed to reset traffic", "error", err)
+	}
+	if reset > 0 {
+		slog.Info("Reset user traffic", "count", reset)
+	}
+
+	reactivated, err := m.userService.ReactivateSuspendedUsers(ctx)
+	if err != nil {
+		slog.Error("Failed to reactivate suspended users", "error", err)
+	}
+	if reactivated > 0 {
+		slog.Info("Reactivated suspended users", "count", reactivated)
+	}
+
+	if reset == 0 && reactivated == 0 {
+		return
+	}
+	if err := m.configService.ApplyChanges(ctx); err != nil {
+		slog.Error("Failed to apply user changes", "error", err)
+	}
+}
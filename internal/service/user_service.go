@@ -1,7 +1,12 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"sing-box-manager/internal/models"
@@ -10,49 +15,169 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrDeviceNotFound 用户没有该设备
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrQuotaExceeded 分销商名下的用户数或流量超出配额
+var ErrQuotaExceeded = errors.New("reseller quota exceeded")
+
 // UserService 用户服务
 type UserService struct {
 	storage *storage.JSONStorage
+	plans   *storage.PlanStorage
+	ledger  *storage.LedgerStorage
+	audit   *AuditService
+	
+	// 用于检查用户可使用的入站是否已定义
+	configService *ConfigService
 }
 
 // NewUserService 创建用户服务
-func NewUserService(storage *storage.JSONStorage) *UserService {
+func NewUserService(storage *storage.JSONStorage, plans *storage.PlanStorage, ledger *storage.LedgerStorage, audit *AuditService) *UserService {
 	return &UserService{
 		storage: storage,
+		plans:   plans,
+		ledger:  ledger,
+		audit:   audit,
 	}
 }
 
-// CreateUser 创建用户
-func (s *UserService) CreateUser(req *models.CreateUserRequest) (*models.User, error) {
+// SetConfigService 设置配置服务，创建和更新用户时检查可使用的入站是否已定义，未设置时不做检查
+func (s *UserService) SetConfigService(configService *ConfigService) {
+	s.configService = configService
+}
+
+// validateInbounds 检查入站标签是否都已定义
+func (s *UserService) validateInbounds(tags []string) error {
+	if s.configService == nil {
+		return nil
+	}
+	return checkInbounds(s.configService, models.NormalizeInbounds(tags))
+}
+
+// ResolveCreatePlan 用套餐填充创建请求中未填写的有效期和各项限制，未指定套餐时不做处理
+// 在检查分销商配额前调用，使配额按实际生效的流量计算
+func (s *UserService) ResolveCreatePlan(req *models.CreateUserRequest) error {
+	if req.PlanID == "" {
+		return nil
+	}
+	plan, err := s.plans.GetPlan(req.PlanID)
+	if err != nil {
+		return err
+	}
+	
+	if req.ExpiresAt == "" {
+		req.ExpiresAt = time.Now().Add(plan.Duration()).Format(time.RFC3339)
+	}
+	if req.TrafficLimit == 0 {
+		req.TrafficLimit = plan.TrafficLimit
+	}
+	if req.DeviceLimit == 0 {
+		req.DeviceLimit = plan.DeviceLimit
+	}
+	if req.SpeedLimit == nil {
+		req.SpeedLimit = models.NormalizeSpeedLimit(plan.SpeedLimit)
+	}
+	if req.AllowedInbounds == nil {
+		req.AllowedInbounds = plan.AllowedInbounds
+	}
+	if req.TrafficResetCycle == "" {
+		req.TrafficResetCycle = plan.TrafficResetCycle
+	}
+	return nil
+}
+
+// ResolveUpdatePlan 用套餐填充更新请求中未填写的各项限制，未指定套餐时不做处理
+func (s *UserService) ResolveUpdatePlan(req *models.UpdateUserRequest) error {
+	if req.PlanID == nil || *req.PlanID == "" {
+		return nil
+	}
+	plan, err := s.plans.GetPlan(*req.PlanID)
+	if err != nil {
+		return err
+	}
+	
+	if req.TrafficLimit == nil {
+		req.TrafficLimit = &plan.TrafficLimit
+	}
+	if req.DeviceLimit == nil {
+		req.DeviceLimit = &plan.DeviceLimit
+	}
+	// 套餐未限速或不限制入站时清除用户原有的设置
+	if req.SpeedLimit == nil {
+		req.SpeedLimit = &models.SpeedLimit{}
+		if plan.SpeedLimit != nil {
+			*req.SpeedLimit = *plan.SpeedLimit
+		}
+	}
+	if req.AllowedInbounds == nil {
+		inbounds := append([]string{}, plan.AllowedInbounds...)
+		req.AllowedInbounds = &inbounds
+	}
+	if req.TrafficResetCycle == nil {
+		req.TrafficResetCycle = &plan.TrafficResetCycle
+	}
+	return nil
+}
+
+// CreateUser 创建用户，指定套餐时需先调用ResolveCreatePlan填充请求
+// quota不为nil时在保存用户时检查该分销商的配额
+func (s *UserService) CreateUser(ctx context.Context, req *models.CreateUserRequest, quota *models.Admin) (*models.User, error) {
 	// 检查用户名是否已存在
 	if _, err := s.storage.GetUserByUsername(req.Username); err == nil {
 		return nil, fmt.Errorf("username %s already exists", req.Username)
 	}
 	
+	if req.ExpiresAt == "" || req.TrafficLimit <= 0 || req.DeviceLimit <= 0 {
+		return nil, fmt.Errorf("expires_at, traffic_limit and device_limit are required without plan_id")
+	}
+	if !models.IsValidResetCycle(req.TrafficResetCycle) {
+		return nil, fmt.Errorf("invalid traffic_reset_cycle: %s", req.TrafficResetCycle)
+	}
+	if err := s.validateInbounds(req.AllowedInbounds); err != nil {
+		return nil, err
+	}
+	
 	// 解析过期时间
 	expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
 	if err != nil {
 		return nil, fmt.Errorf("invalid expires_at format: %v", err)
 	}
 	
+	token, err := generateSubscriptionToken()
+	if err != nil {
+		return nil, err
+	}
+	
 	// 创建用户
+	now := time.Now()
 	user := &models.User{
-		ID:               uuid.New().String(),
-		Username:         req.Username,
-		Password:         req.Password,
-		CreatedAt:        time.Now(),
-		ExpiresAt:        expiresAt,
-		TrafficLimit:     req.TrafficLimit,
-		TrafficUsed:      0,
-		DeviceLimit:      req.DeviceLimit,
-		ConnectedDevices: make([]string, 0),
-		IsActive:         true,
-	}
-	
-	if err := s.storage.CreateUser(user); err != nil {
+		ID:                uuid.New().String(),
+		Username:          req.Username,
+		Password:          req.Password,
+		CreatedAt:         now,
+		ExpiresAt:         expiresAt,
+		OwnerID:           req.OwnerID,
+		TrafficLimit:      req.TrafficLimit,
+		TrafficUsed:       0,
+		DeviceLimit:       req.DeviceLimit,
+		Devices:           make([]models.Device, 0),
+		IsActive:          true,
+		SubscriptionToken: token,
+		AllowedGroups:     models.NormalizeGroups(req.AllowedGroups),
+		SpeedLimit:        models.NormalizeSpeedLimit(req.SpeedLimit),
+		PlanID:            req.PlanID,
+		Trial:             req.Trial,
+		AllowedInbounds:   models.NormalizeInbounds(req.AllowedInbounds),
+		TrafficResetCycle: req.TrafficResetCycle,
+		NextTrafficReset:  models.NextResetAfter(req.TrafficResetCycle, now),
+	}
+	
+	if err := s.storage.CreateUserChecked(user, resellerQuota(quota)); err != nil {
 		return nil, err
 	}
 	
+	s.audit.Record(ctx, AuditUserCreate, "user", user.ID, nil, user)
 	return user, nil
 }
 
@@ -66,19 +191,462 @@ func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 	return s.storage.GetUserByUsername(username)
 }
 
-// UpdateUser 更新用户
-func (s *UserService) UpdateUser(id string, req *models.UpdateUserRequest) (*models.User, error) {
-	user, err := s.storage.GetUser(id)
+// GetUserBySubscriptionToken 根据订阅令牌获取用户
+func (s *UserService) GetUserBySubscriptionToken(token string) (*models.User, error) {
+	return s.storage.GetUserBySubscriptionToken(token)
+}
+
+// EnsureSubscriptionTokens 为旧版本创建的、没有订阅令牌的用户补发令牌
+func (s *UserService) EnsureSubscriptionTokens() error {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return err
+	}
+	
+	for _, candidate := range users {
+		if candidate.SubscriptionToken != "" {
+			continue
+		}
+		
+		token, err := generateSubscriptionToken()
+		if err != nil {
+			return err
+		}
+		_, err = s.storage.ModifyUser(candidate.ID, func(user *models.User) error {
+			if user.SubscriptionToken != "" {
+				return errUserUnchanged
+			}
+			user.SubscriptionToken = token
+			return nil
+		})
+		if err != nil && !errors.Is(err, errUserUnchanged) {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// RotateSubscriptionToken 为用户签发新的订阅令牌，旧令牌立即失效，同时清除滥用标记
+func (s *UserService) RotateSubscriptionToken(ctx context.Context, id string) (*models.User, error) {
+	token, err := generateSubscriptionToken()
 	if err != nil {
 		return nil, err
 	}
 	
-	// 更新字段
-	if req.ExpiresAt != nil {
-		expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+	var before *models.User
+	user, err := s.storage.ModifyUser(id, func(user *models.User) error {
+		before = user.Clone()
+		user.SubscriptionToken = token
+		user.SubscriptionFlagged = false
+		user.SubscriptionFlaggedAt = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	
+	s.audit.Record(ctx, AuditUserSubRotate, "user", id, before, user)
+	return user, nil
+}
+
+// FlagSubscription 标记用户的订阅疑似泄露，token为超出限制的订阅令牌
+// 已标记或令牌已被轮换时不做修改，新令牌不会因旧令牌的拉取记录被标记
+func (s *UserService) FlagSubscription(ctx context.Context, id, token string) error {
+	now := time.Now()
+	var before *models.User
+	user, err := s.storage.ModifyUser(id, func(user *models.User) error {
+		if user.SubscriptionFlagged || user.SubscriptionToken != token {
+			return errUserUnchanged
+		}
+		before = user.Clone()
+		user.SubscriptionFlagged = true
+		user.SubscriptionFlaggedAt = &now
+		return nil
+	})
+	if errors.Is(err, errUserUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserSubFlag, "user", id, before, user)
+	return nil
+}
+
+// SuspendUser 临时停用用户，until之后由ReactivateSuspendedUsers自动恢复
+func (s *UserService) SuspendUser(ctx context.Context, id string, until time.Time) error {
+	var before *models.User
+	user, err := s.storage.ModifyUser(id, func(user *models.User) error {
+		before = user.Clone()
+		user.IsActive = false
+		user.DisabledReason = models.DisabledReasonSuspended
+		user.DisabledUntil = &until
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserSuspend, "user", id, before, user)
+	return nil
+}
+
+// ReactivateSuspendedUsers 恢复已到自动恢复时间的用户，返回恢复的用户数
+func (s *UserService) ReactivateSuspendedUsers(ctx context.Context) (int, error) {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	
+	now := time.Now()
+	reactivated := 0
+	for _, candidate := range users {
+		if !suspensionOver(candidate, now) {
+			continue
+		}
+		
+		// 检查后管理员可能已修改用户，在锁内按最新记录重新判断
+		var before *models.User
+		user, err := s.storage.ModifyUser(candidate.ID, func(user *models.User) error {
+			if !suspensionOver(user, now) {
+				return errUserUnchanged
+			}
+			before = user.Clone()
+			user.IsActive = true
+			user.DisabledReason = ""
+			user.DisabledUntil = nil
+			return nil
+		})
+		if errors.Is(err, errUserUnchanged) {
+			continue
+		}
+		if err != nil {
+			return reactivated, err
+		}
+		
+		s.audit.Record(ctx, AuditUserReactivate, "user", user.ID, before, user)
+		reactivated++
+	}
+	return reactivated, nil
+}
+
+// suspensionOver 用户因临时停用而停用且已到恢复时间
+func suspensionOver(user *models.User, now time.Time) bool {
+	return !user.IsActive && user.DisabledReason == models.DisabledReasonSuspended &&
+		user.DisabledUntil != nil && !user.DisabledUntil.After(now)
+}
+
+// DisableExhaustedUsers 停用已到期或流量用尽的用户并记录原因，返回停用的用户数
+func (s *UserService) DisableExhaustedUsers(ctx context.Context) (int, error) {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	
+	disabled := 0
+	for _, candidate := range users {
+		if !candidate.IsActive || candidate.ExhaustedReason() == "" {
+			continue
+		}
+		
+		// 检查后用户可能已被续期或充值，在锁内按最新记录重新判断
+		var before *models.User
+		user, err := s.storage.ModifyUser(candidate.ID, func(user *models.User) error {
+			reason := user.ExhaustedReason()
+			if !user.IsActive || reason == "" {
+				return errUserUnchanged
+			}
+			before = user.Clone()
+			user.IsActive = false
+			user.DisabledReason = reason
+			return nil
+		})
+		if errors.Is(err, errUserUnchanged) {
+			continue
+		}
+		if err != nil {
+			return disabled, err
+		}
+		
+		s.audit.Record(ctx, AuditUserDisable, "user", user.ID, before, user)
+		disabled++
+	}
+	return disabled, nil
+}
+
+// reactivateIfRecovered 恢复因到期或流量用尽停用、现已不再到期或超限的用户
+func reactivateIfRecovered(user *models.User) {
+	if user.IsRecoverable() {
+		user.IsActive = true
+		user.DisabledReason = ""
+		user.DisabledUntil = nil
+	}
+}
+
+// RenewUser 续期用户，从当前有效期和当前时间中较晚者起算，并记录到账单
+// 指定套餐时未填写天数则按套餐天数续期，并应用套餐的各项限制；试用用户同时转为正式用户，用户ID和密码不变
+// quota不为nil时流量限制提高后检查该分销商的配额
+func (s *UserService) RenewUser(ctx context.Context, id string, req *models.RenewUserRequest, quota *models.Admin) (*models.User, *models.LedgerEntry, error) {
+	return s.renew(ctx, id, req, 0, quota)
+}
+
+// ApplyVoucher 在一次用户变更中应用兑换码的续期和流量充值，只写入一条账单记录
+// 未指定续期天数和套餐时只充值流量，任何一项失败时用户不做修改
+func (s *UserService) ApplyVoucher(ctx context.Context, id string, req *models.RenewUserRequest, trafficBytes int64, quota *models.Admin) (*models.User, *models.LedgerEntry, error) {
+	if req.DurationDays == 0 && req.PlanID == "" {
+		return s.TopUpUser(ctx, id, &models.TopUpUserRequest{TrafficBytes: trafficBytes, Note: req.Note}, quota)
+	}
+	return s.renew(ctx, id, req, trafficBytes, quota)
+}
+
+// renew 续期用户，同时增加trafficBytes流量，流量在应用套餐的流量限制之后增加
+func (s *UserService) renew(ctx context.Context, id string, req *models.RenewUserRequest, trafficBytes int64, quota *models.Admin) (*models.User, *models.LedgerEntry, error) {
+	var plan *models.Plan
+	if req.PlanID != "" {
+		var err error
+		if plan, err = s.plans.GetPlan(req.PlanID); err != nil {
+			return nil, nil, err
+		}
+	}
+	days := req.DurationDays
+	if days == 0 && plan != nil {
+		days = plan.DurationDays
+	}
+	if days <= 0 {
+		return nil, nil, fmt.Errorf("duration_days or plan_id is required")
+	}
+	
+	var converting bool
+	before, user, entry, err := s.updateWithLedger(ctx, id, quota, func(before, user *models.User) (*models.LedgerEntry, error) {
+		converting = applyRenewal(user, plan, days, req.ResetTraffic, time.Now())
+		if trafficBytes > 0 {
+			user.TrafficLimit += trafficBytes
+			reactivateIfRecovered(user)
+		}
+		
+		entryType := models.LedgerRenew
+		if converting {
+			entryType = models.LedgerConvert
+		}
+		entry := s.newLedgerEntry(ctx, entryType, before, user, req.Note)
+		entry.DurationDays = days
+		entry.TrafficBytes = trafficBytes
+		if plan != nil {
+			entry.PlanID = plan.ID
+		}
+		return entry, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	action := AuditUserRenew
+	if converting {
+		action = AuditUserTrialConvert
+	}
+	s.audit.Record(ctx, action, "user", id, before, user)
+	return user, entry, nil
+}
+
+// applyRenewal 按续期天数和套餐修改用户，返回试用用户是否转为正式用户
+// 试用用户按套餐续期时转为正式用户，剩余的试用时间和已用流量不计入
+func applyRenewal(user *models.User, plan *models.Plan, days int, resetTraffic bool, now time.Time) bool {
+	converting := user.Trial && plan != nil
+	
+	base := user.ExpiresAt
+	if base.Before(now) || converting {
+		base = now
+	}
+	user.ExpiresAt = base.AddDate(0, 0, days)
+	
+	if plan != nil {
+		user.PlanID = plan.ID
+		user.TrafficLimit = plan.TrafficLimit
+		user.DeviceLimit = plan.DeviceLimit
+		user.SpeedLimit = models.NormalizeSpeedLimit(plan.SpeedLimit)
+		user.AllowedInbounds = models.NormalizeInbounds(plan.AllowedInbounds)
+		if plan.TrafficResetCycle != user.TrafficResetCycle {
+			user.TrafficResetCycle = plan.TrafficResetCycle
+			user.NextTrafficReset = models.NextResetAfter(user.TrafficResetCycle, now)
+		}
+	}
+	if resetTraffic || converting {
+		user.TrafficUsed = 0
+		user.NextTrafficReset = models.NextResetAfter(user.TrafficResetCycle, now)
+	}
+	user.Trial = user.Trial && !converting
+	reactivateIfRecovered(user)
+	return converting
+}
+
+// TopUpUser 为用户增加流量，并记录到账单，quota不为nil时检查该分销商的配额
+func (s *UserService) TopUpUser(ctx context.Context, id string, req *models.TopUpUserRequest, quota *models.Admin) (*models.User, *models.LedgerEntry, error) {
+	before, user, entry, err := s.updateWithLedger(ctx, id, quota, func(before, user *models.User) (*models.LedgerEntry, error) {
+		user.TrafficLimit += req.TrafficBytes
+		reactivateIfRecovered(user)
+		
+		entry := s.newLedgerEntry(ctx, models.LedgerTopUp, before, user, req.Note)
+		entry.TrafficBytes = req.TrafficBytes
+		return entry, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	s.audit.Record(ctx, AuditUserTopUp, "user", id, before, user)
+	return user, entry, nil
+}
+
+// updateWithLedger 修改用户并写入账单记录，返回修改前后的用户和账单记录
+// 配额检查通过后才写入账单，账单在用户变更保存前写入并同步到磁盘，写入失败时用户不做修改；
+// 用户保存失败时账单已写入，记录错误日志供核对
+func (s *UserService) updateWithLedger(ctx context.Context, id string, quota *models.Admin, update func(before, user *models.User) (*models.LedgerEntry, error)) (*models.User, *models.User, *models.LedgerEntry, error) {
+	var before *models.User
+	var entry *models.LedgerEntry
+	check := resellerQuota(quota)
+	user, err := s.storage.ModifyUserChecked(id, func(user *models.User) error {
+		before = user.Clone()
+		var err error
+		entry, err = update(before, user)
+		return err
+	}, func(before, after *models.User, owned []*models.User) error {
+		if check != nil {
+			if err := check(before, after, owned); err != nil {
+				entry = nil
+				return err
+			}
+		}
+		if err := s.ledger.Append(entry); err != nil {
+			entry = nil
+			return fmt.Errorf("failed to write ledger entry: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if entry != nil {
+			slog.ErrorContext(ctx, "Ledger entry was written but the user update failed", "user_id", id, "ledger_id", entry.ID, "type", entry.Type, "error", err)
+		}
+		return nil, nil, nil, err
+	}
+	return before, user, entry, nil
+}
+
+// ListLedger 按时间倒序列出用户的续期和充值记录
+func (s *UserService) ListLedger(userID string) ([]*models.LedgerEntry, error) {
+	return s.ledger.ListByUser(userID)
+}
+
+// newLedgerEntry 根据操作前后的用户状态构造账单记录
+func (s *UserService) newLedgerEntry(ctx context.Context, entryType string, before, after *models.User, note string) *models.LedgerEntry {
+	actor := ActorFromContext(ctx)
+	return &models.LedgerEntry{
+		ID:                 uuid.New().String(),
+		Timestamp:          time.Now(),
+		UserID:             after.ID,
+		Type:               entryType,
+		ActorType:          actor.Type,
+		ActorID:            actor.ID,
+		ActorName:          actor.Name,
+		ExpiresBefore:      before.ExpiresAt,
+		ExpiresAfter:       after.ExpiresAt,
+		TrafficLimitBefore: before.TrafficLimit,
+		TrafficLimitAfter:  after.TrafficLimit,
+		Note:               note,
+	}
+}
+
+// ResetTrafficCycles 重置已到重置时间的用户已用流量，返回重置的用户数
+func (s *UserService) ResetTrafficCycles(ctx context.Context) (int, error) {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	
+	now := time.Now()
+	reset := 0
+	for _, candidate := range users {
+		if candidate.NextTrafficReset == nil || candidate.NextTrafficReset.After(now) {
+			continue
+		}
+		
+		var before *models.User
+		user, err := s.storage.ModifyUser(candidate.ID, func(user *models.User) error {
+			if user.NextTrafficReset == nil || user.NextTrafficReset.After(now) {
+				return errUserUnchanged
+			}
+			before = user.Clone()
+			user.TrafficUsed = 0
+			user.NextTrafficReset = advanceTrafficReset(user.TrafficResetCycle, *user.NextTrafficReset, now)
+			reactivateIfRecovered(user)
+			return nil
+		})
+		if errors.Is(err, errUserUnchanged) {
+			continue
+		}
 		if err != nil {
+			return reset, err
+		}
+		
+		s.audit.Record(ctx, AuditUserTrafficReset, "user", user.ID, before, user)
+		reset++
+	}
+	return reset, nil
+}
+
+// errUserUnchanged 用户在检查后已被修改，不再需要本次变更
+var errUserUnchanged = errors.New("user no longer needs the change")
+
+// advanceTrafficReset 返回晚于now的下一次重置时间，周期无效时返回nil
+// 长时间未运行时跳过错过的周期，下一次重置仍落在原周期的时间点上
+func advanceTrafficReset(cycle string, next, now time.Time) *time.Time {
+	for !next.After(now) {
+		nextReset := models.NextResetAfter(cycle, next)
+		if nextReset == nil {
+			return nil
+		}
+		next = *nextReset
+	}
+	return &next
+}
+
+// UpdateUser 更新用户，指定套餐时需先调用ResolveUpdatePlan填充请求
+// quota不为nil时流量限制提高后检查该分销商的配额
+func (s *UserService) UpdateUser(ctx context.Context, id string, req *models.UpdateUserRequest, quota *models.Admin) (*models.User, error) {
+	if req.TrafficResetCycle != nil && !models.IsValidResetCycle(*req.TrafficResetCycle) {
+		return nil, fmt.Errorf("invalid traffic_reset_cycle: %s", *req.TrafficResetCycle)
+	}
+	if req.AllowedInbounds != nil {
+		if err := s.validateInbounds(*req.AllowedInbounds); err != nil {
+			return nil, err
+		}
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		var err error
+		if expiresAt, err = time.Parse(time.RFC3339, *req.ExpiresAt); err != nil {
 			return nil, fmt.Errorf("invalid expires_at format: %v", err)
 		}
+	}
+	
+	var before *models.User
+	user, err := s.storage.ModifyUserChecked(id, func(user *models.User) error {
+		before = user.Clone()
+		applyUpdate(user, req, expiresAt)
+		return nil
+	}, resellerQuota(quota))
+	if err != nil {
+		return nil, err
+	}
+	
+	s.audit.Record(ctx, AuditUserUpdate, "user", id, before, user)
+	return user, nil
+}
+
+// applyUpdate 将更新请求中填写的字段应用到用户
+func applyUpdate(user *models.User, req *models.UpdateUserRequest, expiresAt time.Time) {
+	if req.ExpiresAt != nil {
 		user.ExpiresAt = expiresAt
 	}
 	
@@ -92,18 +660,53 @@ func (s *UserService) UpdateUser(id string, req *models.UpdateUserRequest) (*mod
 	
 	if req.IsActive != nil {
 		user.IsActive = *req.IsActive
+		user.DisabledReason = ""
+		user.DisabledUntil = nil
+		if !user.IsActive {
+			user.DisabledReason = models.DisabledReasonManual
+		}
 	}
 	
-	if err := s.storage.UpdateUser(id, user); err != nil {
-		return nil, err
+	if req.AllowedGroups != nil {
+		user.AllowedGroups = models.NormalizeGroups(*req.AllowedGroups)
 	}
 	
-	return user, nil
+	if req.SpeedLimit != nil {
+		user.SpeedLimit = models.NormalizeSpeedLimit(req.SpeedLimit)
+	}
+	
+	if req.PlanID != nil {
+		user.PlanID = *req.PlanID
+	}
+	
+	if req.AllowedInbounds != nil {
+		user.AllowedInbounds = models.NormalizeInbounds(*req.AllowedInbounds)
+	}
+	
+	// 重置周期变化时从现在开始计算下一次重置
+	if req.TrafficResetCycle != nil && *req.TrafficResetCycle != user.TrafficResetCycle {
+		user.TrafficResetCycle = *req.TrafficResetCycle
+		user.NextTrafficReset = models.NextResetAfter(user.TrafficResetCycle, time.Now())
+	}
+	
+	// 延长有效期或提高流量限制后恢复因到期或流量用尽停用的用户
+	reactivateIfRecovered(user)
 }
 
 // DeleteUser 删除用户
-func (s *UserService) DeleteUser(id string) error {
-	return s.storage.DeleteUser(id)
+func (s *UserService) DeleteUser(ctx context.Context, id string) error {
+	user, err := s.storage.GetUser(id)
+	if err != nil {
+		return err
+	}
+	before := user.Clone()
+	
+	if err := s.storage.DeleteUser(id); err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserDelete, "user", id, before, nil)
+	return nil
 }
 
 // ListUsers 列出所有用户
@@ -111,8 +714,55 @@ func (s *UserService) ListUsers() ([]*models.User, error) {
 	return s.storage.ListUsers()
 }
 
+// ListUsersByOwner 列出指定分销商名下的用户
+func (s *UserService) ListUsersByOwner(ownerID string) ([]*models.User, error) {
+	users, err := s.storage.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	
+	owned := make([]*models.User, 0)
+	for _, user := range users {
+		if user.OwnerID == ownerID {
+			owned = append(owned, user)
+		}
+	}
+	
+	return owned, nil
+}
+
+// resellerQuota 返回在用户存储的锁内检查分销商配额的函数，reseller为nil时不检查
+// 只检查增加的部分：创建用户时检查用户数和流量，修改用户时只在流量限制提高后检查，降低限制时即使已超出配额也允许
+func resellerQuota(reseller *models.Admin) storage.UserCheck {
+	if reseller == nil {
+		return nil
+	}
+	return func(before, after *models.User, owned []*models.User) error {
+		addUsers, addTraffic := 1, after.TrafficLimit
+		if before != nil {
+			addUsers, addTraffic = 0, after.TrafficLimit-before.TrafficLimit
+		}
+		
+		if reseller.MaxUsers > 0 && addUsers > 0 && len(owned) > reseller.MaxUsers {
+			return fmt.Errorf("%w: %d/%d users allocated", ErrQuotaExceeded, len(owned)-addUsers, reseller.MaxUsers)
+		}
+		
+		if reseller.MaxTraffic > 0 && addTraffic > 0 {
+			var allocated int64
+			for _, user := range owned {
+				allocated += user.TrafficLimit
+			}
+			if allocated > reseller.MaxTraffic {
+				return fmt.Errorf("%w: %d/%d bytes allocated", ErrQuotaExceeded, allocated-addTraffic, reseller.MaxTraffic)
+			}
+		}
+		
+		return nil
+	}
+}
+
 // ConnectDevice 连接设备
-func (s *UserService) ConnectDevice(userID, deviceID string) error {
+func (s *UserService) ConnectDevice(ctx context.Context, userID, deviceID string) error {
 	user, err := s.storage.GetUser(userID)
 	if err != nil {
 		return err
@@ -121,18 +771,125 @@ func (s *UserService) ConnectDevice(userID, deviceID string) error {
 	if !user.CanConnect(deviceID) {
 		return fmt.Errorf("user cannot connect: expired, traffic exceeded, or device limit reached")
 	}
+	before := user.Clone()
+	
+	after, err := s.storage.AddConnectedDevice(userID, deviceID)
+	if err != nil {
+		return err
+	}
 	
-	return s.storage.AddConnectedDevice(userID, deviceID)
+	s.audit.Record(ctx, AuditUserConnect, "user", userID, before, after)
+	return nil
 }
 
 // DisconnectDevice 断开设备
-func (s *UserService) DisconnectDevice(userID, deviceID string) error {
-	return s.storage.RemoveConnectedDevice(userID, deviceID)
+func (s *UserService) DisconnectDevice(ctx context.Context, userID, deviceID string) error {
+	user, err := s.storage.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	before := user.Clone()
+	
+	after, err := s.storage.RemoveConnectedDevice(userID, deviceID)
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserDisconnect, "user", userID, before, after)
+	return nil
 }
 
-// UpdateTrafficUsage 更新流量使用
-func (s *UserService) UpdateTrafficUsage(userID string, bytesUsed int64) error {
-	return s.storage.UpdateTrafficUsage(userID, bytesUsed)
+// RenameDevice 修改设备名称
+func (s *UserService) RenameDevice(ctx context.Context, userID, deviceID, name string) (*models.Device, error) {
+	user, err := s.storage.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.FindDevice(deviceID) < 0 {
+		return nil, ErrDeviceNotFound
+	}
+	before := user.Clone()
+	
+	after, err := s.storage.RenameDevice(userID, deviceID, name)
+	if err != nil {
+		return nil, err
+	}
+	
+	s.audit.Record(ctx, AuditUserDeviceRename, "user", userID, before, after)
+	i := after.FindDevice(deviceID)
+	if i < 0 {
+		return nil, ErrDeviceNotFound
+	}
+	device := after.Devices[i]
+	return &device, nil
+}
+
+// RevokeDevice 移除设备，释放其占用的设备数，设备在revokedDeviceTTL内不会重新加入设备列表
+func (s *UserService) RevokeDevice(ctx context.Context, userID, deviceID string) error {
+	user, err := s.storage.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.FindDevice(deviceID) < 0 {
+		return ErrDeviceNotFound
+	}
+	before := user.Clone()
+	
+	after, err := s.storage.RevokeDevice(userID, deviceID, time.Now().Add(revokedDeviceTTL))
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserDeviceRevoke, "user", userID, before, after)
+	return nil
+}
+
+// NoteDeviceClient 记录设备拉取订阅时使用的客户端，设备尚未出现时不做处理
+func (s *UserService) NoteDeviceClient(userID, deviceID, userAgent string) error {
+	if userAgent == "" {
+		return nil
+	}
+	return s.storage.UpdateDeviceClient(userID, deviceID, userAgent)
+}
+
+// RecordDeviceActivity 记录从sing-box连接中识别出的设备活动
+// 属于自动采集，不写入审计日志
+func (s *UserService) RecordDeviceActivity(activity models.DeviceActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+	return s.storage.TouchDevices(activity)
+}
+
+// ExpireIdleDevices 移除空闲超过idleTimeout的设备，返回被移除的设备数
+func (s *UserService) ExpireIdleDevices(idleTimeout time.Duration) (int, error) {
+	return s.storage.ExpireIdleDevices(time.Now().Add(-idleTimeout))
+}
+
+// DeviceActivitySince 返回since之后有活动的设备，agent用于向中心管理端上报
+func (s *UserService) DeviceActivitySince(since time.Time) models.DeviceActivity {
+	return s.storage.DeviceActivitySince(since)
+}
+
+// UpdateTrafficUsage 更新流量使用，inbound为产生流量的入站标签，可为空
+func (s *UserService) UpdateTrafficUsage(ctx context.Context, userID string, bytesUsed int64, inbound string) error {
+	before, err := s.storage.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	
+	after, err := s.storage.UpdateTrafficUsage(userID, bytesUsed, inbound)
+	if err != nil {
+		return err
+	}
+	
+	s.audit.Record(ctx, AuditUserTraffic, "user", userID, before, after)
+	return nil
+}
+
+// ApplyTrafficDeltas 批量累加节点上报的流量，只写入一次用户数据且不逐个记录审计日志，由调用方按批次审计
+func (s *UserService) ApplyTrafficDeltas(deltas map[string]int64) (map[string]int64, error) {
+	return s.storage.ApplyTrafficDeltas(deltas)
 }
 
 // GetUserStats 获取用户统计信息
@@ -150,11 +907,22 @@ func (s *UserService) GetUserStats(userID string) (map[string]interface{}, error
 		"traffic_used":      user.TrafficUsed,
 		"traffic_limit":     user.TrafficLimit,
 		"traffic_remaining": user.TrafficLimit - user.TrafficUsed,
-		"device_count":      len(user.ConnectedDevices),
+		"device_count":      len(user.Devices),
 		"device_limit":      user.DeviceLimit,
 		"expires_at":        user.ExpiresAt,
-		"connected_devices": user.ConnectedDevices,
+		"devices":           user.Devices,
+		"connected_devices": user.ConnectedDeviceIDs(),
+		"device_last_seen":  user.DeviceLastSeen(),
+		"speed_limit":       user.SpeedLimit,
 	}
 	
 	return stats, nil
-}
\ No newline at end of file
+}
+// generateSubscriptionToken 生成不可猜测的订阅令牌
+func generateSubscriptionToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate subscription token: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
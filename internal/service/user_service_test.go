@@ -0,0 +1,617 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+)
+
+// addUser 直接写入用户
+func (s *testServices) addUser(t *testing.T, user *models.User) *models.User {
+	t.Helper()
+	user.CreatedAt = time.Now()
+	if err := s.users.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRenewAndTopUpWriteLedger(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	s.addPlan(t, &models.Plan{ID: "monthly", Name: "monthly", DurationDays: 30, TrafficLimit: 1000, DeviceLimit: 2, TrafficResetCycle: models.ResetCycleMonthly})
+
+	future := time.Now().AddDate(0, 0, 10)
+	tests := []struct {
+		name        string
+		user        *models.User
+		renew       *models.RenewUserRequest
+		topUp       int64
+		wantType    string
+		wantLimit   int64
+		wantUsed    int64
+		wantTrial   bool
+		wantMinDays int
+	}{
+		{
+			name:        "renew extends current expiry",
+			user:        &models.User{ID: "u1", Username: "u1", IsActive: true, ExpiresAt: future, TrafficLimit: 500, TrafficUsed: 200},
+			renew:       &models.RenewUserRequest{DurationDays: 5},
+			wantType:    models.LedgerRenew,
+			wantLimit:   500,
+			wantUsed:    200,
+			wantMinDays: 14,
+		},
+		{
+			name:        "trial converts on plan renewal",
+			user:        &models.User{ID: "u2", Username: "u2", IsActive: true, Trial: true, ExpiresAt: future, TrafficLimit: 100, TrafficUsed: 50},
+			renew:       &models.RenewUserRequest{PlanID: "monthly"},
+			wantType:    models.LedgerConvert,
+			wantLimit:   1000,
+			wantUsed:    0,
+			wantMinDays: 29,
+		},
+		{
+			name:      "top-up adds traffic and reactivates",
+			user:      &models.User{ID: "u3", Username: "u3", DisabledReason: models.DisabledReasonTraffic, ExpiresAt: future, TrafficLimit: 100, TrafficUsed: 100},
+			topUp:     50,
+			wantType:  models.LedgerTopUp,
+			wantLimit: 150,
+			wantUsed:  100,
+		},
+	}
+	for _, tt := range tests {
+		s.addUser(t, tt.user)
+
+		var user *models.User
+		var entry *models.LedgerEntry
+		var err error
+		if tt.renew != nil {
+			user, entry, err = s.user.RenewUser(ctx, tt.user.ID, tt.renew, nil)
+		} else {
+			user, entry, err = s.user.TopUpUser(ctx, tt.user.ID, &models.TopUpUserRequest{TrafficBytes: tt.topUp}, nil)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if user.TrafficLimit != tt.wantLimit || user.TrafficUsed != tt.wantUsed || user.Trial != tt.wantTrial || !user.IsActive {
+			t.Errorf("%s: user = %+v", tt.name, user)
+		}
+		if tt.wantMinDays > 0 && user.ExpiresAt.Before(time.Now().AddDate(0, 0, tt.wantMinDays)) {
+			t.Errorf("%s: expires at %v", tt.name, user.ExpiresAt)
+		}
+
+		entries, err := s.user.ListLedger(tt.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].ID != entry.ID || entries[0].Type != tt.wantType {
+			t.Errorf("%s: ledger = %+v, want one %s entry", tt.name, entries, tt.wantType)
+		}
+	}
+}
+
+func TestLedgerFailureLeavesUserUnchanged(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", IsActive: true, ExpiresAt: time.Now(), TrafficLimit: 100})
+
+	// 账单路径是目录，写入必然失败
+	ledgerPath := filepath.Join(s.dir, "broken-ledger")
+	if err := os.Mkdir(ledgerPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := storage.NewLedgerStorage(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewUserService(s.users, s.plans, ledger, s.audit)
+
+	if _, _, err := users.RenewUser(ctx, "u1", &models.RenewUserRequest{DurationDays: 30}, nil); err == nil {
+		t.Error("renew succeeded without a ledger entry")
+	}
+	if _, _, err := users.TopUpUser(ctx, "u1", &models.TopUpUserRequest{TrafficBytes: 50}, nil); err == nil {
+		t.Error("top-up succeeded without a ledger entry")
+	}
+
+	user, err := s.users.GetUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TrafficLimit != 100 || user.ExpiresAt.After(time.Now()) {
+		t.Errorf("user changed after ledger failure: %+v", user)
+	}
+}
+
+func TestResolveCreatePlan(t *testing.T) {
+	s := newTestServices(t)
+	s.addPlan(t, &models.Plan{ID: "pro", Name: "pro", DurationDays: 30, TrafficLimit: 1000, DeviceLimit: 3,
+		SpeedLimit: &models.SpeedLimit{UploadKbps: 1000, DownloadKbps: 2000}, TrafficResetCycle: models.ResetCycleMonthly, AllowedInbounds: []string{"trojan-in"}})
+
+	tests := []struct {
+		name        string
+		req         models.CreateUserRequest
+		wantErr     bool
+		wantTraffic int64
+		wantDevices int
+		wantCycle   string
+		wantInbound []string
+	}{
+		{"no plan keeps request", models.CreateUserRequest{TrafficLimit: 5, DeviceLimit: 1}, false, 5, 1, "", nil},
+		{"plan fills limits", models.CreateUserRequest{PlanID: "pro"}, false, 1000, 3, models.ResetCycleMonthly, []string{"trojan-in"}},
+		{"explicit values win", models.CreateUserRequest{PlanID: "pro", TrafficLimit: 7, DeviceLimit: 2, TrafficResetCycle: models.ResetCycleWeekly, AllowedInbounds: []string{}},
+			false, 7, 2, models.ResetCycleWeekly, []string{}},
+		{"unknown plan", models.CreateUserRequest{PlanID: "missing"}, true, 0, 0, "", nil},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		err := s.user.ResolveCreatePlan(&req)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if req.TrafficLimit != tt.wantTraffic || req.DeviceLimit != tt.wantDevices || req.TrafficResetCycle != tt.wantCycle ||
+			len(req.AllowedInbounds) != len(tt.wantInbound) || (req.AllowedInbounds == nil) != (tt.wantInbound == nil) {
+			t.Errorf("%s: request = %+v", tt.name, req)
+		}
+		if tt.req.PlanID != "" && req.ExpiresAt == "" {
+			t.Errorf("%s: expires_at not filled", tt.name)
+		}
+	}
+}
+
+func TestResolveUpdatePlanClearsLimits(t *testing.T) {
+	s := newTestServices(t)
+	s.addPlan(t, &models.Plan{ID: "plain", Name: "plain", DurationDays: 30, TrafficLimit: 1000, DeviceLimit: 3})
+
+	planID := "plain"
+	req := models.UpdateUserRequest{PlanID: &planID}
+	if err := s.user.ResolveUpdatePlan(&req); err != nil {
+		t.Fatal(err)
+	}
+	// 套餐未限速、不限制入站时清除用户原有的设置
+	if *req.TrafficLimit != 1000 || *req.DeviceLimit != 3 || *req.SpeedLimit != (models.SpeedLimit{}) ||
+		req.AllowedInbounds == nil || len(*req.AllowedInbounds) != 0 || *req.TrafficResetCycle != "" {
+		t.Errorf("request = %+v", req)
+	}
+}
+
+func TestUserInboundValidation(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	expires := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+
+	tests := []struct {
+		name     string
+		inbounds []string
+		wantErr  bool
+	}{
+		{"no restriction", nil, false},
+		{"known inbounds", []string{"trojan-in", " vless-in "}, false},
+		{"unknown inbound", []string{"trojan-in", "missing-in"}, true},
+	}
+	for i, tt := range tests {
+		req := &models.CreateUserRequest{Username: fmt.Sprintf("user-%d", i), Password: "secret", ExpiresAt: expires, TrafficLimit: 100, DeviceLimit: 1, AllowedInbounds: tt.inbounds}
+		user, err := s.user.CreateUser(ctx, req, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: create err = %v", tt.name, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		update := &models.UpdateUserRequest{AllowedInbounds: &[]string{"missing-in"}}
+		if _, err := s.user.UpdateUser(ctx, user.ID, update, nil); err == nil {
+			t.Errorf("%s: update accepted an unknown inbound", tt.name)
+		}
+	}
+}
+
+func TestResellerQuota(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	reseller := s.addAdmin(t, &models.Admin{ID: "reseller", Username: "reseller", Role: models.RoleReseller, MaxUsers: 2, MaxTraffic: 300})
+	expires := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+	create := func(name string, traffic int64) error {
+		_, err := s.user.CreateUser(ctx, &models.CreateUserRequest{Username: name, Password: "secret", ExpiresAt: expires, TrafficLimit: traffic, DeviceLimit: 1, OwnerID: reseller.ID}, reseller)
+		return err
+	}
+	limit := func(name string, traffic int64) error {
+		user, err := s.users.GetUserByUsername(name)
+		if err != nil {
+			return err
+		}
+		_, err = s.user.UpdateUser(ctx, user.ID, &models.UpdateUserRequest{TrafficLimit: &traffic}, reseller)
+		return err
+	}
+	topUp := func(name string, traffic int64) error {
+		user, err := s.users.GetUserByUsername(name)
+		if err != nil {
+			return err
+		}
+		_, _, err = s.user.TopUpUser(ctx, user.ID, &models.TopUpUserRequest{TrafficBytes: traffic}, reseller)
+		return err
+	}
+
+	tests := []struct {
+		name      string
+		run       func() error
+		wantQuota bool
+	}{
+		{"first user", func() error { return create("a", 100) }, false},
+		{"traffic over quota", func() error { return create("b", 250) }, true},
+		{"second user", func() error { return create("b", 150) }, false},
+		{"user count over quota", func() error { return create("c", 1) }, true},
+		{"raise within quota", func() error { return limit("a", 150) }, false},
+		{"raise over quota", func() error { return limit("a", 151) }, true},
+		{"top-up over quota", func() error { return topUp("b", 1) }, true},
+		{"lower limit", func() error { return limit("a", 50) }, false},
+		{"top-up within quota", func() error { return topUp("b", 100) }, false},
+	}
+	for _, tt := range tests {
+		err := tt.run()
+		if tt.wantQuota != errors.Is(err, ErrQuotaExceeded) || (!tt.wantQuota && err != nil) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+
+	// 配额错误不应留下账单记录
+	b, _ := s.users.GetUserByUsername("b")
+	if entries, _ := s.user.ListLedger(b.ID); len(entries) != 1 {
+		t.Errorf("ledger entries = %d, want 1", len(entries))
+	}
+}
+
+func TestResellerQuotaConcurrentCreates(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	reseller := s.addAdmin(t, &models.Admin{ID: "reseller", Username: "reseller", Role: models.RoleReseller, MaxUsers: 3})
+	expires := time.Now().AddDate(0, 1, 0).Format(time.RFC3339)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.user.CreateUser(ctx, &models.CreateUserRequest{Username: fmt.Sprintf("user-%d", i), Password: "secret", ExpiresAt: expires, TrafficLimit: 1, DeviceLimit: 1, OwnerID: reseller.ID}, reseller)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if created != 3 {
+		t.Errorf("created = %d, want 3", created)
+	}
+}
+
+func TestAdvanceTrafficReset(t *testing.T) {
+	base := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		cycle string
+		next  time.Time
+		now   time.Time
+		want  *time.Time
+	}{
+		{"daily due now", models.ResetCycleDaily, base, base, ptr(base.AddDate(0, 0, 1))},
+		{"daily skips missed days", models.ResetCycleDaily, base, base.Add(72*time.Hour + time.Minute), ptr(base.AddDate(0, 0, 4))},
+		{"weekly keeps weekday", models.ResetCycleWeekly, base, base.AddDate(0, 0, 10), ptr(base.AddDate(0, 0, 14))},
+		{"monthly keeps day of month", models.ResetCycleMonthly, base, base.Add(time.Hour), ptr(base.AddDate(0, 1, 0))},
+		{"monthly skips missed months", models.ResetCycleMonthly, base, base.AddDate(0, 2, 1), ptr(base.AddDate(0, 3, 0))},
+		{"not yet due", models.ResetCycleMonthly, base, base.Add(-time.Hour), ptr(base)},
+		{"cycle removed", "", base, base, nil},
+	}
+	for _, tt := range tests {
+		got := advanceTrafficReset(tt.cycle, tt.next, tt.now)
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("%s: got %v, want nil", tt.name, *got)
+		case tt.want != nil && (got == nil || !got.Equal(*tt.want)):
+			t.Errorf("%s: got %v, want %v", tt.name, got, *tt.want)
+		}
+	}
+}
+
+func TestResetTrafficCycles(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	expires := time.Now().AddDate(0, 1, 0)
+
+	s.addUser(t, &models.User{ID: "due", Username: "due", ExpiresAt: expires, TrafficLimit: 100, TrafficUsed: 100, DisabledReason: models.DisabledReasonTraffic, TrafficResetCycle: models.ResetCycleDaily, NextTrafficReset: &past})
+	s.addUser(t, &models.User{ID: "later", Username: "later", IsActive: true, ExpiresAt: expires, TrafficLimit: 100, TrafficUsed: 40, TrafficResetCycle: models.ResetCycleDaily, NextTrafficReset: &future})
+
+	reset, err := s.user.ResetTrafficCycles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset != 1 {
+		t.Fatalf("reset = %d, want 1", reset)
+	}
+
+	due, _ := s.users.GetUser("due")
+	if due.TrafficUsed != 0 || !due.IsActive || due.NextTrafficReset == nil || !due.NextTrafficReset.After(time.Now()) {
+		t.Errorf("due user = %+v", due)
+	}
+	later, _ := s.users.GetUser("later")
+	if later.TrafficUsed != 40 {
+		t.Errorf("later user traffic = %d, want 40", later.TrafficUsed)
+	}
+}
+
+func TestDisableExhaustedUsersDuringRenewal(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	const count = 100
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("u%d", i)
+		s.addUser(t, &models.User{ID: id, Username: id, IsActive: true, ExpiresAt: time.Now().Add(-time.Minute), TrafficLimit: 100})
+	}
+
+	// 清理逐个停用用户时同时续期，每个用户都在两者之间交错
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := s.user.DisableExhaustedUsers(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := count - 1; i >= 0; i-- {
+			if _, _, err := s.user.RenewUser(ctx, fmt.Sprintf("u%d", i), &models.RenewUserRequest{DurationDays: 30}, nil); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	// 无论哪一方先执行，续期都不能被停用覆盖
+	for i := 0; i < count; i++ {
+		user, _ := s.users.GetUser(fmt.Sprintf("u%d", i))
+		if !user.IsActive || user.IsExpired() || user.DisabledReason != "" {
+			t.Errorf("%s: active %v, expires %v, reason %q", user.ID, user.IsActive, user.ExpiresAt, user.DisabledReason)
+		}
+	}
+}
+
+func TestDisableExhaustedUsers(t *testing.T) {
+	s := newTestServices(t)
+	expires := time.Now().AddDate(0, 1, 0)
+	tests := []struct {
+		user       *models.User
+		wantReason string
+	}{
+		{&models.User{ID: "expired", Username: "expired", IsActive: true, ExpiresAt: time.Now().Add(-time.Hour), TrafficLimit: 100}, models.DisabledReasonExpired},
+		{&models.User{ID: "traffic", Username: "traffic", IsActive: true, ExpiresAt: expires, TrafficLimit: 100, TrafficUsed: 100}, models.DisabledReasonTraffic},
+		{&models.User{ID: "ok", Username: "ok", IsActive: true, ExpiresAt: expires, TrafficLimit: 100, TrafficUsed: 10}, ""},
+	}
+	for _, tt := range tests {
+		s.addUser(t, tt.user)
+	}
+
+	disabled, err := s.user.DisableExhaustedUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled != 2 {
+		t.Errorf("disabled = %d, want 2", disabled)
+	}
+	for _, tt := range tests {
+		user, _ := s.users.GetUser(tt.user.ID)
+		if user.DisabledReason != tt.wantReason || user.IsActive != (tt.wantReason == "") {
+			t.Errorf("%s: active %v, reason %q", tt.user.ID, user.IsActive, user.DisabledReason)
+		}
+	}
+}
+
+func TestReactivateSuspendedUsers(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	expires := time.Now().AddDate(0, 1, 0)
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		user       *models.User
+		wantActive bool
+	}{
+		{&models.User{ID: "due", Username: "due", ExpiresAt: expires, DisabledReason: models.DisabledReasonSuspended, DisabledUntil: &past}, true},
+		{&models.User{ID: "later", Username: "later", ExpiresAt: expires, DisabledReason: models.DisabledReasonSuspended, DisabledUntil: &future}, false},
+		{&models.User{ID: "manual", Username: "manual", ExpiresAt: expires, DisabledReason: models.DisabledReasonManual, DisabledUntil: &past}, false},
+	}
+	for _, tt := range tests {
+		s.addUser(t, tt.user)
+	}
+
+	reactivated, err := s.user.ReactivateSuspendedUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reactivated != 1 {
+		t.Errorf("reactivated = %d, want 1", reactivated)
+	}
+	for _, tt := range tests {
+		user, _ := s.users.GetUser(tt.user.ID)
+		if user.IsActive != tt.wantActive {
+			t.Errorf("%s: active = %v, want %v", tt.user.ID, user.IsActive, tt.wantActive)
+		}
+	}
+
+	// 停用时返回新的记录，之前读到的用户不变
+	before, _ := s.users.GetUser("due")
+	if err := s.user.SuspendUser(ctx, "due", future); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := s.users.GetUser("due")
+	if !before.IsActive || after.IsActive || after.DisabledReason != models.DisabledReasonSuspended {
+		t.Errorf("before active %v, after active %v reason %q", before.IsActive, after.IsActive, after.DisabledReason)
+	}
+}
+
+func TestFlagSubscription(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", SubscriptionToken: "old", IsActive: true, ExpiresAt: time.Now().AddDate(0, 1, 0)})
+
+	rotated, err := s.user.RotateSubscriptionToken(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		wantFlagged bool
+	}{
+		{"token rotated before flagging", "old", false},
+		{"current token", rotated.SubscriptionToken, true},
+		{"already flagged", rotated.SubscriptionToken, true},
+	}
+	var flaggedAt *time.Time
+	for _, tt := range tests {
+		if err := s.user.FlagSubscription(ctx, "u1", tt.token); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		user, _ := s.users.GetUser("u1")
+		if user.SubscriptionFlagged != tt.wantFlagged {
+			t.Errorf("%s: flagged = %v, want %v", tt.name, user.SubscriptionFlagged, tt.wantFlagged)
+		}
+		if flaggedAt != nil && !user.SubscriptionFlaggedAt.Equal(*flaggedAt) {
+			t.Errorf("%s: flagged again at %v", tt.name, user.SubscriptionFlaggedAt)
+		}
+		flaggedAt = user.SubscriptionFlaggedAt
+	}
+
+	// 轮换返回新的记录，之前读到的用户不变
+	flagged, _ := s.users.GetUser("u1")
+	if _, err := s.user.RotateSubscriptionToken(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if !flagged.SubscriptionFlagged || flagged.SubscriptionToken != rotated.SubscriptionToken {
+		t.Errorf("earlier copy modified: %+v", flagged)
+	}
+}
+
+func TestEnsureSubscriptionTokens(t *testing.T) {
+	s := newTestServices(t)
+	tests := []struct {
+		user      *models.User
+		wantToken string
+	}{
+		{&models.User{ID: "legacy", Username: "legacy"}, ""},
+		{&models.User{ID: "current", Username: "current", SubscriptionToken: "existing"}, "existing"},
+	}
+	for _, tt := range tests {
+		s.addUser(t, tt.user)
+	}
+	legacy, _ := s.users.GetUser("legacy")
+
+	if err := s.user.EnsureSubscriptionTokens(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		user, _ := s.users.GetUser(tt.user.ID)
+		if user.SubscriptionToken == "" || tt.wantToken != "" && user.SubscriptionToken != tt.wantToken {
+			t.Errorf("%s: token = %q", tt.user.ID, user.SubscriptionToken)
+		}
+	}
+	// 补发令牌写入新的记录，之前读到的用户不变
+	if legacy.SubscriptionToken != "" {
+		t.Errorf("earlier copy modified: token = %q", legacy.SubscriptionToken)
+	}
+}
+
+func TestDeviceChangesReturnUpdatedUser(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", IsActive: true, ExpiresAt: time.Now().AddDate(0, 1, 0), TrafficLimit: 100, DeviceLimit: 2,
+		Devices: []models.Device{{ID: "d1", LastSeen: time.Now()}}})
+
+	device, err := s.user.RenameDevice(ctx, "u1", "d1", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Name != "phone" {
+		t.Errorf("renamed device = %+v", device)
+	}
+
+	tests := []struct {
+		name   string
+		run    func() error
+		action string
+	}{
+		{"connect", func() error { return s.user.ConnectDevice(ctx, "u1", "d2") }, AuditUserConnect},
+		{"disconnect", func() error { return s.user.DisconnectDevice(ctx, "u1", "d2") }, AuditUserDisconnect},
+	}
+	for _, tt := range tests {
+		if err := tt.run(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+	}
+
+	// 审计日志记录的是修改后的用户
+	events, err := s.audit.Query(&models.AuditQuery{Target: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := make(map[string]bool)
+	for _, event := range events {
+		_, changed[event.Action] = event.Changes["devices"]
+	}
+	for _, action := range []string{AuditUserDeviceRename, AuditUserConnect, AuditUserDisconnect} {
+		if !changed[action] {
+			t.Errorf("%s: audit entry missing or unchanged", action)
+		}
+	}
+}
+
+func TestUpdateTrafficUsageAudit(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", IsActive: true, ExpiresAt: time.Now().AddDate(0, 1, 0), TrafficLimit: 1000, TrafficUsed: 100})
+
+	if err := s.user.UpdateTrafficUsage(ctx, "u1", 50, "vless-in"); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.audit.Query(&models.AuditQuery{Target: "u1", Action: AuditUserTraffic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("events = %d, want 1", len(events))
+	}
+	change, ok := events[0].Changes["traffic_used"]
+	if !ok || fmt.Sprint(change.Before) != "100" || fmt.Sprint(change.After) != "150" {
+		t.Errorf("traffic change = %+v", events[0].Changes)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// voucherAlphabet 兑换码字符集，去掉了易混淆的I、O、0、1
+const voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// voucherCodeLength 兑换码长度 (不含分隔符)，每个字符5位，共80位随机数
+const voucherCodeLength = 16
+
+// 兑换失败次数限制：同一IP在时间窗口内使用无效的订阅令牌或兑换码过多后暂时拒绝兑换
+const (
+	redeemFailureWindow      = 15 * time.Minute
+	redeemMaxAddressFailures = 10
+)
+
+var (
+	// ErrRedeemInvalid 订阅令牌或兑换码无效，不区分是哪一项，避免被用来探测有效的令牌或兑换码
+	ErrRedeemInvalid = errors.New("invalid subscription token or voucher code")
+	// ErrRedeemLocked 兑换失败次数过多
+	ErrRedeemLocked = errors.New("too many failed redeem attempts, try again later")
+	// ErrVoucherNotFound 兑换码不存在
+	ErrVoucherNotFound = errors.New("voucher not found")
+	// ErrVoucherDisabled 兑换码已停用
+	ErrVoucherDisabled = errors.New("voucher is disabled")
+	// ErrVoucherExpired 兑换码已过期
+	ErrVoucherExpired = errors.New("voucher has expired")
+	// ErrVoucherUsed 兑换码的可兑换次数已用完
+	ErrVoucherUsed = errors.New("voucher has been used")
+	// ErrVoucherAlreadyRedeemed 用户已兑换过该兑换码
+	ErrVoucherAlreadyRedeemed = errors.New("voucher already redeemed by this user")
+	// ErrVoucherNotApplicable 兑换码不适用于该用户
+	ErrVoucherNotApplicable = errors.New("voucher is not applicable to this user")
+)
+
+// VoucherService 兑换码管理服务
+type VoucherService struct {
+	storage      *storage.VoucherStorage
+	planService  *PlanService
+	userService  *UserService
+	adminService *AdminService
+	audit        *AuditService
+
+	// 按客户端IP统计的无效兑换次数
+	addressFailures *attemptLimiter
+}
+
+// NewVoucherService 创建兑换码服务
+func NewVoucherService(storage *storage.VoucherStorage, planService *PlanService, userService *UserService, adminService *AdminService, audit *AuditService) *VoucherService {
+	return &VoucherService{
+		storage:      storage,
+		planService:  planService,
+		userService:  userService,
+		adminService: adminService,
+		audit:        audit,
+
+		addressFailures: newAttemptLimiter(redeemMaxAddressFailures, redeemFailureWindow),
+	}
+}
+
+// CreateVouchers 批量生成兑换码，分销商生成的兑换码归其所有
+func (s *VoucherService) CreateVouchers(ctx context.Context, req *models.CreateVouchersRequest, admin *models.Admin) ([]*models.Voucher, error) {
+	if req.PlanID == "" && req.DurationDays == 0 && req.TrafficBytes == 0 {
+		return nil, fmt.Errorf("plan_id, duration_days or traffic_bytes is required")
+	}
+	if req.PlanID != "" {
+		if _, err := s.planService.GetPlan(req.PlanID); err != nil {
+			return nil, err
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %v", err)
+		}
+		expiresAt = &t
+	}
+
+	maxRedemptions := req.MaxRedemptions
+	if maxRedemptions == 0 {
+		maxRedemptions = 1
+	}
+
+	var ownerID string
+	if admin.Role == models.RoleReseller {
+		ownerID = admin.ID
+	}
+
+	now := time.Now()
+	batchID := uuid.New().String()
+	vouchers := make([]*models.Voucher, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		code, err := generateVoucherCode()
+		if err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, &models.Voucher{
+			Code:           code,
+			BatchID:        batchID,
+			PlanID:         req.PlanID,
+			DurationDays:   req.DurationDays,
+			TrafficBytes:   req.TrafficBytes,
+			MaxRedemptions: maxRedemptions,
+			Redemptions:    []models.VoucherRedemption{},
+			ExpiresAt:      expiresAt,
+			OwnerID:        ownerID,
+			CreatedBy:      admin.Username,
+			CreatedAt:      now,
+			Note:           strings.TrimSpace(req.Note),
+		})
+	}
+
+	if err := s.storage.CreateVouchers(vouchers); err != nil {
+		return nil, err
+	}
+
+	// 审计日志只记录批次信息，不记录兑换码本身
+	s.audit.Record(ctx, AuditVoucherCreate, "voucher_batch", batchID, nil, map[string]interface{}{
+		"count":           len(vouchers),
+		"plan_id":         req.PlanID,
+		"duration_days":   req.DurationDays,
+		"traffic_bytes":   req.TrafficBytes,
+		"max_redemptions": maxRedemptions,
+		"expires_at":      expiresAt,
+		"owner_id":        ownerID,
+	})
+	return vouchers, nil
+}
+
+// GetVoucher 获取兑换码
+func (s *VoucherService) GetVoucher(code string) (*models.Voucher, error) {
+	voucher, err := s.storage.GetVoucher(models.NormalizeVoucherCode(code))
+	if err != nil {
+		return nil, ErrVoucherNotFound
+	}
+	return voucher, nil
+}
+
+// ListVouchers 列出满足条件的兑换码
+func (s *VoucherService) ListVouchers(query models.VoucherQuery) ([]*models.Voucher, error) {
+	now := time.Now()
+	return s.storage.ListVouchers(func(voucher *models.Voucher) bool {
+		return query.Matches(voucher, now)
+	})
+}
+
+// DisableVoucher 停用兑换码
+func (s *VoucherService) DisableVoucher(ctx context.Context, code string) (*models.Voucher, error) {
+	before, err := s.GetVoucher(code)
+	if err != nil {
+		return nil, err
+	}
+
+	voucher, err := s.storage.DisableVoucher(before.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditVoucherDisable, "voucher", voucher.Code, before, voucher)
+	return voucher, nil
+}
+
+// Redeem 用户通过订阅令牌兑换兑换码，已停用的用户也可以兑换以恢复服务
+// 同一IP使用无效的订阅令牌或兑换码过多时暂时拒绝兑换
+func (s *VoucherService) Redeem(ctx context.Context, token, code, ip string) (*models.User, *models.Voucher, error) {
+	now := time.Now()
+	if !s.addressFailures.Allow(ip, now) {
+		return nil, nil, ErrRedeemLocked
+	}
+
+	user, err := s.userService.GetUserBySubscriptionToken(token)
+	if err != nil {
+		s.addressFailures.Fail(ip, now)
+		return nil, nil, ErrRedeemInvalid
+	}
+	// 兑换产生的续期和充值记录以用户本人为操作者
+	ctx = WithActor(ctx, Actor{
+		Type: models.ActorTypeUser,
+		ID:   user.ID,
+		Name: user.Username,
+		IP:   ip,
+	})
+	code = models.NormalizeVoucherCode(code)
+	existing, err := s.storage.GetVoucher(code)
+	if err != nil {
+		s.addressFailures.Fail(ip, now)
+		return nil, nil, ErrRedeemInvalid
+	}
+
+	// 分销商的兑换码增加的流量计入分销商的配额
+	var reseller *models.Admin
+	if existing.OwnerID != "" {
+		if reseller, err = s.adminService.GetAdmin(existing.OwnerID); err != nil {
+			return nil, nil, ErrVoucherNotApplicable
+		}
+	}
+
+	redemption := models.VoucherRedemption{
+		UserID:     user.ID,
+		RedeemedAt: now,
+		IP:         ip,
+	}
+	voucher, err := s.storage.Redeem(code, redemption, func(voucher *models.Voucher) error {
+		return s.checkRedeemable(voucher, user)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redeemed, err := s.apply(ctx, voucher, user.ID, reseller)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.audit.Record(ctx, AuditVoucherRedeem, "voucher", voucher.Code, nil, map[string]interface{}{
+		"user_id":  user.ID,
+		"batch_id": voucher.BatchID,
+	})
+	return redeemed, voucher, nil
+}
+
+// checkRedeemable 检查用户能否兑换该兑换码，在兑换码存储的锁内调用
+func (s *VoucherService) checkRedeemable(voucher *models.Voucher, user *models.User) error {
+	if voucher.RedeemedBy(user.ID) {
+		return ErrVoucherAlreadyRedeemed
+	}
+	switch voucher.Status(time.Now()) {
+	case models.VoucherStatusDisabled:
+		return ErrVoucherDisabled
+	case models.VoucherStatusUsed:
+		return ErrVoucherUsed
+	case models.VoucherStatusExpired:
+		return ErrVoucherExpired
+	}
+	// 分销商的兑换码只能由其名下用户兑换
+	if voucher.OwnerID != "" && user.OwnerID != voucher.OwnerID {
+		return ErrVoucherNotApplicable
+	}
+	return nil
+}
+
+// apply 在一次用户变更中将兑换内容应用到用户，reseller不为nil时检查其配额，失败时撤销兑换记录
+func (s *VoucherService) apply(ctx context.Context, voucher *models.Voucher, userID string, reseller *models.Admin) (*models.User, error) {
+	// 按套餐续期时同时开始新的流量周期
+	user, _, err := s.userService.ApplyVoucher(ctx, userID, &models.RenewUserRequest{
+		DurationDays: voucher.DurationDays,
+		PlanID:       voucher.PlanID,
+		ResetTraffic: voucher.PlanID != "",
+		Note:         "voucher " + voucher.Code,
+	}, voucher.TrafficBytes, reseller)
+	if err != nil {
+		s.cancelRedemption(ctx, voucher.Code, userID)
+		if errors.Is(err, ErrQuotaExceeded) {
+			return nil, fmt.Errorf("%w: %v", ErrVoucherNotApplicable, err)
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// cancelRedemption 撤销兑换记录，失败时只记录日志
+func (s *VoucherService) cancelRedemption(ctx context.Context, code, userID string) {
+	if err := s.storage.CancelRedemption(code, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to cancel voucher redemption", "voucher", code, "user_id", userID, "error", err)
+	}
+}
+
+// generateVoucherCode 生成随机兑换码，格式为XXXX-XXXX-XXXX-XXXX
+func generateVoucherCode() (string, error) {
+	buf := make([]byte, voucherCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate voucher code: %v", err)
+	}
+
+	// 字符集长度为32，取低5位不会产生偏差
+	code := make([]byte, voucherCodeLength)
+	for i, b := range buf {
+		code[i] = voucherAlphabet[int(b)%len(voucherAlphabet)]
+	}
+	return models.NormalizeVoucherCode(string(code)), nil
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+	"sing-box-manager/internal/storage"
+)
+
+// newTestVoucher 生成一个兑换码
+func newTestVoucher(t *testing.T, vouchers *VoucherService, req *models.CreateVouchersRequest, admin *models.Admin) *models.Voucher {
+	t.Helper()
+	req.Count = 1
+	created, err := vouchers.CreateVouchers(context.Background(), req, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created[0]
+}
+
+func TestRedeemVoucher(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	vouchers := NewVoucherService(s.vouchers, s.plan, s.user, s.admin, s.audit)
+	admin := s.addAdmin(t, &models.Admin{ID: "admin", Username: "admin", Role: models.RoleAdmin})
+	reseller := s.addAdmin(t, &models.Admin{ID: "reseller", Username: "reseller", Role: models.RoleReseller, MaxUsers: 10, MaxTraffic: 1000})
+	s.addPlan(t, &models.Plan{ID: "basic", Name: "basic", DurationDays: 30, TrafficLimit: 1000})
+
+	expires := time.Now().AddDate(0, 0, 1)
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", SubscriptionToken: "token-1", IsActive: true, ExpiresAt: expires, TrafficLimit: 100})
+	s.addUser(t, &models.User{ID: "u2", Username: "u2", SubscriptionToken: "token-2", IsActive: true, ExpiresAt: expires, TrafficLimit: 100})
+	s.addUser(t, &models.User{ID: "u3", Username: "u3", SubscriptionToken: "token-3", IsActive: true, ExpiresAt: expires, TrafficLimit: 100, OwnerID: reseller.ID})
+
+	combined := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{PlanID: "basic", TrafficBytes: 500}, admin)
+	traffic := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{TrafficBytes: 50, MaxRedemptions: 2}, admin)
+	disabled := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{DurationDays: 7}, admin)
+	if _, err := vouchers.DisableVoucher(ctx, disabled.Code); err != nil {
+		t.Fatal(err)
+	}
+	owned := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{TrafficBytes: 100}, reseller)
+	tooLarge := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{TrafficBytes: 5000}, reseller)
+
+	tests := []struct {
+		name      string
+		token     string
+		code      string
+		want      error
+		wantLimit int64
+	}{
+		{"unknown token", "missing", combined.Code, ErrRedeemInvalid, 0},
+		{"unknown code", "token-1", "AAAA-BBBB-CCCC-DDDD", ErrRedeemInvalid, 0},
+		{"plan and traffic in one update", "token-1", combined.Code, nil, 1500},
+		{"same user again", "token-1", combined.Code, ErrVoucherAlreadyRedeemed, 0},
+		{"single-use voucher used up", "token-2", combined.Code, ErrVoucherUsed, 0},
+		{"lower-case code with spaces", "token-2", " " + strings.ToLower(traffic.Code) + " ", nil, 150},
+		{"disabled voucher", "token-2", disabled.Code, ErrVoucherDisabled, 0},
+		{"reseller voucher for another owner", "token-1", owned.Code, ErrVoucherNotApplicable, 0},
+		{"reseller voucher for own user", "token-3", owned.Code, nil, 200},
+		{"reseller quota exceeded", "token-3", tooLarge.Code, ErrVoucherNotApplicable, 0},
+	}
+	for _, tt := range tests {
+		user, voucher, err := vouchers.Redeem(ctx, tt.token, tt.code, "10.0.0.1")
+		if tt.want != nil {
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if user.TrafficLimit != tt.wantLimit {
+			t.Errorf("%s: traffic limit = %d, want %d", tt.name, user.TrafficLimit, tt.wantLimit)
+		}
+		entries, err := s.user.ListLedger(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Note != "voucher "+voucher.Code {
+			t.Errorf("%s: ledger = %+v, want one entry for the voucher", tt.name, entries)
+		}
+	}
+
+	// 续期和充值写在同一条账单记录中
+	entries, _ := s.user.ListLedger("u1")
+	if len(entries) != 1 || entries[0].PlanID != "basic" || entries[0].TrafficBytes != 500 || entries[0].DurationDays != 30 {
+		t.Errorf("combined ledger = %+v", entries)
+	}
+}
+
+func TestRedeemVoucherRateLimit(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	vouchers := NewVoucherService(s.vouchers, s.plan, s.user, s.admin, s.audit)
+	admin := s.addAdmin(t, &models.Admin{ID: "admin", Username: "admin", Role: models.RoleAdmin})
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", SubscriptionToken: "token-1", IsActive: true, ExpiresAt: time.Now(), TrafficLimit: 100})
+	voucher := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{TrafficBytes: 50}, admin)
+
+	for i := 0; i < redeemMaxAddressFailures; i++ {
+		if _, _, err := vouchers.Redeem(ctx, "token-1", "AAAA-BBBB-CCCC-DDDD", "10.0.0.1"); !errors.Is(err, ErrRedeemInvalid) {
+			t.Fatalf("attempt %d: err = %v", i, err)
+		}
+	}
+	if _, _, err := vouchers.Redeem(ctx, "token-1", voucher.Code, "10.0.0.1"); !errors.Is(err, ErrRedeemLocked) {
+		t.Errorf("locked address: err = %v, want %v", err, ErrRedeemLocked)
+	}
+	if _, _, err := vouchers.Redeem(ctx, "token-1", voucher.Code, "10.0.0.2"); err != nil {
+		t.Errorf("other address: %v", err)
+	}
+}
+
+func TestRedeemVoucherCancelsOnFailure(t *testing.T) {
+	s := newTestServices(t)
+	ctx := context.Background()
+	admin := s.addAdmin(t, &models.Admin{ID: "admin", Username: "admin", Role: models.RoleAdmin})
+	s.addUser(t, &models.User{ID: "u1", Username: "u1", SubscriptionToken: "token-1", IsActive: true, ExpiresAt: time.Now(), TrafficLimit: 100})
+
+	// 账单路径是目录，用户变更必然失败
+	ledgerPath := filepath.Join(s.dir, "broken-ledger")
+	if err := os.Mkdir(ledgerPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := storage.NewLedgerStorage(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewUserService(s.users, s.plans, ledger, s.audit)
+	vouchers := NewVoucherService(s.vouchers, s.plan, users, s.admin, s.audit)
+	voucher := newTestVoucher(t, vouchers, &models.CreateVouchersRequest{DurationDays: 30, TrafficBytes: 50}, admin)
+
+	if _, _, err := vouchers.Redeem(ctx, "token-1", voucher.Code, "10.0.0.1"); err == nil {
+		t.Fatal("redeem succeeded without a ledger entry")
+	}
+
+	user, _ := s.users.GetUser("u1")
+	if user.TrafficLimit != 100 || user.ExpiresAt.After(time.Now()) {
+		t.Errorf("user changed after failed redeem: %+v", user)
+	}
+	voucher, err = vouchers.GetVoucher(voucher.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(voucher.Redemptions) != 0 {
+		t.Errorf("redemption kept after failed redeem: %+v", voucher.Redemptions)
+	}
+}
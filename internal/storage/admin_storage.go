@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"sing-box-manager/internal/models"
+)
+
+// AdminStorage 管理员账号存储
+type AdminStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	admins   map[string]*models.Admin
+}
+
+// NewAdminStorage 创建管理员存储实例
+func NewAdminStorage(filePath string) (*AdminStorage, error) {
+	storage := &AdminStorage{
+		filePath: filePath,
+		admins:   make(map[string]*models.Admin),
+	}
+
+	if err := readJSONFile(filePath, &storage.admins); err != nil {
+		return nil, fmt.Errorf("failed to load admins: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *AdminStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.admins)
+}
+
+// CreateAdmin 创建管理员
+func (s *AdminStorage) CreateAdmin(admin *models.Admin) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.admins[admin.ID]; exists {
+		return fmt.Errorf("admin with ID %s already exists", admin.ID)
+	}
+	for _, existing := range s.admins {
+		if existing.Username == admin.Username {
+			return fmt.Errorf("admin username %s already exists", admin.Username)
+		}
+	}
+
+	s.admins[admin.ID] = admin
+	return s.saveToFile()
+}
+
+// GetAdmin 获取管理员
+func (s *AdminStorage) GetAdmin(id string) (*models.Admin, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	admin, exists := s.admins[id]
+	if !exists {
+		return nil, fmt.Errorf("admin with ID %s not found", id)
+	}
+
+	return admin, nil
+}
+
+// GetAdminByUsername 根据用户名获取管理员
+func (s *AdminStorage) GetAdminByUsername(username string) (*models.Admin, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, admin := range s.admins {
+		if admin.Username == username {
+			return admin, nil
+		}
+	}
+
+	return nil, fmt.Errorf("admin with username %s not found", username)
+}
+
+// ModifyAdmin 在持有锁时复制管理员记录并由update修改，保存成功后替换原记录
+// 已返回给调用方的记录不会被修改，update返回错误时不做任何修改
+func (s *AdminStorage) ModifyAdmin(id string, update func(admin *models.Admin) error) (*models.Admin, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, exists := s.admins[id]
+	if !exists {
+		return nil, fmt.Errorf("admin with ID %s not found", id)
+	}
+
+	admin := *existing
+	if err := update(&admin); err != nil {
+		return nil, err
+	}
+
+	s.admins[id] = &admin
+	if err := s.saveToFile(); err != nil {
+		s.admins[id] = existing
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// DeleteAdmin 删除管理员
+func (s *AdminStorage) DeleteAdmin(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.admins[id]; !exists {
+		return fmt.Errorf("admin with ID %s not found", id)
+	}
+
+	delete(s.admins, id)
+	return s.saveToFile()
+}
+
+// ListAdmins 列出所有管理员
+func (s *AdminStorage) ListAdmins() ([]*models.Admin, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	admins := make([]*models.Admin, 0, len(s.admins))
+	for _, admin := range s.admins {
+		admins = append(admins, admin)
+	}
+
+	return admins, nil
+}
+
+// Count 返回管理员数量
+func (s *AdminStorage) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.admins)
+}
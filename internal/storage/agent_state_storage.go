@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"sing-box-manager/internal/models"
+)
+
+// agentState agent模式需要跨重启保留的同步状态
+type agentState struct {
+	// 最近一次同步时中心管理端记录的各用户已用流量
+	TrafficBaseline map[string]int64 `json:"traffic_baseline"`
+	// 已发送但未确认的流量上报，下次同步时原样重发，中心管理端按上报ID去重
+	PendingReport *models.TrafficReport `json:"pending_report,omitempty"`
+}
+
+// AgentStateStorage agent同步状态存储
+type AgentStateStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	state    agentState
+}
+
+// NewAgentStateStorage 创建agent同步状态存储实例
+func NewAgentStateStorage(filePath string) (*AgentStateStorage, error) {
+	storage := &AgentStateStorage{
+		filePath: filePath,
+		state:    agentState{TrafficBaseline: make(map[string]int64)},
+	}
+
+	if err := readJSONFile(filePath, &storage.state); err != nil {
+		return nil, fmt.Errorf("failed to load agent state: %v", err)
+	}
+	if storage.state.TrafficBaseline == nil {
+		storage.state.TrafficBaseline = make(map[string]int64)
+	}
+
+	return storage, nil
+}
+
+// TrafficBaseline 返回流量基线的副本
+func (s *AgentStateStorage) TrafficBaseline() map[string]int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	baseline := make(map[string]int64, len(s.state.TrafficBaseline))
+	for id, used := range s.state.TrafficBaseline {
+		baseline[id] = used
+	}
+	return baseline
+}
+
+// SetTrafficBaseline 保存流量基线
+func (s *AgentStateStorage) SetTrafficBaseline(baseline map[string]int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.TrafficBaseline = baseline
+	return writeJSONFile(s.filePath, s.state)
+}
+
+// PendingReport 返回未确认的流量上报，没有时返回nil
+func (s *AgentStateStorage) PendingReport() *models.TrafficReport {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.state.PendingReport
+}
+
+// SetPendingReport 在发送前保存流量上报，发送结果未知时可以原样重发
+func (s *AgentStateStorage) SetPendingReport(report *models.TrafficReport) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.PendingReport = report
+	return writeJSONFile(s.filePath, s.state)
+}
+
+// CompleteReport 上报被确认后，同时保存新的流量基线并清除未确认的上报
+func (s *AgentStateStorage) CompleteReport(baseline map[string]int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state.TrafficBaseline = baseline
+	s.state.PendingReport = nil
+	return writeJSONFile(s.filePath, s.state)
+}
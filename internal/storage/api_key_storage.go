@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// lastUsedPersistInterval 最近使用时间的落盘间隔，避免每个请求都写文件
+const lastUsedPersistInterval = time.Minute
+
+// APIKeyStorage API密钥存储
+type APIKeyStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	keys     map[string]*models.APIKey
+
+	// 最近一次落盘时间
+	savedAt time.Time
+}
+
+// NewAPIKeyStorage 创建API密钥存储实例
+func NewAPIKeyStorage(filePath string) (*APIKeyStorage, error) {
+	storage := &APIKeyStorage{
+		filePath: filePath,
+		keys:     make(map[string]*models.APIKey),
+	}
+
+	if err := readJSONFile(filePath, &storage.keys); err != nil {
+		return nil, fmt.Errorf("failed to load api keys: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *APIKeyStorage) saveToFile() error {
+	if err := writeJSONFile(s.filePath, s.keys); err != nil {
+		return err
+	}
+	s.savedAt = time.Now()
+	return nil
+}
+
+// CreateAPIKey 创建API密钥
+func (s *APIKeyStorage) CreateAPIKey(key *models.APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("api key with ID %s already exists", key.ID)
+	}
+
+	s.keys[key.ID] = key
+	return s.saveToFile()
+}
+
+// GetAPIKey 获取API密钥
+func (s *APIKeyStorage) GetAPIKey(id string) (*models.APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, exists := s.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("api key with ID %s not found", id)
+	}
+
+	return key, nil
+}
+
+// GetAPIKeyByHash 根据密钥哈希获取API密钥
+func (s *APIKeyStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, key := range s.keys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("api key not found")
+}
+
+// ListAPIKeys 列出所有API密钥
+func (s *APIKeyStorage) ListAPIKeys() ([]*models.APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]*models.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Count 返回API密钥数量
+func (s *APIKeyStorage) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.keys)
+}
+
+// RevokeAPIKey 吊销API密钥
+func (s *APIKeyStorage) RevokeAPIKey(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[id]
+	if !exists {
+		return fmt.Errorf("api key with ID %s not found", id)
+	}
+
+	updated := *key
+	updated.Revoked = true
+	s.keys[id] = &updated
+	if err := s.saveToFile(); err != nil {
+		s.keys[id] = key
+		return err
+	}
+	return nil
+}
+
+// TouchAPIKey 更新密钥最近使用时间
+// 替换为新的记录而不修改原记录，已返回给调用方的密钥不会在读取时被并发修改
+func (s *APIKeyStorage) TouchAPIKey(id string, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.keys[id]
+	if !exists {
+		return fmt.Errorf("api key with ID %s not found", id)
+	}
+
+	updated := *key
+	updated.LastUsedAt = &usedAt
+	s.keys[id] = &updated
+	if usedAt.Sub(s.savedAt) < lastUsedPersistInterval {
+		return nil
+	}
+
+	return s.saveToFile()
+}
+
+// BindUnownedAPIKeys 将未绑定管理员的密钥绑定到指定管理员，返回绑定数量
+func (s *APIKeyStorage) BindUnownedAPIKeys(adminID string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := make(map[string]*models.APIKey)
+	for id, key := range s.keys {
+		if key.AdminID == "" {
+			updated := *key
+			updated.AdminID = adminID
+			previous[id] = key
+			s.keys[id] = &updated
+		}
+	}
+
+	if len(previous) == 0 {
+		return 0, nil
+	}
+	if err := s.saveToFile(); err != nil {
+		for id, key := range previous {
+			s.keys[id] = key
+		}
+		return 0, err
+	}
+	return len(previous), nil
+}
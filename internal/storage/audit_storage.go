@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sing-box-manager/internal/models"
+)
+
+// AuditStorage 审计日志存储，以JSON Lines格式只追加写入
+type AuditStorage struct {
+	file *jsonlFile
+}
+
+// NewAuditStorage 创建审计日志存储实例
+func NewAuditStorage(filePath string) (*AuditStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+
+	return &AuditStorage{
+		file: newJSONLFile(filePath, true),
+	}, nil
+}
+
+// Append 追加一条审计事件，写入后同步到磁盘
+func (s *AuditStorage) Append(event *models.AuditEvent) error {
+	return s.file.append(event)
+}
+
+// Scan 按写入顺序遍历所有审计事件，fn返回false时停止
+// 遍历期间追加的事件不会被读到，慢速的读取方不会阻塞写入
+func (s *AuditStorage) Scan(fn func(event *models.AuditEvent) bool) error {
+	return s.file.scan(func(line []byte) (bool, error) {
+		var event models.AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return false, fmt.Errorf("corrupted audit log entry: %v", err)
+		}
+		return fn(&event), nil
+	})
+}
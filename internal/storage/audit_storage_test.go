@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestAuditStorageScanDoesNotBlockAppend(t *testing.T) {
+	s, err := NewAuditStorage(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range []string{"a", "b"} {
+		if err := s.Append(&models.AuditEvent{ID: action, Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 遍历过程中追加事件，模拟导出时的并发写入
+	var seen []string
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Scan(func(event *models.AuditEvent) bool {
+			seen = append(seen, event.Action)
+			return s.Append(&models.AuditEvent{ID: "c", Action: "c"}) == nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Append blocked while Scan was running")
+	}
+
+	// 遍历只读取开始时已有的事件
+	if len(seen) != 2 || seen[0] != "a" || seen[1] != "b" {
+		t.Fatalf("seen = %v, want [a b]", seen)
+	}
+
+	var total int
+	if err := s.Scan(func(*models.AuditEvent) bool { total++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Fatalf("total = %d, want 4", total)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sing-box-manager/internal/metrics"
+)
+
+// readJSONFile 从文件读取JSON数据到v，文件不存在时写入v的当前值创建文件
+func readJSONFile(filePath string, v interface{}) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return writeJSONFile(filePath, v)
+		}
+		return err
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile 将v以JSON格式写入文件
+func writeJSONFile(filePath string, v interface{}) error {
+	defer metrics.ObserveStorageWrite(filepath.Base(filePath), time.Now())
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filePath, data, 0600)
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"path/filepath"
 	"time"
 
+	"sing-box-manager/internal/metrics"
 	"sing-box-manager/internal/models"
 )
 
@@ -15,6 +17,10 @@ type JSONStorage struct {
 	filePath string
 	mutex    sync.RWMutex
 	users    map[string]*models.User
+	loadErr  error
+	
+	// 入站标签 -> 尚未上报的流量，只保存在内存中
+	inboundTraffic map[string]int64
 }
 
 // NewJSONStorage 创建JSON存储实例
@@ -25,10 +31,7 @@ func NewJSONStorage(filePath string) *JSONStorage {
 	}
 	
 	// 加载现有数据
-	storage.loadFromFile()
-	
-	// 启动自动清理过期用户
-	go storage.startCleanupJob()
+	storage.loadErr = storage.loadFromFile()
 	
 	return storage
 }
@@ -54,8 +57,18 @@ func (s *JSONStorage) loadFromFile() error {
 	return json.Unmarshal(data, &s.users)
 }
 
+// LoadError 返回初始加载数据文件时的错误，nil表示加载成功
+func (s *JSONStorage) LoadError() error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	
+	return s.loadErr
+}
+
 // saveToFile 保存数据到文件
 func (s *JSONStorage) saveToFile() error {
+	defer metrics.ObserveStorageWrite(filepath.Base(s.filePath), time.Now())
+	
 	data, err := json.MarshalIndent(s.users, "", "  ")
 	if err != nil {
 		return err
@@ -66,6 +79,15 @@ func (s *JSONStorage) saveToFile() error {
 
 // CreateUser 创建用户
 func (s *JSONStorage) CreateUser(user *models.User) error {
+	return s.CreateUserChecked(user, nil)
+}
+
+// UserCheck 在持有锁时检查用户变更，返回错误时放弃变更，用于检查分销商配额，并发的变更不会同时通过检查
+// before为变更前的用户(创建时为nil)，owned为变更后同一分销商名下的所有用户(含after)
+type UserCheck func(before, after *models.User, owned []*models.User) error
+
+// CreateUserChecked 创建用户，check返回错误时不创建
+func (s *JSONStorage) CreateUserChecked(user *models.User, check UserCheck) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
@@ -73,8 +95,18 @@ func (s *JSONStorage) CreateUser(user *models.User) error {
 		return fmt.Errorf("user with ID %s already exists", user.ID)
 	}
 	
+	if check != nil {
+		if err := check(nil, user, append(s.ownedUsers(user.OwnerID, ""), user)); err != nil {
+			return err
+		}
+	}
+	
 	s.users[user.ID] = user
-	return s.saveToFile()
+	if err := s.saveToFile(); err != nil {
+		delete(s.users, user.ID)
+		return err
+	}
+	return nil
 }
 
 // GetUser 获取用户
@@ -104,6 +136,24 @@ func (s *JSONStorage) GetUserByUsername(username string) (*models.User, error) {
 	return nil, fmt.Errorf("user with username %s not found", username)
 }
 
+// GetUserBySubscriptionToken 根据订阅令牌获取用户
+func (s *JSONStorage) GetUserBySubscriptionToken(token string) (*models.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	
+	if token == "" {
+		return nil, fmt.Errorf("subscription not found")
+	}
+	
+	for _, user := range s.users {
+		if user.SubscriptionToken == token {
+			return user, nil
+		}
+	}
+	
+	return nil, fmt.Errorf("subscription not found")
+}
+
 // UpdateUser 更新用户
 func (s *JSONStorage) UpdateUser(id string, user *models.User) error {
 	s.mutex.Lock()
@@ -117,6 +167,54 @@ func (s *JSONStorage) UpdateUser(id string, user *models.User) error {
 	return s.saveToFile()
 }
 
+// ModifyUser 在持有锁时复制用户并由update修改，保存成功后替换原记录，返回修改后的用户
+// 已返回给调用方的用户不会被修改；update返回错误或保存失败时不做任何修改
+func (s *JSONStorage) ModifyUser(id string, update func(user *models.User) error) (*models.User, error) {
+	return s.ModifyUserChecked(id, update, nil)
+}
+
+// ModifyUserChecked 与ModifyUser相同，修改后由check检查，返回错误时不做修改
+func (s *JSONStorage) ModifyUserChecked(id string, update func(user *models.User) error, check UserCheck) (*models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	existing, exists := s.users[id]
+	if !exists {
+		return nil, fmt.Errorf("user with ID %s not found", id)
+	}
+	
+	user := existing.Clone()
+	if err := update(user); err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(existing, user, append(s.ownedUsers(user.OwnerID, id), user)); err != nil {
+			return nil, err
+		}
+	}
+	
+	s.users[id] = user
+	if err := s.saveToFile(); err != nil {
+		s.users[id] = existing
+		return nil, err
+	}
+	return user, nil
+}
+
+// ownedUsers 返回分销商名下除exclude外的用户，ownerID为空时返回空，调用方需持有锁
+func (s *JSONStorage) ownedUsers(ownerID, exclude string) []*models.User {
+	owned := make([]*models.User, 0)
+	if ownerID == "" {
+		return owned
+	}
+	for id, user := range s.users {
+		if id != exclude && user.OwnerID == ownerID {
+			owned = append(owned, user)
+		}
+	}
+	return owned
+}
+
 // DeleteUser 删除用户
 func (s *JSONStorage) DeleteUser(id string) error {
 	s.mutex.Lock()
@@ -143,49 +241,127 @@ func (s *JSONStorage) ListUsers() ([]*models.User, error) {
 	return users, nil
 }
 
-// AddConnectedDevice 添加连接设备
-func (s *JSONStorage) AddConnectedDevice(userID, deviceID string) error {
+// ReplaceUsers 用中心管理端下发的用户集合替换本地用户
+// baseline为上次同步时各用户的已用流量，本地在此之后新增且尚未上报的流量会累加到下发的用量上
+func (s *JSONStorage) ReplaceUsers(users []*models.User, baseline map[string]int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	replaced := make(map[string]*models.User, len(users))
+	for _, user := range users {
+		if local, exists := s.users[user.ID]; exists {
+			if unreported := local.TrafficUsed - baseline[user.ID]; unreported > 0 {
+				user.TrafficUsed += unreported
+			}
+		}
+		replaced[user.ID] = user
+	}
+	
+	s.users = replaced
+	return s.saveToFile()
+}
+
+// AddConnectedDevice 添加连接设备，返回修改后的用户
+func (s *JSONStorage) AddConnectedDevice(userID, deviceID string) (*models.User, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
 	user, exists := s.users[userID]
 	if !exists {
-		return fmt.Errorf("user with ID %s not found", userID)
+		return nil, fmt.Errorf("user with ID %s not found", userID)
 	}
 	
 	// 检查设备是否已连接
-	for _, device := range user.ConnectedDevices {
-		if device == deviceID {
-			return nil // 已连接
-		}
+	if user.FindDevice(deviceID) >= 0 {
+		return user, nil // 已连接
 	}
 	
-	user.ConnectedDevices = append(user.ConnectedDevices, deviceID)
-	return s.saveToFile()
+	now := time.Now()
+	updated := user.Clone()
+	updated.Devices = append(updated.Devices, models.Device{ID: deviceID, FirstSeen: now, LastSeen: now})
+	if err := s.swapUsers(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
 }
 
-// RemoveConnectedDevice 移除连接设备
-func (s *JSONStorage) RemoveConnectedDevice(userID, deviceID string) error {
+// RemoveConnectedDevice 移除连接设备，返回修改后的用户
+func (s *JSONStorage) RemoveConnectedDevice(userID, deviceID string) (*models.User, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
 	user, exists := s.users[userID]
 	if !exists {
-		return fmt.Errorf("user with ID %s not found", userID)
+		return nil, fmt.Errorf("user with ID %s not found", userID)
+	}
+	
+	i := user.FindDevice(deviceID)
+	if i < 0 {
+		return user, nil
 	}
 	
-	for i, device := range user.ConnectedDevices {
-		if device == deviceID {
-			user.ConnectedDevices = append(user.ConnectedDevices[:i], user.ConnectedDevices[i+1:]...)
-			break
+	updated := user.Clone()
+	updated.Devices = append(updated.Devices[:i], updated.Devices[i+1:]...)
+	if err := s.swapUsers(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// RevokeDevice 移除设备并在until之前禁止其重新加入设备列表，同时清理已过期的禁止记录，返回修改后的用户
+func (s *JSONStorage) RevokeDevice(userID, deviceID string, until time.Time) (*models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("user with ID %s not found", userID)
+	}
+	
+	updated := user.Clone()
+	if i := updated.FindDevice(deviceID); i >= 0 {
+		updated.Devices = append(updated.Devices[:i], updated.Devices[i+1:]...)
+	}
+	now := time.Now()
+	revoked := make(map[string]time.Time, len(updated.RevokedDevices)+1)
+	for id, revokedUntil := range updated.RevokedDevices {
+		if now.Before(revokedUntil) {
+			revoked[id] = revokedUntil
 		}
 	}
+	revoked[deviceID] = until
+	updated.RevokedDevices = revoked
+	if err := s.swapUsers(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// RenameDevice 修改设备名称，返回修改后的用户
+func (s *JSONStorage) RenameDevice(userID, deviceID, name string) (*models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	
-	return s.saveToFile()
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("user with ID %s not found", userID)
+	}
+	
+	i := user.FindDevice(deviceID)
+	if i < 0 {
+		return nil, fmt.Errorf("device %s not found", deviceID)
+	}
+	
+	updated := user.Clone()
+	updated.Devices[i].Name = name
+	if err := s.swapUsers(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
 }
 
-// UpdateTrafficUsage 更新流量使用
-func (s *JSONStorage) UpdateTrafficUsage(userID string, bytesUsed int64) error {
+// UpdateDeviceClient 记录设备使用的客户端，设备不存在时不做处理
+func (s *JSONStorage) UpdateDeviceClient(userID, deviceID, userAgent string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
@@ -194,38 +370,221 @@ func (s *JSONStorage) UpdateTrafficUsage(userID string, bytesUsed int64) error {
 		return fmt.Errorf("user with ID %s not found", userID)
 	}
 	
-	user.TrafficUsed += bytesUsed
-	return s.saveToFile()
+	i := user.FindDevice(deviceID)
+	if i < 0 || user.Devices[i].UserAgent == userAgent {
+		return nil
+	}
+	
+	updated := user.Clone()
+	updated.Devices[i].UserAgent = userAgent
+	updated.Devices[i].ClientApp = models.ClientAppFromUserAgent(userAgent)
+	return s.swapUsers(updated)
 }
 
-// startCleanupJob 启动自动清理任务
-func (s *JSONStorage) startCleanupJob() {
-	ticker := time.NewTicker(1 * time.Hour) // 每小时检查一次
-	defer ticker.Stop()
+// TouchDevices 记录设备活动，未连接过的设备会加入连接设备列表，不存在的用户和已移除的设备忽略
+// 只有设备信息实际变化的用户才会被替换，没有变化时不写文件
+func (s *JSONStorage) TouchDevices(activity models.DeviceActivity) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	now := time.Now()
+	updated := make([]*models.User, 0)
+	for userID, sightings := range activity {
+		user, exists := s.users[userID]
+		if !exists {
+			continue
+		}
+		
+		devices := copyDevices(user.Devices)
+		changed := false
+		for deviceID, sighting := range sightings {
+			if user.IsDeviceRevoked(deviceID, now) {
+				continue
+			}
+			i := user.FindDevice(deviceID)
+			if i < 0 {
+				devices = append(devices, models.Device{ID: deviceID, FirstSeen: sighting.LastSeen})
+				i = len(devices) - 1
+				changed = true
+			}
+			
+			device := &devices[i]
+			if sighting.LastSeen.After(device.LastSeen) {
+				device.LastSeen = sighting.LastSeen
+				if sighting.IP != "" {
+					device.LastIP = sighting.IP
+				}
+				changed = true
+			}
+			if sighting.UserAgent != "" && sighting.UserAgent != device.UserAgent {
+				device.UserAgent = sighting.UserAgent
+				device.ClientApp = models.ClientAppFromUserAgent(sighting.UserAgent)
+				changed = true
+			}
+		}
+		if changed {
+			clone := user.Clone()
+			clone.Devices = devices
+			updated = append(updated, clone)
+		}
+	}
 	
-	for range ticker.C {
-		s.cleanupExpiredUsers()
+	if len(updated) == 0 {
+		return nil
 	}
+	return s.swapUsers(updated...)
 }
 
-// cleanupExpiredUsers 清理过期用户
-func (s *JSONStorage) cleanupExpiredUsers() {
+// DeviceActivitySince 返回since之后有活动的设备
+func (s *JSONStorage) DeviceActivitySince(since time.Time) models.DeviceActivity {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	
+	activity := make(models.DeviceActivity)
+	for _, user := range s.users {
+		for _, device := range user.Devices {
+			if device.LastSeen.After(since) {
+				activity.Record(user.ID, device.ID, models.DeviceSighting{
+					LastSeen:  device.LastSeen,
+					IP:        device.LastIP,
+					UserAgent: device.UserAgent,
+				})
+			}
+		}
+	}
+	return activity
+}
+
+// ExpireIdleDevices 移除最近活动时间早于cutoff的设备，返回被移除的设备数
+// 没有活动时间的旧数据从本次开始计时
+func (s *JSONStorage) ExpireIdleDevices(cutoff time.Time) (int, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
-	var expiredUsers []string
-	for id, user := range s.users {
-		if user.IsExpired() {
-			expiredUsers = append(expiredUsers, id)
+	now := time.Now()
+	updated := make([]*models.User, 0)
+	removed := 0
+	for _, user := range s.users {
+		if len(user.Devices) == 0 {
+			continue
+		}
+		
+		changed := false
+		kept := make([]models.Device, 0, len(user.Devices))
+		for _, device := range user.Devices {
+			if device.LastSeen.IsZero() {
+				device.LastSeen = now
+				changed = true
+			} else if device.LastSeen.Before(cutoff) {
+				removed++
+				changed = true
+				continue
+			}
+			kept = append(kept, device)
+		}
+		if changed {
+			clone := user.Clone()
+			clone.Devices = kept
+			updated = append(updated, clone)
 		}
 	}
 	
-	for _, id := range expiredUsers {
-		delete(s.users, id)
+	if len(updated) == 0 {
+		return 0, nil
 	}
+	if err := s.swapUsers(updated...); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// swapUsers 用修改后的副本替换原记录并保存，保存失败时恢复原记录，调用方需持有锁
+// 已返回给调用方的用户不会被修改
+func (s *JSONStorage) swapUsers(users ...*models.User) error {
+	previous := make([]*models.User, len(users))
+	for i, user := range users {
+		previous[i] = s.users[user.ID]
+		s.users[user.ID] = user
+	}
+	
+	if err := s.saveToFile(); err != nil {
+		for _, user := range previous {
+			s.users[user.ID] = user
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateTrafficUsage 更新流量使用，inbound非空时同时累加该入站的流量，返回修改后的用户
+func (s *JSONStorage) UpdateTrafficUsage(userID string, bytesUsed int64, inbound string) (*models.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, fmt.Errorf("user with ID %s not found", userID)
+	}
+	
+	updated := user.Clone()
+	updated.TrafficUsed += bytesUsed
+	if err := s.swapUsers(updated); err != nil {
+		return nil, err
+	}
+	
+	if inbound != "" {
+		if s.inboundTraffic == nil {
+			s.inboundTraffic = make(map[string]int64)
+		}
+		s.inboundTraffic[inbound] += bytesUsed
+	}
+	return updated, nil
+}
+
+// TakeInboundTraffic 返回上次调用后各入站累加的流量并清零，agent用于随流量上报一起发送
+func (s *JSONStorage) TakeInboundTraffic() map[string]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	
-	if len(expiredUsers) > 0 {
-		s.saveToFile()
-		fmt.Printf("Cleaned up %d expired users\n", len(expiredUsers))
+	traffic := s.inboundTraffic
+	s.inboundTraffic = nil
+	return traffic
+}
+
+// ApplyTrafficDeltas 批量累加多个用户的已用流量并一次性写入文件，不存在的用户(如已被删除)会被忽略，返回实际累加的流量
+func (s *JSONStorage) ApplyTrafficDeltas(deltas map[string]int64) (map[string]int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	
+	applied := make(map[string]int64, len(deltas))
+	previous := make(map[string]*models.User, len(deltas))
+	for userID, bytesUsed := range deltas {
+		user, exists := s.users[userID]
+		if !exists || bytesUsed <= 0 {
+			continue
+		}
+		updated := *user
+		updated.TrafficUsed += bytesUsed
+		previous[userID] = user
+		s.users[userID] = &updated
+		applied[userID] = bytesUsed
+	}
+	if len(applied) == 0 {
+		return applied, nil
 	}
-}
\ No newline at end of file
+	
+	if err := s.saveToFile(); err != nil {
+		for userID, user := range previous {
+			s.users[userID] = user
+		}
+		return nil, err
+	}
+	return applied, nil
+}
+
+// copyDevices 复制设备列表，修改副本后整体替换，避免与正在读取用户数据的请求并发读写
+func copyDevices(devices []models.Device) []models.Device {
+	copied := make([]models.Device, len(devices), len(devices)+1)
+	copy(copied, devices)
+	return copied
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func newTestJSONStorage(t *testing.T, users ...*models.User) *JSONStorage {
+	t.Helper()
+	s := NewJSONStorage(filepath.Join(t.TempDir(), "users.json"))
+	if err := s.LoadError(); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		if err := s.CreateUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestApplyTrafficDeltas(t *testing.T) {
+	s := newTestJSONStorage(t,
+		&models.User{ID: "a", Username: "a", TrafficUsed: 100},
+		&models.User{ID: "b", Username: "b"},
+	)
+	before, _ := s.GetUser("a")
+
+	applied, err := s.ApplyTrafficDeltas(map[string]int64{"a": 50, "b": 7, "gone": 10, "zero": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied["a"] != 50 || applied["b"] != 7 {
+		t.Fatalf("applied = %v", applied)
+	}
+
+	a, _ := s.GetUser("a")
+	b, _ := s.GetUser("b")
+	if a.TrafficUsed != 150 || b.TrafficUsed != 7 {
+		t.Fatalf("traffic = %d, %d", a.TrafficUsed, b.TrafficUsed)
+	}
+	// 已返回给调用方的用户不会被修改
+	if before.TrafficUsed != 100 {
+		t.Fatalf("previously returned user was mutated: %d", before.TrafficUsed)
+	}
+
+	// 重新加载后数据一致
+	reloaded := NewJSONStorage(s.filePath)
+	if u, err := reloaded.GetUser("a"); err != nil || u.TrafficUsed != 150 {
+		t.Fatalf("reloaded user = %v, %v", u, err)
+	}
+}
+
+func TestDeviceUpdatesAreCopyOnWrite(t *testing.T) {
+	seen := time.Now().Add(-time.Minute)
+	newUser := func() *models.User {
+		return &models.User{ID: "u", Username: "u", Devices: []models.Device{{ID: "d1", FirstSeen: seen, LastSeen: seen}}}
+	}
+	tests := []struct {
+		name   string
+		update func(s *JSONStorage) error
+		check  func(user *models.User) bool
+	}{
+		{"add device", func(s *JSONStorage) error {
+			_, err := s.AddConnectedDevice("u", "d2")
+			return err
+		}, func(u *models.User) bool { return len(u.Devices) == 2 }},
+		{"remove device", func(s *JSONStorage) error {
+			_, err := s.RemoveConnectedDevice("u", "d1")
+			return err
+		}, func(u *models.User) bool { return len(u.Devices) == 0 }},
+		{"revoke device", func(s *JSONStorage) error {
+			_, err := s.RevokeDevice("u", "d1", time.Now().Add(time.Hour))
+			return err
+		}, func(u *models.User) bool { return len(u.Devices) == 0 && len(u.RevokedDevices) == 1 }},
+		{"rename device", func(s *JSONStorage) error {
+			_, err := s.RenameDevice("u", "d1", "phone")
+			return err
+		}, func(u *models.User) bool { return u.Devices[0].Name == "phone" }},
+		{"update client", func(s *JSONStorage) error { return s.UpdateDeviceClient("u", "d1", "sing-box 1.10") }, func(u *models.User) bool { return u.Devices[0].UserAgent == "sing-box 1.10" }},
+		{"touch devices", func(s *JSONStorage) error {
+			activity := make(models.DeviceActivity)
+			activity.Record("u", "d1", models.DeviceSighting{LastSeen: time.Now(), IP: "10.0.0.1"})
+			return s.TouchDevices(activity)
+		}, func(u *models.User) bool { return u.Devices[0].LastIP == "10.0.0.1" }},
+		{"expire idle devices", func(s *JSONStorage) error {
+			_, err := s.ExpireIdleDevices(time.Now())
+			return err
+		}, func(u *models.User) bool { return len(u.Devices) == 0 }},
+	}
+	for _, tt := range tests {
+		s := newTestJSONStorage(t, newUser())
+		before, _ := s.GetUser("u")
+		original := before.Clone()
+
+		// 保存失败时不做任何修改
+		if err := os.Remove(s.filePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(s.filePath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.update(s); err == nil {
+			t.Errorf("%s: save into a directory succeeded", tt.name)
+		}
+		if current, _ := s.GetUser("u"); current != before {
+			t.Errorf("%s: user replaced after failed save", tt.name)
+		}
+
+		if err := os.Remove(s.filePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.update(s); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		after, _ := s.GetUser("u")
+		if !tt.check(after) {
+			t.Errorf("%s: update not applied: %+v", tt.name, after)
+		}
+		// 已返回给调用方的用户不会被修改
+		if len(before.Devices) != len(original.Devices) || before.Devices[0] != original.Devices[0] || len(before.RevokedDevices) != 0 {
+			t.Errorf("%s: previously returned user was mutated: %+v", tt.name, before)
+		}
+	}
+}
+
+func TestTouchDevicesSkipsUnchangedSightings(t *testing.T) {
+	s := newTestJSONStorage(t, &models.User{ID: "u", Username: "u"})
+	seen := time.Now()
+	activity := make(models.DeviceActivity)
+	activity.Record("u", "d1", models.DeviceSighting{LastSeen: seen, IP: "10.0.0.1", UserAgent: "sing-box 1.10"})
+
+	tests := []struct {
+		name      string
+		activity  models.DeviceActivity
+		wantWrite bool
+	}{
+		{"new device", activity, true},
+		{"same sighting again", activity, false},
+		{"unknown user", models.DeviceActivity{"missing": {"d1": {LastSeen: time.Now()}}}, false},
+		{"newer sighting", models.DeviceActivity{"u": {"d1": {LastSeen: seen.Add(time.Second)}}}, true},
+	}
+	for _, tt := range tests {
+		// 删除文件后只有写入才会重新创建
+		if err := os.Remove(s.filePath); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.TouchDevices(tt.activity); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		_, err := os.Stat(s.filePath)
+		if written := err == nil; written != tt.wantWrite {
+			t.Errorf("%s: written = %v, want %v", tt.name, written, tt.wantWrite)
+		}
+		if err != nil {
+			if err := s.saveToFile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestUpdateTrafficUsage(t *testing.T) {
+	s := newTestJSONStorage(t, &models.User{ID: "a", Username: "a", TrafficUsed: 100})
+	before, _ := s.GetUser("a")
+
+	// 保存失败时不计入流量，重试不会重复计算
+	if err := os.Remove(s.filePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.filePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateTrafficUsage("a", 50, "vless-in"); err == nil {
+		t.Fatal("save into a directory succeeded")
+	}
+	if err := os.Remove(s.filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		bytes   int64
+		inbound string
+		wantErr bool
+		want    int64
+	}{
+		{"retried report", "a", 50, "vless-in", false, 150},
+		{"untagged report", "a", 10, "", false, 160},
+		{"unknown user", "missing", 10, "vless-in", true, 160},
+	}
+	for _, tt := range tests {
+		if _, err := s.UpdateTrafficUsage(tt.userID, tt.bytes, tt.inbound); (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v", tt.name, err)
+		}
+		if a, _ := s.GetUser("a"); a.TrafficUsed != tt.want {
+			t.Errorf("%s: traffic = %d, want %d", tt.name, a.TrafficUsed, tt.want)
+		}
+	}
+
+	if before.TrafficUsed != 100 {
+		t.Errorf("previously returned user was mutated: %d", before.TrafficUsed)
+	}
+	if inbounds := s.TakeInboundTraffic(); len(inbounds) != 1 || inbounds["vless-in"] != 50 {
+		t.Errorf("inbound traffic = %v", inbounds)
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/metrics"
+)
+
+// jsonlMaxLineSize 单条记录的最大长度，超过时读取报错
+const jsonlMaxLineSize = 4 * 1024 * 1024
+
+// jsonlFile 以JSON Lines格式只追加写入的文件，审计日志、账单和订阅拉取日志共用
+type jsonlFile struct {
+	filePath string
+	// 写入后同步到磁盘，返回时记录已持久化
+	durable bool
+	mutex   sync.Mutex
+}
+
+// newJSONLFile 创建JSON Lines文件，目录需已存在
+func newJSONLFile(filePath string, durable bool) *jsonlFile {
+	return &jsonlFile{
+		filePath: filePath,
+		durable:  durable,
+	}
+}
+
+// append 追加一条记录
+func (f *jsonlFile) append(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	defer metrics.ObserveStorageWrite(filepath.Base(f.filePath), time.Now())
+
+	file, err := os.OpenFile(f.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if f.durable {
+		return file.Sync()
+	}
+	return nil
+}
+
+// scan 按写入顺序遍历所有记录，fn返回false或错误时停止
+// 只在获取文件长度时持有锁，遍历期间追加的记录不会被读到，慢速的读取方不会阻塞写入
+func (f *jsonlFile) scan(fn func(line []byte) (bool, error)) error {
+	f.mutex.Lock()
+	file, err := os.Open(f.filePath)
+	var size int64
+	if err == nil {
+		var info os.FileInfo
+		if info, err = file.Stat(); err == nil {
+			size = info.Size()
+		} else {
+			file.Close()
+		}
+	}
+	f.mutex.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	return scanLines(io.LimitReader(file, size), fn)
+}
+
+// retain 重写文件，只保留keep返回true的记录，返回删除的记录数
+// 重写期间持有锁，新记录在重写完成后追加；先写入临时文件再替换，失败时原文件保持不变
+func (f *jsonlFile) retain(keep func(line []byte) (bool, error)) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.Open(f.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.filePath), filepath.Base(f.filePath)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := bufio.NewWriter(tmp)
+	removed := 0
+	err = scanLines(file, func(line []byte) (bool, error) {
+		kept, err := keep(line)
+		if err != nil {
+			return false, err
+		}
+		if !kept {
+			removed++
+			return true, nil
+		}
+		if _, err := writer.Write(line); err != nil {
+			return false, err
+		}
+		return true, writer.WriteByte('\n')
+	})
+	if err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), f.filePath); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// scanLines 逐行读取，跳过空行
+func scanLines(r io.Reader, fn func(line []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), jsonlMaxLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		more, err := fn(line)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestLedgerStorageReadsLongEntries(t *testing.T) {
+	s, err := NewLedgerStorage(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过bufio.Scanner默认64KB缓冲区的记录
+	notes := []string{"short", strings.Repeat("x", 200*1024), "last"}
+	for i, note := range notes {
+		if err := s.Append(&models.LedgerEntry{ID: string(rune('a' + i)), UserID: "u1", Note: note}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append(&models.LedgerEntry{ID: "other", UserID: "u2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.ListByUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(notes) {
+		t.Fatalf("entries = %d, want %d", len(entries), len(notes))
+	}
+	for i, entry := range entries {
+		if want := notes[len(notes)-1-i]; entry.Note != want {
+			t.Errorf("entry %d note length = %d, want %d", i, len(entry.Note), len(want))
+		}
+	}
+}
+
+func TestSubscriptionLogPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscription_access.jsonl")
+	s, err := NewSubscriptionLogStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	tests := []struct {
+		ip   string
+		at   time.Time
+		kept bool
+	}{
+		{"10.0.0.1", now.Add(-72 * time.Hour), false},
+		{"10.0.0.2", cutoff.Add(-time.Second), false},
+		{"10.0.0.3", cutoff, true},
+		{"10.0.0.4", now, true},
+	}
+	for _, tt := range tests {
+		if err := s.Append(&models.SubscriptionAccess{Timestamp: tt.at, UserID: "u1", IP: tt.ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := s.Prune(cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed = %d, want 2", removed)
+	}
+
+	var kept []string
+	if err := s.Scan(func(access *models.SubscriptionAccess) bool {
+		kept = append(kept, access.IP)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, tt := range tests {
+		if tt.kept {
+			want = append(want, tt.ip)
+		}
+	}
+	if strings.Join(kept, ",") != strings.Join(want, ",") {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+
+	// 没有过期记录时不重写文件，也不留下临时文件
+	if removed, err := s.Prune(cutoff); err != nil || removed != 0 {
+		t.Errorf("second prune = %d, %v", removed, err)
+	}
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("files = %v, want only the log", files)
+	}
+}
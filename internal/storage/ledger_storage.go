@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sing-box-manager/internal/models"
+)
+
+// LedgerStorage 续期和充值记录存储，以JSON Lines格式只追加写入
+type LedgerStorage struct {
+	file *jsonlFile
+}
+
+// NewLedgerStorage 创建续期和充值记录存储实例
+func NewLedgerStorage(filePath string) (*LedgerStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ledger directory: %v", err)
+	}
+
+	return &LedgerStorage{
+		file: newJSONLFile(filePath, true),
+	}, nil
+}
+
+// Append 追加一条记录，写入后同步到磁盘，返回时记录已持久化
+func (s *LedgerStorage) Append(entry *models.LedgerEntry) error {
+	return s.file.append(entry)
+}
+
+// ListByUser 按时间倒序返回用户的记录
+func (s *LedgerStorage) ListByUser(userID string) ([]*models.LedgerEntry, error) {
+	entries := make([]*models.LedgerEntry, 0)
+	err := s.file.scan(func(line []byte) (bool, error) {
+		var entry models.LedgerEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return false, fmt.Errorf("corrupted ledger entry: %v", err)
+		}
+		if entry.UserID == userID {
+			entries = append(entries, &entry)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件按时间顺序追加，反转后最新的在前
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// NodeStorage 边缘节点存储
+type NodeStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	nodes    map[string]*models.Node
+}
+
+// NewNodeStorage 创建节点存储实例
+func NewNodeStorage(filePath string) (*NodeStorage, error) {
+	storage := &NodeStorage{
+		filePath: filePath,
+		nodes:    make(map[string]*models.Node),
+	}
+
+	if err := readJSONFile(filePath, &storage.nodes); err != nil {
+		return nil, fmt.Errorf("failed to load nodes: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *NodeStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.nodes)
+}
+
+// CreateNode 创建节点，节点名称不可重复
+func (s *NodeStorage) CreateNode(node *models.Node) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.nodes[node.ID]; exists {
+		return fmt.Errorf("node with ID %s already exists", node.ID)
+	}
+	for _, existing := range s.nodes {
+		if existing.Name == node.Name {
+			return fmt.Errorf("node name %s already exists", node.Name)
+		}
+	}
+
+	s.nodes[node.ID] = node
+	return s.saveToFile()
+}
+
+// GetNode 获取节点
+func (s *NodeStorage) GetNode(id string) (*models.Node, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	node, exists := s.nodes[id]
+	if !exists {
+		return nil, fmt.Errorf("node with ID %s not found", id)
+	}
+
+	return node, nil
+}
+
+// GetNodeByTokenHash 根据令牌哈希查找节点
+func (s *NodeStorage) GetNodeByTokenHash(tokenHash string) (*models.Node, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, node := range s.nodes {
+		if node.TokenHash == tokenHash {
+			return node, nil
+		}
+	}
+
+	return nil, fmt.Errorf("node not found")
+}
+
+// ModifyNode 在持有锁时复制节点并由update修改，保存成功后替换原记录，返回修改后的节点
+// 已返回给调用方的节点不会被修改；update返回错误、名称重复或保存失败时不做任何修改
+func (s *NodeStorage) ModifyNode(id string, update func(node *models.Node) error) (*models.Node, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, exists := s.nodes[id]
+	if !exists {
+		return nil, fmt.Errorf("node with ID %s not found", id)
+	}
+
+	node := *existing
+	if err := update(&node); err != nil {
+		return nil, err
+	}
+	for _, other := range s.nodes {
+		if other.ID != id && other.Name == node.Name {
+			return nil, fmt.Errorf("node name %s already exists", node.Name)
+		}
+	}
+
+	s.nodes[id] = &node
+	if err := s.saveToFile(); err != nil {
+		s.nodes[id] = existing
+		return nil, err
+	}
+	return &node, nil
+}
+
+// TouchNode 记录节点最近一次同步时间
+func (s *NodeStorage) TouchNode(id string, at time.Time) error {
+	_, err := s.ModifyNode(id, func(node *models.Node) error {
+		node.LastSyncAt = &at
+		return nil
+	})
+	return err
+}
+
+// RecordHeartbeat 记录节点心跳时间和运行状态
+func (s *NodeStorage) RecordHeartbeat(id string, status *models.NodeStatus, at time.Time) error {
+	_, err := s.ModifyNode(id, func(node *models.Node) error {
+		node.LastSeenAt = &at
+		node.Status = status
+		return nil
+	})
+	return err
+}
+
+// DeleteNode 删除节点
+func (s *NodeStorage) DeleteNode(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.nodes[id]; !exists {
+		return fmt.Errorf("node with ID %s not found", id)
+	}
+
+	delete(s.nodes, id)
+	return s.saveToFile()
+}
+
+// ListNodes 列出所有节点
+func (s *NodeStorage) ListNodes() ([]*models.Node, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	nodes := make([]*models.Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+func TestModifyNode(t *testing.T) {
+	s, err := NewNodeStorage(filepath.Join(t.TempDir(), "nodes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []*models.Node{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}} {
+		if err := s.CreateNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := s.GetNode("a")
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		id      string
+		update  func(node *models.Node) error
+		wantErr bool
+	}{
+		{"heartbeat", "a", func(node *models.Node) error { node.LastSeenAt = &now; return nil }, false},
+		{"rename", "a", func(node *models.Node) error { node.Name = "renamed"; return nil }, false},
+		{"duplicate name", "a", func(node *models.Node) error { node.Name = "b"; return nil }, true},
+		{"update error", "a", func(node *models.Node) error { node.Name = "lost"; return errors.New("rejected") }, true},
+		{"unknown node", "missing", func(node *models.Node) error { return nil }, true},
+	}
+	for _, tt := range tests {
+		if _, err := s.ModifyNode(tt.id, tt.update); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+
+	after, _ := s.GetNode("a")
+	if after.Name != "renamed" || after.LastSeenAt == nil {
+		t.Errorf("node = %+v", after)
+	}
+	// 已返回给调用方的节点不会被修改
+	if before.Name != "a" || before.LastSeenAt != nil {
+		t.Errorf("previously returned node was mutated: %+v", before)
+	}
+
+	// 保存失败时不做任何修改
+	if err := os.Remove(s.filePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.filePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TouchNode("a", now); err == nil {
+		t.Fatal("save into a directory succeeded")
+	}
+	if current, _ := s.GetNode("a"); current != after {
+		t.Error("node replaced after failed save")
+	}
+}
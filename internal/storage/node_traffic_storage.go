@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// maxRecentReports 每个节点记录的最近上报ID数量，agent只会重发最近一份未确认的上报
+const maxRecentReports = 32
+
+// nodeTrafficState 节点流量文件内容
+type nodeTrafficState struct {
+	// 节点ID -> 用户ID -> 字节数
+	Traffic map[string]map[string]int64 `json:"traffic"`
+	// 节点ID -> 最近已处理的上报ID，按处理顺序排列
+	Reports map[string][]string `json:"reports"`
+	// 节点ID -> 用户ID -> 最近一次上报流量的时间
+	LastActive map[string]map[string]time.Time `json:"last_active,omitempty"`
+}
+
+// NodeTrafficStorage 各节点上每个用户的累计流量
+type NodeTrafficStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	state    nodeTrafficState
+}
+
+// NewNodeTrafficStorage 创建节点流量存储实例
+func NewNodeTrafficStorage(filePath string) (*NodeTrafficStorage, error) {
+	storage := &NodeTrafficStorage{
+		filePath: filePath,
+		state: nodeTrafficState{
+			Traffic:    make(map[string]map[string]int64),
+			Reports:    make(map[string][]string),
+			LastActive: make(map[string]map[string]time.Time),
+		},
+	}
+
+	if err := storage.load(); err != nil {
+		return nil, fmt.Errorf("failed to load node traffic: %v", err)
+	}
+
+	return storage, nil
+}
+
+// load 加载数据，兼容只保存了节点流量映射的旧格式
+func (s *NodeTrafficStorage) load() error {
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s.saveToFile()
+		}
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if _, ok := raw["traffic"]; !ok {
+		return json.Unmarshal(data, &s.state.Traffic)
+	}
+
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return err
+	}
+	if s.state.Traffic == nil {
+		s.state.Traffic = make(map[string]map[string]int64)
+	}
+	if s.state.Reports == nil {
+		s.state.Reports = make(map[string][]string)
+	}
+	if s.state.LastActive == nil {
+		s.state.LastActive = make(map[string]map[string]time.Time)
+	}
+	return nil
+}
+
+// saveToFile 保存数据到文件
+func (s *NodeTrafficStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.state)
+}
+
+// ApplyReport 累加节点上各用户的流量并记录上报ID，reportID已处理过时不做修改并返回true
+// reportID为空表示旧版本agent的上报，不做去重；有流量的用户记为此时活跃，撤销上报时不恢复
+func (s *NodeTrafficStorage) ApplyReport(nodeID, reportID string, usage map[string]int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reports := s.state.Reports[nodeID]
+	if reportID != "" {
+		for _, id := range reports {
+			if id == reportID {
+				return true, nil
+			}
+		}
+	}
+
+	users, exists := s.state.Traffic[nodeID]
+	if !exists {
+		users = make(map[string]int64)
+		s.state.Traffic[nodeID] = users
+	}
+	active, exists := s.state.LastActive[nodeID]
+	if !exists {
+		active = make(map[string]time.Time)
+		s.state.LastActive[nodeID] = active
+	}
+	now := time.Now()
+	for userID, bytesUsed := range usage {
+		users[userID] += bytesUsed
+		if bytesUsed > 0 {
+			active[userID] = now
+		}
+	}
+	if reportID != "" {
+		reports = append(reports, reportID)
+		if len(reports) > maxRecentReports {
+			reports = reports[len(reports)-maxRecentReports:]
+		}
+		s.state.Reports[nodeID] = reports
+	}
+
+	if err := s.saveToFile(); err != nil {
+		s.revert(nodeID, reportID, usage)
+		return false, err
+	}
+	return false, nil
+}
+
+// RevertReport 撤销ApplyReport记录的流量和上报ID，用户流量未能写入时调用，使agent重发时能重新处理
+func (s *NodeTrafficStorage) RevertReport(nodeID, reportID string, usage map[string]int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.revert(nodeID, reportID, usage)
+	return s.saveToFile()
+}
+
+// revert 在内存中撤销一份上报，调用方需持有锁
+func (s *NodeTrafficStorage) revert(nodeID, reportID string, usage map[string]int64) {
+	if users, exists := s.state.Traffic[nodeID]; exists {
+		for userID, bytesUsed := range usage {
+			users[userID] -= bytesUsed
+			if users[userID] <= 0 {
+				delete(users, userID)
+			}
+		}
+	}
+
+	if reportID == "" {
+		return
+	}
+	reports := s.state.Reports[nodeID]
+	for i, id := range reports {
+		if id == reportID {
+			s.state.Reports[nodeID] = append(reports[:i:i], reports[i+1:]...)
+			break
+		}
+	}
+}
+
+// NodeTraffic 返回节点上各用户的累计流量和最近活跃时间
+func (s *NodeTrafficStorage) NodeTraffic(nodeID string) []models.NodeUserTraffic {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	traffic := make([]models.NodeUserTraffic, 0, len(s.state.Traffic[nodeID]))
+	for userID, bytesUsed := range s.state.Traffic[nodeID] {
+		entry := models.NodeUserTraffic{UserID: userID, BytesUsed: bytesUsed}
+		if at, ok := s.state.LastActive[nodeID][userID]; ok {
+			entry.LastActiveAt = &at
+		}
+		traffic = append(traffic, entry)
+	}
+	return traffic
+}
+
+// DeleteNode 删除节点的流量记录
+func (s *NodeTrafficStorage) DeleteNode(nodeID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, hasTraffic := s.state.Traffic[nodeID]
+	_, hasReports := s.state.Reports[nodeID]
+	_, hasActive := s.state.LastActive[nodeID]
+	if !hasTraffic && !hasReports && !hasActive {
+		return nil
+	}
+
+	delete(s.state.Traffic, nodeID)
+	delete(s.state.Reports, nodeID)
+	delete(s.state.LastActive, nodeID)
+	return s.saveToFile()
+}
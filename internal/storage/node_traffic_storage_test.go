@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNodeTrafficApplyReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node_traffic.json")
+	s, err := NewNodeTrafficStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		reportID  string
+		usage     map[string]int64
+		duplicate bool
+		want      int64
+	}{
+		{"first report", "r1", map[string]int64{"u": 100}, false, 100},
+		{"resent report", "r1", map[string]int64{"u": 100}, true, 100},
+		{"next report", "r2", map[string]int64{"u": 50}, false, 150},
+		{"legacy report without id", "", map[string]int64{"u": 1}, false, 151},
+		{"legacy report is never deduplicated", "", map[string]int64{"u": 1}, false, 152},
+	}
+	for _, tt := range tests {
+		duplicate, err := s.ApplyReport("n", tt.reportID, tt.usage)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if duplicate != tt.duplicate {
+			t.Errorf("%s: duplicate = %v, want %v", tt.name, duplicate, tt.duplicate)
+		}
+		if got := s.NodeTraffic("n")[0].BytesUsed; got != tt.want {
+			t.Errorf("%s: traffic = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// 撤销后同一上报可以重新处理
+	if err := s.RevertReport("n", "r2", map[string]int64{"u": 50}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.NodeTraffic("n")[0].BytesUsed; got != 102 {
+		t.Fatalf("traffic after revert = %d, want 102", got)
+	}
+	if duplicate, _ := s.ApplyReport("n", "r2", map[string]int64{"u": 50}); duplicate {
+		t.Fatal("reverted report was treated as duplicate")
+	}
+
+	// 上报ID在重启后仍然有效
+	reloaded, err := NewNodeTrafficStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duplicate, _ := reloaded.ApplyReport("n", "r1", map[string]int64{"u": 100}); !duplicate {
+		t.Fatal("report id was not persisted")
+	}
+}
+
+func TestNodeTrafficRecentReportsAreBounded(t *testing.T) {
+	s, err := NewNodeTrafficStorage(filepath.Join(t.TempDir(), "node_traffic.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRecentReports+10; i++ {
+		if _, err := s.ApplyReport("n", string(rune('a'+i)), map[string]int64{"u": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(s.state.Reports["n"]); got != maxRecentReports {
+		t.Fatalf("recent reports = %d, want %d", got, maxRecentReports)
+	}
+}
+
+func TestNodeTrafficLoadsLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node_traffic.json")
+	if err := os.WriteFile(path, []byte(`{"node-1":{"user-1":42}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewNodeTrafficStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic := s.NodeTraffic("node-1")
+	if len(traffic) != 1 || traffic[0].UserID != "user-1" || traffic[0].BytesUsed != 42 {
+		t.Fatalf("traffic = %+v", traffic)
+	}
+}
+
+func TestNodeTrafficLastActive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node_traffic.json")
+	s, err := NewNodeTrafficStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ApplyReport("n", "r1", map[string]int64{"active": 10, "idle": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewNodeTrafficStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		userID string
+		active bool
+	}{
+		{"active", true},
+		{"idle", false},
+	}
+	traffic := make(map[string]bool)
+	for _, entry := range reloaded.NodeTraffic("n") {
+		traffic[entry.UserID] = entry.LastActiveAt != nil
+	}
+	for _, tt := range tests {
+		if traffic[tt.userID] != tt.active {
+			t.Errorf("%s: active = %v, want %v", tt.userID, traffic[tt.userID], tt.active)
+		}
+	}
+
+	if err := reloaded.DeleteNode("n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := reloaded.state.LastActive["n"]; exists {
+		t.Error("last active times kept after deleting node")
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"sing-box-manager/internal/models"
+)
+
+// PlanStorage 套餐模板存储
+type PlanStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	plans    map[string]*models.Plan
+}
+
+// NewPlanStorage 创建套餐存储实例
+func NewPlanStorage(filePath string) (*PlanStorage, error) {
+	storage := &PlanStorage{
+		filePath: filePath,
+		plans:    make(map[string]*models.Plan),
+	}
+
+	if err := readJSONFile(filePath, &storage.plans); err != nil {
+		return nil, fmt.Errorf("failed to load plans: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *PlanStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.plans)
+}
+
+// CreatePlan 创建套餐，套餐名称不可重复
+func (s *PlanStorage) CreatePlan(plan *models.Plan) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.plans[plan.ID]; exists {
+		return fmt.Errorf("plan with ID %s already exists", plan.ID)
+	}
+	for _, existing := range s.plans {
+		if existing.Name == plan.Name {
+			return fmt.Errorf("plan name %s already exists", plan.Name)
+		}
+	}
+
+	s.plans[plan.ID] = plan
+	return s.saveToFile()
+}
+
+// GetPlan 获取套餐
+func (s *PlanStorage) GetPlan(id string) (*models.Plan, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	plan, exists := s.plans[id]
+	if !exists {
+		return nil, fmt.Errorf("plan with ID %s not found", id)
+	}
+
+	return plan, nil
+}
+
+// UpdatePlan 更新套餐
+func (s *PlanStorage) UpdatePlan(id string, plan *models.Plan) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.plans[id]; !exists {
+		return fmt.Errorf("plan with ID %s not found", id)
+	}
+	for _, existing := range s.plans {
+		if existing.ID != id && existing.Name == plan.Name {
+			return fmt.Errorf("plan name %s already exists", plan.Name)
+		}
+	}
+
+	s.plans[id] = plan
+	return s.saveToFile()
+}
+
+// DeletePlan 删除套餐
+func (s *PlanStorage) DeletePlan(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.plans[id]; !exists {
+		return fmt.Errorf("plan with ID %s not found", id)
+	}
+
+	delete(s.plans, id)
+	return s.saveToFile()
+}
+
+// ListPlans 列出所有套餐，按名称排序
+func (s *PlanStorage) ListPlans() ([]*models.Plan, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	plans := make([]*models.Plan, 0, len(s.plans))
+	for _, plan := range s.plans {
+		plans = append(plans, plan)
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Name < plans[j].Name
+	})
+
+	return plans, nil
+}
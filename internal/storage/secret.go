@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadOrCreateSecret 从文件读取十六进制编码的密钥，文件不存在时生成size字节的随机密钥并保存
+func LoadOrCreateSecret(filePath string, size int) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret in %s: %v", filePath, err)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filePath, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
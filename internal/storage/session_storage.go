@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// SessionStorage 登录会话存储
+type SessionStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	sessions map[string]*models.Session
+}
+
+// NewSessionStorage 创建会话存储实例
+func NewSessionStorage(filePath string) (*SessionStorage, error) {
+	storage := &SessionStorage{
+		filePath: filePath,
+		sessions: make(map[string]*models.Session),
+	}
+
+	if err := readJSONFile(filePath, &storage.sessions); err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *SessionStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.sessions)
+}
+
+// CreateSession 创建会话，同时清理已过期的会话
+func (s *SessionStorage) CreateSession(session *models.Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if now.After(existing.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sessions[session.ID] = session
+	return s.saveToFile()
+}
+
+// GetSession 获取会话
+func (s *SessionStorage) GetSession(id string) (*models.Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, fmt.Errorf("session with ID %s not found", id)
+	}
+
+	return session, nil
+}
+
+// RotateRefreshToken 校验旧的刷新令牌哈希并替换为新的，保证同一令牌只能使用一次
+func (s *SessionStorage) RotateRefreshToken(id, oldHash, newHash string, expiresAt time.Time) (*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists || session.RefreshHash != oldHash {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if !session.IsValid() {
+		return nil, fmt.Errorf("session has expired or been revoked")
+	}
+
+	session.RefreshHash = newHash
+	session.ExpiresAt = expiresAt
+	return session, s.saveToFile()
+}
+
+// RevokeSession 吊销会话
+func (s *SessionStorage) RevokeSession(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return fmt.Errorf("session with ID %s not found", id)
+	}
+
+	session.Revoked = true
+	return s.saveToFile()
+}
+
+// RevokeAdminSessions 吊销指定管理员的所有会话
+func (s *SessionStorage) RevokeAdminSessions(adminID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, session := range s.sessions {
+		if session.AdminID == adminID {
+			session.Revoked = true
+		}
+	}
+
+	return s.saveToFile()
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sing-box-manager/internal/models"
+)
+
+// SubscriptionLogStorage 订阅拉取日志存储，以JSON Lines格式只追加写入
+type SubscriptionLogStorage struct {
+	file *jsonlFile
+}
+
+// NewSubscriptionLogStorage 创建订阅拉取日志存储实例
+func NewSubscriptionLogStorage(filePath string) (*SubscriptionLogStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create subscription log directory: %v", err)
+	}
+
+	// 拉取记录只用于排查和统计，不需要每次写入都同步到磁盘
+	return &SubscriptionLogStorage{
+		file: newJSONLFile(filePath, false),
+	}, nil
+}
+
+// Append 追加一条拉取记录
+func (s *SubscriptionLogStorage) Append(access *models.SubscriptionAccess) error {
+	return s.file.append(access)
+}
+
+// Scan 按写入顺序遍历所有拉取记录，fn返回false时停止
+func (s *SubscriptionLogStorage) Scan(fn func(access *models.SubscriptionAccess) bool) error {
+	return s.file.scan(func(line []byte) (bool, error) {
+		var access models.SubscriptionAccess
+		if err := json.Unmarshal(line, &access); err != nil {
+			return false, fmt.Errorf("corrupted subscription log entry: %v", err)
+		}
+		return fn(&access), nil
+	})
+}
+
+// Prune 删除早于before的拉取记录，返回删除的记录数
+func (s *SubscriptionLogStorage) Prune(before time.Time) (int, error) {
+	return s.file.retain(func(line []byte) (bool, error) {
+		var access models.SubscriptionAccess
+		if err := json.Unmarshal(line, &access); err != nil {
+			return false, fmt.Errorf("corrupted subscription log entry: %v", err)
+		}
+		return !access.Timestamp.Before(before), nil
+	})
+}
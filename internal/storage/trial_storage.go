@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"sing-box-manager/internal/models"
+)
+
+// TrialStorage 试用开通记录存储，按邮箱索引
+type TrialStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	trials   map[string]*models.TrialRecord
+}
+
+// NewTrialStorage 创建试用记录存储实例
+func NewTrialStorage(filePath string) (*TrialStorage, error) {
+	storage := &TrialStorage{
+		filePath: filePath,
+		trials:   make(map[string]*models.TrialRecord),
+	}
+
+	if err := readJSONFile(filePath, &storage.trials); err != nil {
+		return nil, fmt.Errorf("failed to load trials: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *TrialStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.trials)
+}
+
+// Reserve 原子地占用邮箱的试用名额，check在持有锁时根据已有记录检查是否允许开通，返回错误时不做记录
+func (s *TrialStorage) Reserve(record *models.TrialRecord, check func(trials []*models.TrialRecord) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	trials := make([]*models.TrialRecord, 0, len(s.trials))
+	for _, trial := range s.trials {
+		trials = append(trials, trial)
+	}
+	if err := check(trials); err != nil {
+		return err
+	}
+	if _, exists := s.trials[record.Email]; exists {
+		return fmt.Errorf("trial for %s already exists", record.Email)
+	}
+
+	s.trials[record.Email] = record
+	if err := s.saveToFile(); err != nil {
+		delete(s.trials, record.Email)
+		return err
+	}
+	return nil
+}
+
+// SetUserID 记录试用开通的用户
+func (s *TrialStorage) SetUserID(email, userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	trial, exists := s.trials[email]
+	if !exists {
+		return fmt.Errorf("trial for %s not found", email)
+	}
+
+	updated := *trial
+	updated.UserID = userID
+	s.trials[email] = &updated
+	return s.saveToFile()
+}
+
+// Release 删除试用记录，用户未能开通时释放名额
+func (s *TrialStorage) Release(email string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.trials[email]; !exists {
+		return fmt.Errorf("trial for %s not found", email)
+	}
+
+	delete(s.trials, email)
+	return s.saveToFile()
+}
+
+// ListTrials 列出所有试用记录，按开通时间倒序
+func (s *TrialStorage) ListTrials() ([]*models.TrialRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	trials := make([]*models.TrialRecord, 0, len(s.trials))
+	for _, trial := range s.trials {
+		trials = append(trials, trial)
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].CreatedAt.After(trials[j].CreatedAt)
+	})
+
+	return trials, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"sing-box-manager/internal/models"
+)
+
+// VoucherStorage 兑换码存储
+// 兑换码以副本替换的方式修改，已返回给调用方的兑换码不会被并发修改
+type VoucherStorage struct {
+	filePath string
+	mutex    sync.RWMutex
+	vouchers map[string]*models.Voucher
+}
+
+// NewVoucherStorage 创建兑换码存储实例
+func NewVoucherStorage(filePath string) (*VoucherStorage, error) {
+	storage := &VoucherStorage{
+		filePath: filePath,
+		vouchers: make(map[string]*models.Voucher),
+	}
+
+	if err := readJSONFile(filePath, &storage.vouchers); err != nil {
+		return nil, fmt.Errorf("failed to load vouchers: %v", err)
+	}
+
+	return storage, nil
+}
+
+// saveToFile 保存数据到文件
+func (s *VoucherStorage) saveToFile() error {
+	return writeJSONFile(s.filePath, s.vouchers)
+}
+
+// CreateVouchers 批量创建兑换码，任一兑换码已存在时全部不创建
+func (s *VoucherStorage) CreateVouchers(vouchers []*models.Voucher) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, voucher := range vouchers {
+		if _, exists := s.vouchers[voucher.Code]; exists {
+			return fmt.Errorf("voucher %s already exists", voucher.Code)
+		}
+	}
+
+	for _, voucher := range vouchers {
+		s.vouchers[voucher.Code] = voucher
+	}
+	if err := s.saveToFile(); err != nil {
+		for _, voucher := range vouchers {
+			delete(s.vouchers, voucher.Code)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetVoucher 获取兑换码
+func (s *VoucherStorage) GetVoucher(code string) (*models.Voucher, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	voucher, exists := s.vouchers[code]
+	if !exists {
+		return nil, fmt.Errorf("voucher %s not found", code)
+	}
+
+	return voucher, nil
+}
+
+// ListVouchers 列出满足条件的兑换码，按创建时间倒序
+func (s *VoucherStorage) ListVouchers(match func(voucher *models.Voucher) bool) ([]*models.Voucher, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vouchers := make([]*models.Voucher, 0)
+	for _, voucher := range s.vouchers {
+		if match(voucher) {
+			vouchers = append(vouchers, voucher)
+		}
+	}
+	sort.Slice(vouchers, func(i, j int) bool {
+		if !vouchers[i].CreatedAt.Equal(vouchers[j].CreatedAt) {
+			return vouchers[i].CreatedAt.After(vouchers[j].CreatedAt)
+		}
+		return vouchers[i].Code < vouchers[j].Code
+	})
+
+	return vouchers, nil
+}
+
+// Redeem 原子地记录一次兑换，check在持有锁时检查兑换码是否可兑换，返回错误时不做记录
+// 检查与记录之间不会有其他兑换，避免同一兑换码被超额兑换
+func (s *VoucherStorage) Redeem(code string, redemption models.VoucherRedemption, check func(voucher *models.Voucher) error) (*models.Voucher, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	voucher, exists := s.vouchers[code]
+	if !exists {
+		return nil, fmt.Errorf("voucher %s not found", code)
+	}
+	if err := check(voucher); err != nil {
+		return nil, err
+	}
+
+	updated := *voucher
+	updated.Redemptions = make([]models.VoucherRedemption, len(voucher.Redemptions), len(voucher.Redemptions)+1)
+	copy(updated.Redemptions, voucher.Redemptions)
+	updated.Redemptions = append(updated.Redemptions, redemption)
+
+	s.vouchers[code] = &updated
+	if err := s.saveToFile(); err != nil {
+		s.vouchers[code] = voucher
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// CancelRedemption 撤销用户的兑换记录，兑换内容未能应用到用户时调用
+func (s *VoucherStorage) CancelRedemption(code, userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	voucher, exists := s.vouchers[code]
+	if !exists {
+		return fmt.Errorf("voucher %s not found", code)
+	}
+
+	updated := *voucher
+	updated.Redemptions = make([]models.VoucherRedemption, 0, len(voucher.Redemptions))
+	for _, redemption := range voucher.Redemptions {
+		if redemption.UserID != userID {
+			updated.Redemptions = append(updated.Redemptions, redemption)
+		}
+	}
+
+	s.vouchers[code] = &updated
+	return s.saveToFile()
+}
+
+// DisableVoucher 停用兑换码，已兑换的内容不受影响
+func (s *VoucherStorage) DisableVoucher(code string) (*models.Voucher, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	voucher, exists := s.vouchers[code]
+	if !exists {
+		return nil, fmt.Errorf("voucher %s not found", code)
+	}
+
+	updated := *voucher
+	updated.Disabled = true
+	s.vouchers[code] = &updated
+	if err := s.saveToFile(); err != nil {
+		s.vouchers[code] = voucher
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -2,6 +2,7 @@
 
 # API测试脚本
 BASE_URL="http://localhost:8080"
+API_KEY="${API_KEY:?请设置API_KEY环境变量}"
 
 echo "🚀 开始测试Sing-box Manager API"
 echo "================================"
@@ -21,7 +22,7 @@ USER_DATA='{
   "device_limit": 3
 }'
 
-RESPONSE=$(curl -s -X POST "$BASE_URL/api/users" \
+RESPONSE=$(curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users" \
   -H "Content-Type: application/json" \
   -d "$USER_DATA")
 
@@ -32,43 +33,43 @@ echo -e "\n"
 
 # 3. 获取用户列表
 echo "3️⃣ 获取用户列表"
-curl -s "$BASE_URL/api/users" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/users" | jq
 echo -e "\n"
 
 # 4. 获取单个用户
 echo "4️⃣ 获取单个用户"
-curl -s "$BASE_URL/api/users/$USER_ID" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/users/$USER_ID" | jq
 echo -e "\n"
 
 # 5. 连接设备
 echo "5️⃣ 连接设备"
-curl -s -X POST "$BASE_URL/api/users/$USER_ID/connect" \
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users/$USER_ID/connect" \
   -H "Content-Type: application/json" \
   -d '{"device_id": "device-001"}' | jq
 echo -e "\n"
 
 # 6. 更新流量使用
 echo "6️⃣ 更新流量使用"
-curl -s -X POST "$BASE_URL/api/users/$USER_ID/traffic" \
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users/$USER_ID/traffic" \
   -H "Content-Type: application/json" \
   -d '{"bytes_used": 1048576}' | jq
 echo -e "\n"
 
 # 7. 获取用户统计
 echo "7️⃣ 获取用户统计"
-curl -s "$BASE_URL/api/users/$USER_ID/stats" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/users/$USER_ID/stats" | jq
 echo -e "\n"
 
 # 8. 更新用户
 echo "8️⃣ 更新用户"
-curl -s -X PUT "$BASE_URL/api/users/$USER_ID" \
+curl -s -H "X-API-Key: $API_KEY" -X PUT "$BASE_URL/api/users/$USER_ID" \
   -H "Content-Type: application/json" \
   -d '{"traffic_limit": 21474836480}' | jq
 echo -e "\n"
 
 # 9. 根据用户名获取用户
 echo "9️⃣ 根据用户名获取用户"
-curl -s "$BASE_URL/api/users/username/testuser" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/users/username/testuser" | jq
 echo -e "\n"
 
 echo "✅ API测试完成！"
\ No newline at end of file
@@ -2,6 +2,7 @@
 
 # 集成测试脚本
 BASE_URL="http://localhost:8080"
+API_KEY="${API_KEY:?请设置API_KEY环境变量}"
 
 echo "🚀 测试Sing-box集成管理系统"
 echo "================================"
@@ -13,7 +14,7 @@ echo -e "\n"
 
 # 2. 配置状态检查
 echo "2️⃣ 配置服务状态"
-curl -s "$BASE_URL/api/config/status" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/config/status" | jq
 echo -e "\n"
 
 # 3. 创建用户
@@ -26,7 +27,7 @@ USER_DATA='{
   "device_limit": 5
 }'
 
-RESPONSE=$(curl -s -X POST "$BASE_URL/api/users" \
+RESPONSE=$(curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users" \
   -H "Content-Type: application/json" \
   -d "$USER_DATA")
 
@@ -37,29 +38,29 @@ echo -e "\n"
 
 # 4. 生成sing-box配置
 echo "4️⃣ 生成sing-box配置"
-curl -s -X POST "$BASE_URL/api/config/generate" | jq
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/generate" | jq
 echo -e "\n"
 
 # 5. 重载配置
 echo "5️⃣ 重载sing-box配置"
-curl -s -X POST "$BASE_URL/api/config/reload" | jq
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/reload" | jq
 echo -e "\n"
 
 # 6. 获取用户统计
 echo "6️⃣ 用户统计信息"
-curl -s "$BASE_URL/api/users/$USER_ID/stats" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/users/$USER_ID/stats" | jq
 echo -e "\n"
 
 # 7. 连接设备测试
 echo "7️⃣ 设备连接测试"
-curl -s -X POST "$BASE_URL/api/users/$USER_ID/connect" \
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users/$USER_ID/connect" \
   -H "Content-Type: application/json" \
   -d '{"device_id": "iphone-001"}' | jq
 echo -e "\n"
 
 # 8. 再次生成配置(应该包含新用户)
 echo "8️⃣ 重新生成配置"
-curl -s -X POST "$BASE_URL/api/config/generate" | jq
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/generate" | jq
 echo -e "\n"
 
 # 9. 检查配置文件
@@ -2,18 +2,19 @@
 
 # Reality协议测试脚本
 BASE_URL="http://localhost:8080"
+API_KEY="${API_KEY:?请设置API_KEY环境变量}"
 
 echo "🚀 测试Reality协议功能"
 echo "================================"
 
 # 1. 配置服务状态
 echo "1️⃣ 检查Reality支持状态"
-curl -s "$BASE_URL/api/config/status" | jq
+curl -s -H "X-API-Key: $API_KEY" "$BASE_URL/api/config/status" | jq
 echo -e "\n"
 
 # 2. 生成Reality密钥对
 echo "2️⃣ 生成Reality密钥对"
-KEYPAIR_RESPONSE=$(curl -s -X POST "$BASE_URL/api/config/reality/keypair")
+KEYPAIR_RESPONSE=$(curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/reality/keypair")
 echo "$KEYPAIR_RESPONSE" | jq
 PRIVATE_KEY=$(echo "$KEYPAIR_RESPONSE" | jq -r '.keypair.private_key')
 PUBLIC_KEY=$(echo "$KEYPAIR_RESPONSE" | jq -r '.keypair.public_key')
@@ -31,7 +32,7 @@ USER_DATA='{
   "device_limit": 10
 }'
 
-RESPONSE=$(curl -s -X POST "$BASE_URL/api/users" \
+RESPONSE=$(curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/users" \
   -H "Content-Type: application/json" \
   -d "$USER_DATA")
 
@@ -42,12 +43,12 @@ echo -e "\n"
 
 # 4. 重新生成配置(包含Reality)
 echo "4️⃣ 生成包含Reality的配置"
-curl -s -X POST "$BASE_URL/api/config/generate" | jq
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/generate" | jq
 echo -e "\n"
 
 # 5. 重载配置
 echo "5️⃣ 重载sing-box配置"
-curl -s -X POST "$BASE_URL/api/config/reload" | jq
+curl -s -H "X-API-Key: $API_KEY" -X POST "$BASE_URL/api/config/reload" | jq
 echo -e "\n"
 
 # 6. 检查端口监听